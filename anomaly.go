@@ -0,0 +1,270 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	anomalyMeasurement = "Uniswap v2 Anomalies"
+
+	// circularTolerance is the relative difference in size under which two
+	// opposite swaps of the same trader are considered to cancel each other.
+	circularTolerance = 0.1
+)
+
+// AnomalyReport scores the swaps of a pair over one interval for signs of wash
+// trading and otherwise manipulated volume. All shares are relative to the
+// volume of the interval, measured in units of token0.
+type AnomalyReport struct {
+	Start         time.Time
+	Swaps         uint
+	Volume        float64
+	SelfShare     float64
+	CircularShare float64
+	Dominance     float64
+	Turnover      float64
+	Score         float64
+	Flags         []string
+}
+
+// Anomalies applies wash trading heuristics to the swaps of a pair:
+// self-trades where the executor is also the recipient, circular trades where
+// a trader reverses a swap of similar size within a short window, volume
+// dominated by a single trader, and volume that is large compared to the
+// liquidity of the pair.
+type Anomalies struct {
+	label     string
+	interval  time.Duration
+	window    time.Duration
+	dominance float64
+	turnover  float64
+
+	current *anomalyWindow
+	recent  []trade
+}
+
+type anomalyWindow struct {
+	start    time.Time
+	swaps    uint
+	volume   float64
+	self     float64
+	circular float64
+	traders  map[common.Address]float64
+	reserve0 float64
+}
+
+type trade struct {
+	timestamp time.Time
+	trader    common.Address
+	direction int
+	size      float64
+	matched   bool
+}
+
+func NewAnomalies(label string, window time.Duration, dominance float64, turnover float64) (*Anomalies, error) {
+
+	interval, err := parseInterval(label)
+	if err != nil {
+		return nil, err
+	}
+
+	a := Anomalies{
+		label:     label,
+		interval:  interval,
+		window:    window,
+		dominance: dominance,
+		turnover:  turnover,
+	}
+
+	return &a, nil
+}
+
+// Add includes the swaps of a pair at one block and the reserves after it, and
+// returns the report of the previous interval if the block starts a new one.
+func (a *Anomalies) Add(timestamp time.Time, swaps []SwapRecord, reserve0 *big.Int) []AnomalyReport {
+
+	var reports []AnomalyReport
+
+	start := timestamp.Truncate(a.interval)
+	if a.current != nil && a.current.start.Before(start) {
+		reports = append(reports, a.report())
+		a.current = nil
+	}
+	if a.current == nil {
+		a.current = &anomalyWindow{
+			start:   start,
+			traders: make(map[common.Address]float64),
+		}
+	}
+
+	cutoff := timestamp.Add(-a.window)
+	expired := 0
+	for _, previous := range a.recent {
+		if previous.timestamp.After(cutoff) {
+			break
+		}
+		expired++
+	}
+	a.recent = a.recent[expired:]
+
+	for _, swap := range swaps {
+
+		direction := swap.Direction()
+		if direction < 0 {
+			continue
+		}
+
+		// The size of a swap is its amount of token0, which is the input for
+		// one direction and the output for the other.
+		size := toFloat(swap.Swap.Amount0In)
+		if direction == 1 {
+			size = toFloat(swap.Swap.Amount0Out)
+		}
+
+		a.current.swaps++
+		a.current.volume += size
+		a.current.traders[swap.To] += size
+		if swap.Sender == swap.To {
+			a.current.self += size
+		}
+
+		current := trade{
+			timestamp: timestamp,
+			trader:    swap.To,
+			direction: direction,
+			size:      size,
+		}
+		for i := range a.recent {
+			previous := &a.recent[i]
+			if previous.matched || previous.trader != current.trader || previous.direction == current.direction {
+				continue
+			}
+			if math.Abs(previous.size-current.size) > circularTolerance*math.Max(previous.size, current.size) {
+				continue
+			}
+			previous.matched = true
+			current.matched = true
+			a.current.circular += current.size
+			if !previous.timestamp.Before(a.current.start) {
+				a.current.circular += previous.size
+			}
+			break
+		}
+		a.recent = append(a.recent, current)
+	}
+
+	if reserve0 != nil {
+		a.current.reserve0 = toFloat(reserve0)
+	}
+
+	return reports
+}
+
+// Current returns the report of the interval that is still being aggregated.
+func (a *Anomalies) Current() (AnomalyReport, bool) {
+	if a.current == nil {
+		return AnomalyReport{}, false
+	}
+	return a.report(), true
+}
+
+// report scores the current interval. Each heuristic yields a component
+// between zero and one, and the score is the probability that at least one of
+// them applies if they were independent.
+func (a *Anomalies) report() AnomalyReport {
+
+	w := a.current
+	r := AnomalyReport{
+		Start:  w.start,
+		Swaps:  w.swaps,
+		Volume: w.volume,
+	}
+	if w.volume == 0 {
+		return r
+	}
+
+	r.SelfShare = w.self / w.volume
+	r.CircularShare = math.Min(1, w.circular/w.volume)
+	for _, volume := range w.traders {
+		r.Dominance = math.Max(r.Dominance, volume/w.volume)
+	}
+	if w.reserve0 > 0 {
+		r.Turnover = w.volume / w.reserve0
+	}
+
+	dominance := 0.0
+	if r.Dominance > a.dominance && a.dominance < 1 {
+		dominance = (r.Dominance - a.dominance) / (1 - a.dominance)
+	}
+	turnover := 0.0
+	if r.Turnover > a.turnover {
+		turnover = math.Min(1, r.Turnover/a.turnover-1)
+	}
+
+	components := []struct {
+		flag  string
+		value float64
+	}{
+		{"self_trade", r.SelfShare},
+		{"circular", r.CircularShare},
+		{"dominance", dominance},
+		{"turnover", turnover},
+	}
+
+	clean := 1.0
+	for _, component := range components {
+		if component.value <= 0 {
+			continue
+		}
+		clean *= 1 - component.value
+		r.Flags = append(r.Flags, component.flag)
+	}
+	r.Score = 1 - clean
+
+	return r
+}
+
+// clone returns a copy of the detector that can be continued independently.
+func (a *Anomalies) clone() *Anomalies {
+	clone := *a
+	if a.current != nil {
+		current := *a.current
+		current.traders = make(map[common.Address]float64, len(a.current.traders))
+		for trader, volume := range a.current.traders {
+			current.traders[trader] = volume
+		}
+		clone.current = &current
+	}
+	clone.recent = append([]trade(nil), a.recent...)
+	return &clone
+}
+
+// Point converts the report into an InfluxDB datapoint for the anomaly
+// measurement.
+func (a *Anomalies) Point(chainName string, pool *Pool, report AnomalyReport) *write.Point {
+
+	tags := map[string]string{
+		"chain":        chainName,
+		"pair":         pool.Name,
+		"pair_address": pool.Address.Hex(),
+		"interval":     a.label,
+	}
+	fields := map[string]interface{}{
+		"swaps":          report.Swaps,
+		"self_share":     report.SelfShare,
+		"circular_share": report.CircularShare,
+		"dominance":      report.Dominance,
+		"turnover":       report.Turnover,
+		"score":          report.Score,
+		"flags":          strings.Join(report.Flags, ","),
+	}
+
+	return write.NewPoint(anomalyMeasurement, tags, fields, report.Start)
+}
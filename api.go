@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// errPageFull stops reading from the source once a page is complete.
+var errPageFull = errors.New("page full")
+
+// PairInfo is the metadata of a pair, as served by the query API.
+type PairInfo struct {
+	Address string    `json:"address"`
+	Name    string    `json:"name"`
+	Token0  TokenInfo `json:"token0"`
+	Token1  TokenInfo `json:"token1"`
+}
+
+// TokenInfo is the metadata of a token, as served by the query API.
+type TokenInfo struct {
+	Address  string `json:"address"`
+	Symbol   string `json:"symbol"`
+	Decimals uint8  `json:"decimals"`
+}
+
+// ReservesInfo is a datapoint of a pair, with the amounts adjusted for the
+// decimals of their token and the price of token0 in units of token1.
+type ReservesInfo struct {
+	Height    uint64    `json:"height"`
+	Timestamp time.Time `json:"timestamp"`
+	Reserve0  string    `json:"reserve0"`
+	Reserve1  string    `json:"reserve1"`
+	Price     float64   `json:"price"`
+	Volume0   string    `json:"volume0"`
+	Volume1   string    `json:"volume1"`
+	Fee0      string    `json:"fee0"`
+	Fee1      string    `json:"fee1"`
+}
+
+// CandleInfo is a candle of a pair, with the amounts adjusted for the decimals
+// of their token.
+type CandleInfo struct {
+	Start   time.Time `json:"start"`
+	Open    float64   `json:"open"`
+	High    float64   `json:"high"`
+	Low     float64   `json:"low"`
+	Close   float64   `json:"close"`
+	Volume0 string    `json:"volume0"`
+	Volume1 string    `json:"volume1"`
+	Fee0    string    `json:"fee0"`
+	Fee1    string    `json:"fee1"`
+}
+
+// Page is the body of the responses of the query API. Series are paginated by
+// time: when more items are available, the next page is requested with the
+// given value as the start time.
+type Page struct {
+	Data interface{} `json:"data"`
+	Next *time.Time  `json:"next,omitempty"`
+}
+
+// Server serves the data of the indexed pairs over HTTP, reading the series
+// from the configured storage:
+//
+//	/chains                          the chain of the miner
+//	/pairs                           the indexed pairs and their tokens
+//	/pairs/{address}/reserves        the datapoints of a pair
+//	/pairs/{address}/candles         the candles of a pair, for ?interval=
+//
+// Series accept the start and end time as ?from= and ?to=, either as RFC3339
+// timestamps or as Unix seconds, and the page size as ?limit=.
+type Server struct {
+	log       zerolog.Logger
+	chainName string
+	source    Source
+	pairs     []PairInfo
+	pools     map[common.Address]*Pool
+	mux       *http.ServeMux
+}
+
+func NewServer(log zerolog.Logger, chainName string, pools []*Pool, source Source) *Server {
+
+	s := Server{
+		log:       log.With().Str("component", "api").Logger(),
+		chainName: chainName,
+		source:    source,
+		pools:     make(map[common.Address]*Pool),
+		mux:       http.NewServeMux(),
+	}
+
+	// The metadata is copied, as the flags of the tokens can be updated by the
+	// miner while the server runs.
+	for _, pool := range pools {
+		s.pairs = append(s.pairs, PairInfo{
+			Address: pool.Address.Hex(),
+			Name:    pool.Name,
+			Token0:  newTokenInfo(pool.Token0),
+			Token1:  newTokenInfo(pool.Token1),
+		})
+		s.pools[pool.Address] = &Pool{
+			Address: pool.Address,
+			Name:    pool.Name,
+			Token0:  pool.Token0,
+			Token1:  pool.Token1,
+		}
+	}
+
+	s.mux.HandleFunc("/chains", get(s.chains))
+	s.mux.HandleFunc("/pairs", get(s.list))
+	s.mux.HandleFunc("/pairs/", get(s.pair))
+
+	return &s
+}
+
+// Listen serves the API on the given address in the background.
+func (s *Server) Listen(address string) {
+	go func() {
+		err := http.ListenAndServe(address, s)
+		if err != nil {
+			s.log.Error().Str("http_address", address).Err(err).Msg("could not serve query API")
+		}
+	}()
+}
+
+// Handle serves an additional API under the given path.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) chains(w http.ResponseWriter, r *http.Request) {
+	chains := []map[string]interface{}{
+		{"name": s.chainName, "pairs": len(s.pairs)},
+	}
+	s.respond(w, Page{Data: chains})
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	s.respond(w, Page{Data: s.pairs})
+}
+
+// pair routes the requests for the series of a single pair.
+func (s *Server) pair(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/pairs/"), "/")
+	if len(parts) != 2 || !common.IsHexAddress(parts[0]) {
+		s.fail(w, http.StatusNotFound, fmt.Errorf("unknown path (%s)", r.URL.Path))
+		return
+	}
+	pool, ok := s.pools[common.HexToAddress(parts[0])]
+	if !ok {
+		s.fail(w, http.StatusNotFound, fmt.Errorf("unknown pair (%s)", parts[0]))
+		return
+	}
+
+	switch parts[1] {
+	case "reserves":
+		s.reserves(w, r, pool)
+	case "candles":
+		s.candles(w, r, pool)
+	default:
+		s.fail(w, http.StatusNotFound, fmt.Errorf("unknown path (%s)", r.URL.Path))
+	}
+}
+
+func (s *Server) reserves(w http.ResponseWriter, r *http.Request, pool *Pool) {
+
+	start, end, limit, err := parseSeries(r)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page := Page{}
+	data := make([]ReservesInfo, 0, limit)
+	// One datapoint more than the page is read, which starts the next page.
+	err = s.source.Datapoints(s.chainName, pool, start, end, limit+1, func(point Datapoint) error {
+		if len(data) == limit {
+			next := point.Timestamp
+			page.Next = &next
+			return errPageFull
+		}
+		data = append(data, ReservesInfo{
+			Height:    point.Height,
+			Timestamp: point.Timestamp,
+			Reserve0:  formatAmount(point.Reserve0, pool.Token0.Decimals),
+			Reserve1:  formatAmount(point.Reserve1, pool.Token1.Decimals),
+			Price:     spotPrice(point.Reserve0, point.Reserve1, pool.Token0.Decimals, pool.Token1.Decimals),
+			Volume0:   formatAmount(point.Volume0, pool.Token0.Decimals),
+			Volume1:   formatAmount(point.Volume1, pool.Token1.Decimals),
+			Fee0:      formatAmount(point.Fee0, pool.Token0.Decimals),
+			Fee1:      formatAmount(point.Fee1, pool.Token1.Decimals),
+		})
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		s.fail(w, http.StatusInternalServerError, fmt.Errorf("could not read datapoints: %w", err))
+		return
+	}
+
+	page.Data = data
+	s.respond(w, page)
+}
+
+func (s *Server) candles(w http.ResponseWriter, r *http.Request, pool *Pool) {
+
+	start, end, limit, err := parseSeries(r)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "1h"
+	}
+	_, err = parseInterval(interval)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page := Page{}
+	data := make([]CandleInfo, 0, limit)
+	err = s.source.Candles(s.chainName, pool, interval, start, end, limit+1, func(candle Candle) error {
+		if len(data) == limit {
+			next := candle.Start
+			page.Next = &next
+			return errPageFull
+		}
+		data = append(data, CandleInfo{
+			Start:   candle.Start,
+			Open:    candle.Open,
+			High:    candle.High,
+			Low:     candle.Low,
+			Close:   candle.Close,
+			Volume0: formatAmount(candle.Volume0, pool.Token0.Decimals),
+			Volume1: formatAmount(candle.Volume1, pool.Token1.Decimals),
+			Fee0:    formatAmount(candle.Fee0, pool.Token0.Decimals),
+			Fee1:    formatAmount(candle.Fee1, pool.Token1.Decimals),
+		})
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		s.fail(w, http.StatusInternalServerError, fmt.Errorf("could not read candles: %w", err))
+		return
+	}
+
+	page.Data = data
+	s.respond(w, page)
+}
+
+func (s *Server) respond(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		s.log.Warn().Err(err).Msg("could not write response")
+	}
+}
+
+func (s *Server) fail(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		s.log.Error().Err(err).Msg("could not serve request")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// get restricts a handler to GET requests.
+func get(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("method not allowed (%s)", r.Method)})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func newTokenInfo(token Token) TokenInfo {
+	return TokenInfo{
+		Address:  token.Address.Hex(),
+		Symbol:   token.Symbol,
+		Decimals: token.Decimals,
+	}
+}
+
+// parseSeries parses the time range and page size of a request for a series;
+// the range defaults to everything up to now.
+func parseSeries(r *http.Request) (time.Time, time.Time, int, error) {
+
+	query := r.URL.Query()
+
+	start := time.Unix(0, 0).UTC()
+	if query.Has("from") {
+		var err error
+		start, err = parseTime(query.Get("from"))
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid start time: %w", err)
+		}
+	}
+
+	end := time.Now().UTC()
+	if query.Has("to") {
+		var err error
+		end, err = parseTime(query.Get("to"))
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid end time: %w", err)
+		}
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("start time must be before end time")
+	}
+
+	limit := defaultPageSize
+	if query.Has("limit") {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid limit: %w", err)
+		}
+		if limit <= 0 || limit > maxPageSize {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+
+	return start, end, limit, nil
+}
+
+// parseTime parses an RFC3339 timestamp or a number of Unix seconds.
+func parseTime(value string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// formatAmount formats an amount in the smallest unit of a token as an exact
+// decimal number of whole tokens, without trailing zeros.
+func formatAmount(amount *big.Int, decimals uint8) string {
+
+	digits := new(big.Int).Abs(amount).String()
+	if len(digits) <= int(decimals) {
+		digits = strings.Repeat("0", int(decimals)-len(digits)+1) + digits
+	}
+
+	whole := digits[:len(digits)-int(decimals)]
+	fraction := strings.TrimRight(digits[len(digits)-int(decimals):], "0")
+
+	formatted := whole
+	if fraction != "" {
+		formatted += "." + fraction
+	}
+	if amount.Sign() < 0 {
+		formatted = "-" + formatted
+	}
+
+	return formatted
+}
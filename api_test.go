@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestFormatAmount(t *testing.T) {
+
+	tests := []struct {
+		amount   string
+		decimals uint8
+		want     string
+	}{
+		{amount: "0", decimals: 18, want: "0"},
+		{amount: "1000000000000000000", decimals: 18, want: "1"},
+		{amount: "1", decimals: 18, want: "0.000000000000000001"},
+		{amount: "1500000", decimals: 6, want: "1.5"},
+		{amount: "123456789", decimals: 6, want: "123.456789"},
+		{amount: "100", decimals: 0, want: "100"},
+		{amount: "-2500", decimals: 3, want: "-2.5"},
+		{amount: "-1", decimals: 2, want: "-0.01"},
+		{amount: "340282366920938463463374607431768211455", decimals: 18, want: "340282366920938463463.374607431768211455"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			amount, ok := new(big.Int).SetString(test.amount, 10)
+			if !ok {
+				t.Fatalf("invalid amount (%s)", test.amount)
+			}
+			got := formatAmount(amount, test.decimals)
+			if got != test.want {
+				t.Errorf("formatted amount = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+const (
+	arbitrageMeasurement = "Uniswap v2 Arbitrage"
+)
+
+// leg is one swap of an arbitrage cycle through a pool, in the given direction.
+type leg struct {
+	pool       *Pool
+	zeroForOne bool
+}
+
+func (l leg) tokens() (Token, Token) {
+	if l.zeroForOne {
+		return l.pool.Token0, l.pool.Token1
+	}
+	return l.pool.Token1, l.pool.Token0
+}
+
+func (l leg) reserves() (float64, float64) {
+	if l.pool.Reserve0 == nil || l.pool.Reserve1 == nil {
+		return 0, 0
+	}
+	if l.zeroForOne {
+		return toFloat(l.pool.Reserve0), toFloat(l.pool.Reserve1)
+	}
+	return toFloat(l.pool.Reserve1), toFloat(l.pool.Reserve0)
+}
+
+// Opportunity is a profitable arbitrage cycle, from the first height where it
+// appeared until the last height where it was still profitable. The input and
+// profit are the largest ones seen, in decimal units of the start token.
+type Opportunity struct {
+	Route       string
+	Pools       string
+	Token       Token
+	StartHeight uint64
+	EndHeight   uint64
+	Start       time.Time
+	End         time.Time
+	Input       float64
+	Profit      float64
+	Closed      bool
+}
+
+// Arbitrage finds profitable cycles through the tracked pools at each height
+// and follows them for as long as they stay open.
+type Arbitrage struct {
+	fees   Fees
+	cycles [][]leg
+	open   map[string]*Opportunity
+}
+
+// NewArbitrage builds the token graph of the given pools and enumerates the
+// cycles of up to the given number of swaps through it. Each cycle is only
+// kept once, starting with its pool with the lowest address, but both
+// directions of a cycle are kept.
+func NewArbitrage(pools []*Pool, fees Fees, maxHops int) *Arbitrage {
+
+	edges := make(map[string][]leg)
+	for _, pool := range pools {
+		edges[pool.Token0.Address.Hex()] = append(edges[pool.Token0.Address.Hex()], leg{pool: pool, zeroForOne: true})
+		edges[pool.Token1.Address.Hex()] = append(edges[pool.Token1.Address.Hex()], leg{pool: pool, zeroForOne: false})
+	}
+
+	var cycles [][]leg
+	var search func(start string, current string, path []leg, used map[string]struct{})
+	search = func(start string, current string, path []leg, used map[string]struct{}) {
+		for _, next := range edges[current] {
+			address := next.pool.Address.Hex()
+			_, ok := used[address]
+			if ok {
+				continue
+			}
+			if bytes.Compare(next.pool.Address.Bytes(), path[0].pool.Address.Bytes()) < 0 {
+				continue
+			}
+			_, out := next.tokens()
+			extended := append(append([]leg{}, path...), next)
+			if out.Address.Hex() == start {
+				cycles = append(cycles, extended)
+				continue
+			}
+			if len(extended) >= maxHops {
+				continue
+			}
+			used[address] = struct{}{}
+			search(start, out.Address.Hex(), extended, used)
+			delete(used, address)
+		}
+	}
+
+	for _, pool := range pools {
+		for _, first := range []leg{{pool: pool, zeroForOne: true}, {pool: pool, zeroForOne: false}} {
+			in, out := first.tokens()
+			used := map[string]struct{}{pool.Address.Hex(): {}}
+			search(in.Address.Hex(), out.Address.Hex(), []leg{first}, used)
+		}
+	}
+
+	a := Arbitrage{
+		fees:   fees,
+		cycles: cycles,
+		open:   make(map[string]*Opportunity),
+	}
+
+	return &a
+}
+
+// Update evaluates all cycles against the current reserves of the pools and
+// returns the opportunities that closed since the last update.
+func (a *Arbitrage) Update(height uint64, timestamp time.Time) []Opportunity {
+
+	seen := make(map[string]struct{})
+	for _, cycle := range a.cycles {
+
+		input, profit, ok := a.optimize(cycle)
+		if !ok {
+			continue
+		}
+
+		token, _ := cycle[0].tokens()
+		scale := math.Pow10(int(token.Decimals))
+		input, profit = input/scale, profit/scale
+
+		key := cycleRoute(cycle) + "@" + cyclePools(cycle)
+		seen[key] = struct{}{}
+
+		opportunity, ok := a.open[key]
+		if !ok {
+			opportunity = &Opportunity{
+				Route:       cycleRoute(cycle),
+				Pools:       cyclePools(cycle),
+				Token:       token,
+				StartHeight: height,
+				Start:       timestamp,
+			}
+			a.open[key] = opportunity
+		}
+		opportunity.EndHeight = height
+		opportunity.End = timestamp
+		if profit > opportunity.Profit {
+			opportunity.Input = input
+			opportunity.Profit = profit
+		}
+	}
+
+	var closed []Opportunity
+	for key, opportunity := range a.open {
+		_, ok := seen[key]
+		if ok {
+			continue
+		}
+		opportunity.Closed = true
+		closed = append(closed, *opportunity)
+		delete(a.open, key)
+	}
+
+	sort.Slice(closed, func(i int, j int) bool {
+		return closed[i].StartHeight < closed[j].StartHeight
+	})
+
+	return closed
+}
+
+// Open returns the opportunities that are still open.
+func (a *Arbitrage) Open() []Opportunity {
+	open := make([]Opportunity, 0, len(a.open))
+	for _, opportunity := range a.open {
+		open = append(open, *opportunity)
+	}
+	return open
+}
+
+// clone returns a copy of the arbitrage whose open opportunities can change
+// independently; the cycles, and thus the pools, are shared.
+func (a *Arbitrage) clone() *Arbitrage {
+	clone := *a
+	clone.open = make(map[string]*Opportunity, len(a.open))
+	for key, opportunity := range a.open {
+		copied := *opportunity
+		clone.open[key] = &copied
+	}
+	return &clone
+}
+
+// optimize composes the pools of the cycle into a single virtual constant
+// product pool and returns the optimal input and profit of the cycle, in raw
+// units of the start token, if it is profitable after fees.
+func (a *Arbitrage) optimize(cycle []leg) (float64, float64, bool) {
+
+	gamma := 1 - float64(a.fees.bps)/10000
+
+	var virtualIn, virtualOut float64
+	for i, l := range cycle {
+		reserveIn, reserveOut := l.reserves()
+		if reserveIn == 0 || reserveOut == 0 {
+			return 0, 0, false
+		}
+		if i == 0 {
+			virtualIn, virtualOut = reserveIn, reserveOut
+			continue
+		}
+		denominator := reserveIn + gamma*virtualOut
+		virtualIn = virtualIn * reserveIn / denominator
+		virtualOut = gamma * virtualOut * reserveOut / denominator
+	}
+
+	if gamma*virtualOut <= virtualIn {
+		return 0, 0, false
+	}
+
+	input := (math.Sqrt(virtualIn*virtualOut*gamma) - virtualIn) / gamma
+	output := gamma * input * virtualOut / (virtualIn + gamma*input)
+	profit := output - input
+	if input <= 0 || profit <= 0 {
+		return 0, 0, false
+	}
+
+	return input, profit, true
+}
+
+// Point converts the opportunity into an InfluxDB datapoint for the arbitrage
+// measurement, timestamped at the start of the opportunity.
+func (o Opportunity) Point(chainName string) *write.Point {
+
+	// The first pool of the cycle ties the opportunity to a pair, so that it
+	// is removed along with the datapoints of the pair when its start block
+	// is reverted.
+	tags := map[string]string{
+		"chain":        chainName,
+		"route":        o.Route,
+		"pools":        o.Pools,
+		"pair_address": strings.Split(o.Pools, ",")[0],
+	}
+	fields := map[string]interface{}{
+		"token":        o.Token.Symbol,
+		"start_height": o.StartHeight,
+		"end_height":   o.EndHeight,
+		"blocks":       o.EndHeight - o.StartHeight + 1,
+		"duration":     o.End.Sub(o.Start).Seconds(),
+		"input":        o.Input,
+		"profit":       o.Profit,
+		"closed":       o.Closed,
+	}
+
+	return blockPoint(arbitrageMeasurement, tags, fields, o.StartHeight, o.Start, 0)
+}
+
+func cyclePools(cycle []leg) string {
+	addresses := make([]string, 0, len(cycle))
+	for _, l := range cycle {
+		addresses = append(addresses, l.pool.Address.Hex())
+	}
+	return strings.Join(addresses, ",")
+}
+
+func cycleRoute(cycle []leg) string {
+	in, _ := cycle[0].tokens()
+	symbols := []string{in.Symbol}
+	for _, l := range cycle {
+		_, out := l.tokens()
+		symbols = append(symbols, out.Symbol)
+	}
+	return strings.Join(symbols, ">")
+}
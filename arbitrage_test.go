@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestArbitrageOptimize(t *testing.T) {
+
+	tokenA := Token{Address: common.HexToAddress("0x00000000000000000000000000000000000000aa"), Symbol: "AAA"}
+	tokenB := Token{Address: common.HexToAddress("0x00000000000000000000000000000000000000bb"), Symbol: "BBB"}
+	tokenC := Token{Address: common.HexToAddress("0x00000000000000000000000000000000000000cc"), Symbol: "CCC"}
+
+	pool := func(address int64, token0 Token, token1 Token, reserve0 int64, reserve1 int64) *Pool {
+		return &Pool{
+			Address:  common.BigToAddress(big.NewInt(address)),
+			Token0:   token0,
+			Token1:   token1,
+			Reserve0: big.NewInt(reserve0),
+			Reserve1: big.NewInt(reserve1),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		cycle      []leg
+		profitable bool
+	}{
+		{
+			name: "same price",
+			cycle: []leg{
+				{pool: pool(1, tokenA, tokenB, 1000000, 2000000), zeroForOne: true},
+				{pool: pool(2, tokenA, tokenB, 3000000, 6000000), zeroForOne: false},
+			},
+		},
+		{
+			name: "price gap within fees",
+			cycle: []leg{
+				{pool: pool(1, tokenA, tokenB, 1000000, 2000000), zeroForOne: true},
+				{pool: pool(2, tokenA, tokenB, 1000000, 1995000), zeroForOne: false},
+			},
+		},
+		{
+			name: "wrong direction",
+			cycle: []leg{
+				{pool: pool(1, tokenA, tokenB, 1000000, 2000000), zeroForOne: true},
+				{pool: pool(2, tokenA, tokenB, 1000000, 2200000), zeroForOne: false},
+			},
+		},
+		{
+			name: "empty pool",
+			cycle: []leg{
+				{pool: pool(1, tokenA, tokenB, 1000000, 2200000), zeroForOne: true},
+				{pool: pool(2, tokenA, tokenB, 0, 0), zeroForOne: false},
+			},
+		},
+		{
+			name: "two pools",
+			cycle: []leg{
+				{pool: pool(1, tokenA, tokenB, 1000000, 2200000), zeroForOne: true},
+				{pool: pool(2, tokenA, tokenB, 1000000, 2000000), zeroForOne: false},
+			},
+			profitable: true,
+		},
+		{
+			name: "three pools",
+			cycle: []leg{
+				{pool: pool(1, tokenA, tokenB, 1000000, 2000000), zeroForOne: true},
+				{pool: pool(2, tokenC, tokenB, 3000000, 2000000), zeroForOne: false},
+				{pool: pool(3, tokenA, tokenC, 1000000, 2700000), zeroForOne: false},
+			},
+			profitable: true,
+		},
+	}
+
+	fees := NewFees(30)
+	gamma := 1 - float64(fees.bps)/10000
+
+	// simulate swaps the input through the legs of the cycle one at a time
+	// and returns the profit in the start token.
+	simulate := func(cycle []leg, input float64) float64 {
+		amount := input
+		for _, l := range cycle {
+			reserveIn, reserveOut := l.reserves()
+			amount = gamma * amount * reserveOut / (reserveIn + gamma*amount)
+		}
+		return amount - input
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			arbitrage := NewArbitrage(nil, fees, 3)
+			input, profit, ok := arbitrage.optimize(test.cycle)
+			if ok != test.profitable {
+				t.Fatalf("profitable = %t, want %t", ok, test.profitable)
+			}
+			if !ok {
+				return
+			}
+
+			// The profit is concave in the input, so a ternary search over the
+			// reserve of the start token finds the optimum to compare with.
+			low, high := 0.0, toFloat(test.cycle[0].pool.Reserve0)
+			for i := 0; i < 200; i++ {
+				left, right := low+(high-low)/3, high-(high-low)/3
+				if simulate(test.cycle, left) < simulate(test.cycle, right) {
+					low = left
+				} else {
+					high = right
+				}
+			}
+			optimum := (low + high) / 2
+
+			if math.Abs(input-optimum) > 1e-3*optimum {
+				t.Errorf("input = %f, want %f", input, optimum)
+			}
+			want := simulate(test.cycle, optimum)
+			if math.Abs(profit-want) > 1e-6*want {
+				t.Errorf("profit = %f, want %f", profit, want)
+			}
+		})
+	}
+}
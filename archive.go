@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Prefixes of the keys in the archive; heights are encoded as big-endian
+// integers, so the keys of each prefix are ordered by height.
+const (
+	prefixChain    = 'c'
+	prefixPool     = 'p'
+	prefixRange    = 'r'
+	prefixSnapshot = 's'
+	prefixHeader   = 'h'
+	prefixLog      = 'l'
+)
+
+// Snapshot is the state of a pool before a processed range, as archived along
+// with its log entries.
+type Snapshot struct {
+	Address  common.Address
+	Known    bool
+	Reserve0 *big.Int
+	Reserve1 *big.Int
+	KLast    *big.Int
+	FeeTo    common.Address `rlp:"optional"`
+}
+
+func NewSnapshot(pool *Pool) Snapshot {
+	s := Snapshot{
+		Address: pool.Address,
+		Known:   pool.Reserve0 != nil && pool.Reserve1 != nil,
+		KLast:   pool.KLast,
+		FeeTo:   pool.FeeTo,
+	}
+	if s.Known {
+		s.Reserve0 = pool.Reserve0
+		s.Reserve1 = pool.Reserve1
+	}
+	return s
+}
+
+// Apply sets the state of the pool to the snapshot.
+func (s Snapshot) Apply(pool *Pool) {
+	pool.Reserve0, pool.Reserve1 = nil, nil
+	if s.Known {
+		pool.Reserve0 = new(big.Int).Set(s.Reserve0)
+		pool.Reserve1 = new(big.Int).Set(s.Reserve1)
+	}
+	pool.KLast = nil
+	if s.KLast != nil {
+		pool.KLast = new(big.Int).Set(s.KLast)
+	}
+	pool.FeeTo = s.FeeTo
+}
+
+// ArchivedRange is a block range processed as a whole.
+type ArchivedRange struct {
+	From uint64
+	To   uint64
+}
+
+// archivedPool is the metadata of a pool.
+type archivedPool struct {
+	Address common.Address
+	Name    string
+	Token0  Token
+	Token1  Token
+	Factory common.Address `rlp:"optional"`
+}
+
+// archivedLog is a log entry without the block height and log index, which
+// are part of its key, and without the removal flag, as removed entries are
+// never archived.
+type archivedLog struct {
+	Address   common.Address
+	Topics    []common.Hash
+	Data      []byte
+	TxHash    common.Hash
+	TxIndex   uint
+	BlockHash common.Hash
+}
+
+// Archive is a sink that keeps the raw log entries and block timestamps of the
+// processed ranges in a LevelDB database, along with the metadata and state of
+// the tracked pools before each range, so that the datapoints can be derived
+// again without a client. The datapoints themselves are not written.
+type Archive struct {
+	db *leveldb.DB
+}
+
+func OpenArchive(path string) (*Archive, error) {
+
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %w", err)
+	}
+
+	a := Archive{
+		db: db,
+	}
+
+	return &a, nil
+}
+
+// Track records the chain of the archive, which cannot change afterwards.
+func (a *Archive) Track(chainName string, pools []*Pool) error {
+
+	archived, err := a.Chain()
+	if err == nil && archived != chainName {
+		return fmt.Errorf("archive belongs to another chain (%s)", archived)
+	}
+
+	return a.db.Put([]byte{prefixChain}, []byte(chainName), nil)
+}
+
+// Record archives a processed range, replacing the ranges and log entries that
+// were previously archived for the same heights.
+func (a *Archive) Record(from uint64, to uint64, pools []*Pool, blocks map[uint64]time.Time, entries []types.Log) error {
+
+	batch := new(leveldb.Batch)
+
+	// A previous range that overlaps the recorded one is cut short before it,
+	// and previous ranges starting within it are removed.
+	a.cut(batch, from)
+	err := a.remove(batch, prefixRange, from, to)
+	if err != nil {
+		return err
+	}
+	err = a.remove(batch, prefixSnapshot, from, to)
+	if err != nil {
+		return err
+	}
+	err = a.remove(batch, prefixLog, from, to)
+	if err != nil {
+		return err
+	}
+
+	batch.Put(heightKey(prefixRange, from), encodeHeight(to))
+
+	for _, pool := range pools {
+		meta, err := rlp.EncodeToBytes(archivedPool{
+			Address: pool.Address,
+			Name:    pool.Name,
+			Token0:  pool.Token0,
+			Token1:  pool.Token1,
+			Factory: pool.Factory,
+		})
+		if err != nil {
+			return fmt.Errorf("could not encode pair metadata (%s): %w", pool.Name, err)
+		}
+		batch.Put(append([]byte{prefixPool}, pool.Address.Bytes()...), meta)
+
+		snapshot, err := rlp.EncodeToBytes(NewSnapshot(pool))
+		if err != nil {
+			return fmt.Errorf("could not encode pair state (%s): %w", pool.Name, err)
+		}
+		batch.Put(append(heightKey(prefixSnapshot, from), pool.Address.Bytes()...), snapshot)
+	}
+
+	for height, timestamp := range blocks {
+		batch.Put(heightKey(prefixHeader, height), encodeHeight(uint64(timestamp.Unix())))
+	}
+
+	for _, entry := range entries {
+		data, err := rlp.EncodeToBytes(archivedLog{
+			Address:   entry.Address,
+			Topics:    entry.Topics,
+			Data:      entry.Data,
+			TxHash:    entry.TxHash,
+			TxIndex:   entry.TxIndex,
+			BlockHash: entry.BlockHash,
+		})
+		if err != nil {
+			return fmt.Errorf("could not encode log entry (%d, %d): %w", entry.BlockNumber, entry.Index, err)
+		}
+		batch.Put(logKey(entry.BlockNumber, entry.Index), data)
+	}
+
+	err = a.db.Write(batch, nil)
+	if err != nil {
+		return fmt.Errorf("could not write batch: %w", err)
+	}
+
+	return nil
+}
+
+// Revert removes the archived ranges, states, log entries and block timestamps
+// from the given height on, and cuts a range that covers it short before it.
+func (a *Archive) Revert(height uint64, since time.Time) error {
+
+	batch := new(leveldb.Batch)
+
+	a.cut(batch, height)
+	for _, prefix := range []byte{prefixRange, prefixSnapshot, prefixLog, prefixHeader} {
+		err := a.remove(batch, prefix, height, ^uint64(0))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := a.db.Write(batch, nil)
+	if err != nil {
+		return fmt.Errorf("could not write batch: %w", err)
+	}
+
+	return nil
+}
+
+// Write does nothing, as the datapoints can be derived from the archive.
+func (a *Archive) Write(points []*write.Point) error {
+	return nil
+}
+
+// Flush does nothing, as the ranges are written when they are recorded.
+func (a *Archive) Flush() error {
+	return nil
+}
+
+// Checkpoint does nothing, as the archived ranges record the progress.
+func (a *Archive) Checkpoint(height uint64) error {
+	return nil
+}
+
+func (a *Archive) Close() error {
+	return a.db.Close()
+}
+
+// Chain returns the name of the chain the archive belongs to.
+func (a *Archive) Chain() (string, error) {
+	name, err := a.db.Get([]byte{prefixChain}, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+// Pool returns the metadata of an archived pool, without state.
+func (a *Archive) Pool(address common.Address) (*Pool, error) {
+
+	data, err := a.db.Get(append([]byte{prefixPool}, address.Bytes()...), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, fmt.Errorf("pair not archived (%s)", address.Hex())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var meta archivedPool
+	err = rlp.DecodeBytes(data, &meta)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode pair metadata: %w", err)
+	}
+
+	p := Pool{
+		Address: meta.Address,
+		Name:    meta.Name,
+		Token0:  meta.Token0,
+		Token1:  meta.Token1,
+		Factory: meta.Factory,
+	}
+
+	return &p, nil
+}
+
+// Ranges returns the archived ranges that start between the given heights,
+// inclusively, in ascending order.
+func (a *Archive) Ranges(start uint64, end uint64) ([]ArchivedRange, error) {
+
+	it := a.db.NewIterator(heightRange(prefixRange, start, end), nil)
+	defer it.Release()
+
+	var ranges []ArchivedRange
+	for it.Next() {
+		ranges = append(ranges, ArchivedRange{
+			From: binary.BigEndian.Uint64(it.Key()[1:]),
+			To:   binary.BigEndian.Uint64(it.Value()),
+		})
+	}
+
+	return ranges, it.Error()
+}
+
+// Snapshots returns the states of the pools before the range starting at the
+// given height.
+func (a *Archive) Snapshots(from uint64) ([]Snapshot, error) {
+
+	it := a.db.NewIterator(util.BytesPrefix(heightKey(prefixSnapshot, from)), nil)
+	defer it.Release()
+
+	var snapshots []Snapshot
+	for it.Next() {
+		var snapshot Snapshot
+		err := rlp.DecodeBytes(it.Value(), &snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode pair state: %w", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, it.Error()
+}
+
+// Entries returns the archived log entries and block timestamps between the
+// given heights, inclusively, in the order of execution.
+func (a *Archive) Entries(from uint64, to uint64) ([]types.Log, map[uint64]time.Time, error) {
+
+	blocks := make(map[uint64]time.Time)
+	headers := a.db.NewIterator(heightRange(prefixHeader, from, to), nil)
+	for headers.Next() {
+		height := binary.BigEndian.Uint64(headers.Key()[1:])
+		blocks[height] = time.Unix(int64(binary.BigEndian.Uint64(headers.Value())), 0).UTC()
+	}
+	headers.Release()
+	err := headers.Error()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read block timestamps: %w", err)
+	}
+
+	var entries []types.Log
+	logs := a.db.NewIterator(heightRange(prefixLog, from, to), nil)
+	defer logs.Release()
+	for logs.Next() {
+		var archived archivedLog
+		err := rlp.DecodeBytes(logs.Value(), &archived)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not decode log entry: %w", err)
+		}
+		key := logs.Key()
+		entries = append(entries, types.Log{
+			Address:     archived.Address,
+			Topics:      archived.Topics,
+			Data:        archived.Data,
+			BlockNumber: binary.BigEndian.Uint64(key[1:9]),
+			TxHash:      archived.TxHash,
+			TxIndex:     archived.TxIndex,
+			BlockHash:   archived.BlockHash,
+			Index:       uint(binary.BigEndian.Uint32(key[9:13])),
+		})
+	}
+	err = logs.Error()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read log entries: %w", err)
+	}
+
+	return entries, blocks, nil
+}
+
+// cut adds the shortening of the range that started before the given height and
+// covers it, if any, to the batch, so that it ends right before the height.
+func (a *Archive) cut(batch *leveldb.Batch, height uint64) {
+	before := a.db.NewIterator(&util.Range{Start: heightKey(prefixRange, 0), Limit: heightKey(prefixRange, height)}, nil)
+	defer before.Release()
+	if before.Last() {
+		start := binary.BigEndian.Uint64(before.Key()[1:])
+		end := binary.BigEndian.Uint64(before.Value())
+		if end >= height {
+			batch.Put(heightKey(prefixRange, start), encodeHeight(height-1))
+		}
+	}
+}
+
+// remove adds the deletion of all keys with the given prefix between the given
+// heights, inclusively, to the batch.
+func (a *Archive) remove(batch *leveldb.Batch, prefix byte, from uint64, to uint64) error {
+	it := a.db.NewIterator(heightRange(prefix, from, to), nil)
+	defer it.Release()
+	for it.Next() {
+		batch.Delete(append([]byte(nil), it.Key()...))
+	}
+	return it.Error()
+}
+
+func encodeHeight(height uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, height)
+	return data
+}
+
+func heightKey(prefix byte, height uint64) []byte {
+	return append([]byte{prefix}, encodeHeight(height)...)
+}
+
+// heightRange covers the keys with the given prefix between the given heights,
+// inclusively.
+func heightRange(prefix byte, from uint64, to uint64) *util.Range {
+	limit := []byte{prefix + 1}
+	if to < ^uint64(0) {
+		limit = heightKey(prefix, to+1)
+	}
+	return &util.Range{Start: heightKey(prefix, from), Limit: limit}
+}
+
+func logKey(height uint64, index uint) []byte {
+	key := heightKey(prefixLog, height)
+	return binary.BigEndian.AppendUint32(key, uint32(index))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+// backfillRange processes the events of the pairs for a fixed block range and
+// writes the datapoints to the configured sinks. Unlike the run command, it
+// always writes and never follows the chain head, so past ranges can be
+// processed again without touching the flags of the running miner.
+func backfillRange(args []string) {
+
+	var (
+		logLevel  string
+		batchSize uint
+
+		pairAddresses []string
+		fromHeight    uint64
+		toHeight      uint64
+
+		apiURL string
+
+		sinks  SinkOptions
+		config Config
+	)
+
+	flags := pflag.NewFlagSet("backfill", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+	flags.UintVarP(&batchSize, "batch-size", "b", 100, "number of blocks to cover per request for log entries")
+
+	flags.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL")
+	flags.StringSliceVarP(&pairAddresses, "pair-addresses", "p", []string{"0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc"}, "Ethereum addresses for Uniswap v2 pairs")
+	flags.Uint64Var(&fromHeight, "from", 0, "first height of the range to process")
+	flags.Uint64Var(&toHeight, "to", 0, "last height of the range to process (defaults to the last height)")
+
+	bindSinkFlags(flags, &sinks)
+	bindConfigFlags(flags, &config)
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	if fromHeight == 0 {
+		log.Fatal().Msg("need start height of the range")
+	}
+	if batchSize == 0 {
+		log.Fatal().Uint("batch_size", batchSize).Msg("need positive batch size")
+	}
+
+	client, chainName := connectChain(log, apiURL)
+
+	if toHeight == 0 {
+		lastHeight, err := client.BlockNumber(context.Background())
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not get last block height")
+		}
+		toHeight = lastHeight
+	}
+	if toHeight < fromHeight {
+		log.Fatal().Uint64("from", fromHeight).Uint64("to", toHeight).Msg("end height before start height")
+	}
+
+	log = log.With().
+		Str("measurement", measurement).
+		Str("chain_name", chainName).
+		Logger()
+
+	sink, _, err := openSinks(log, sinks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not open sinks")
+	}
+
+	config.WriteMetrics = true
+	miner, err := NewMiner(log, client, sink, chainName, pairAddresses, config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not initialize miner")
+	}
+
+	miner.Load(fromHeight - 1)
+
+	processRange(log, miner, fromHeight, toHeight, batchSize)
+
+	err = miner.Close()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not close miner")
+	}
+
+	log.Info().Uint64("from", fromHeight).Uint64("to", toHeight).Msg("backfilled block range")
+
+	os.Exit(0)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+const (
+	candleMeasurement = "Uniswap v2 Candles"
+)
+
+// Candle is the open/high/low/close price, base/quote volume and liquidity
+// provider fees of a pair over one interval, with the price expressed as units
+// of token1 per token0.
+type Candle struct {
+	Start   time.Time
+	Open    float64
+	High    float64
+	Low     float64
+	Close   float64
+	Volume0 *big.Int
+	Volume1 *big.Int
+	Fee0    *big.Int
+	Fee1    *big.Int
+}
+
+// Candles aggregates the per-block datapoints of a pair into candles of a fixed
+// interval, aligned on the block timestamps.
+type Candles struct {
+	label    string
+	interval time.Duration
+	current  *Candle
+}
+
+func NewCandles(label string) (*Candles, error) {
+
+	interval, err := parseInterval(label)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Candles{
+		label:    label,
+		interval: interval,
+	}
+
+	return &c, nil
+}
+
+// Add includes the datapoint of one block in the aggregation and returns the
+// candles that were completed by it. The open price is the price before the
+// block, at which the first candle opens; later candles open at the close of
+// the previous one. Intervals without any blocks are returned as candles
+// carrying the previous close forward with zero volume.
+func (c *Candles) Add(point Datapoint, open float64, price float64) []Candle {
+
+	start := point.Timestamp.Truncate(c.interval)
+	if c.current == nil {
+		c.current = newCandle(start, open)
+	}
+
+	var completed []Candle
+	for c.current.Start.Before(start) {
+		completed = append(completed, *c.current)
+		c.current = newCandle(c.current.Start.Add(c.interval), c.current.Close)
+	}
+
+	c.current.High = math.Max(c.current.High, price)
+	c.current.Low = math.Min(c.current.Low, price)
+	c.current.Close = price
+	c.current.Volume0.Add(c.current.Volume0, point.Volume0)
+	c.current.Volume1.Add(c.current.Volume1, point.Volume1)
+	c.current.Fee0.Add(c.current.Fee0, point.Fee0)
+	c.current.Fee1.Add(c.current.Fee1, point.Fee1)
+
+	return completed
+}
+
+// Current returns the candle that is still being aggregated, if any.
+func (c *Candles) Current() (Candle, bool) {
+	if c.current == nil {
+		return Candle{}, false
+	}
+	return *c.current, true
+}
+
+// clone returns a copy of the aggregation that can be continued independently.
+func (c *Candles) clone() *Candles {
+	clone := *c
+	if c.current != nil {
+		current := *c.current
+		current.Volume0 = new(big.Int).Set(current.Volume0)
+		current.Volume1 = new(big.Int).Set(current.Volume1)
+		current.Fee0 = new(big.Int).Set(current.Fee0)
+		current.Fee1 = new(big.Int).Set(current.Fee1)
+		clone.current = &current
+	}
+	return &clone
+}
+
+// Point converts the given candle into an InfluxDB datapoint for the candle
+// measurement.
+func (c *Candles) Point(chainName string, pool *Pool, candle Candle) *write.Point {
+
+	tags := map[string]string{
+		"chain":        chainName,
+		"pair":         pool.Name,
+		"pair_address": pool.Address.Hex(),
+		"interval":     c.label,
+	}
+	fields := map[string]interface{}{
+		"open":    candle.Open,
+		"high":    candle.High,
+		"low":     candle.Low,
+		"close":   candle.Close,
+		"volume0": hex.EncodeToString(candle.Volume0.Bytes()),
+		"volume1": hex.EncodeToString(candle.Volume1.Bytes()),
+		"fee0":    hex.EncodeToString(candle.Fee0.Bytes()),
+		"fee1":    hex.EncodeToString(candle.Fee1.Bytes()),
+	}
+
+	return write.NewPoint(candleMeasurement, tags, fields, candle.Start)
+}
+
+// newCandle opens a candle at the price the pair had when the interval started,
+// which is the close of the previous interval.
+func newCandle(start time.Time, price float64) *Candle {
+	c := Candle{
+		Start:   start,
+		Open:    price,
+		High:    price,
+		Low:     price,
+		Close:   price,
+		Volume0: big.NewInt(0),
+		Volume1: big.NewInt(0),
+		Fee0:    big.NewInt(0),
+		Fee1:    big.NewInt(0),
+	}
+	return &c
+}
+
+// parseInterval parses a candle interval, which is a Go duration with the
+// additional support for whole days, such as "1d".
+func parseInterval(label string) (time.Duration, error) {
+
+	var interval time.Duration
+	if strings.HasSuffix(label, "d") {
+		days, err := strconv.ParseUint(strings.TrimSuffix(label, "d"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days (%s): %w", label, err)
+		}
+		interval = time.Duration(days) * 24 * time.Hour
+	} else {
+		duration, err := time.ParseDuration(label)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval duration (%s): %w", label, err)
+		}
+		interval = duration
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("interval must be positive (%s)", label)
+	}
+
+	return interval, nil
+}
+
+// spotPrice returns the price of token0 in units of token1, adjusted for the
+// decimals of both tokens.
+func spotPrice(reserve0 *big.Int, reserve1 *big.Int, decimals0 uint8, decimals1 uint8) float64 {
+
+	if reserve0.Sign() == 0 {
+		return 0
+	}
+
+	amount0 := new(big.Float).Quo(new(big.Float).SetInt(reserve0), pow10(decimals0))
+	amount1 := new(big.Float).Quo(new(big.Float).SetInt(reserve1), pow10(decimals1))
+
+	price, _ := new(big.Float).Quo(amount1, amount0).Float64()
+
+	return price
+}
+
+func pow10(decimals uint8) *big.Float {
+	exp := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	return new(big.Float).SetInt(exp)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCandlesAdd(t *testing.T) {
+
+	base := time.Unix(1600000000, 0).UTC().Truncate(time.Hour)
+
+	// block is the datapoint of one block at the given offset from the start
+	// of the first hour, with the price before and after it.
+	type block struct {
+		offset time.Duration
+		open   float64
+		price  float64
+		volume int64
+	}
+
+	tests := []struct {
+		name    string
+		blocks  []block
+		want    []Candle
+		current Candle
+	}{
+		{
+			name: "first candle opens before its first block",
+			blocks: []block{
+				{offset: 10 * time.Minute, open: 1, price: 2, volume: 5},
+			},
+			current: Candle{Start: base, Open: 1, High: 2, Low: 1, Close: 2, Volume0: big.NewInt(5)},
+		},
+		{
+			name: "blocks within one interval",
+			blocks: []block{
+				{offset: 10 * time.Minute, open: 2, price: 3, volume: 1},
+				{offset: 20 * time.Minute, open: 3, price: 1, volume: 2},
+				{offset: 30 * time.Minute, open: 1, price: 2, volume: 3},
+			},
+			current: Candle{Start: base, Open: 2, High: 3, Low: 1, Close: 2, Volume0: big.NewInt(6)},
+		},
+		{
+			name: "next candle opens at previous close",
+			blocks: []block{
+				{offset: 10 * time.Minute, open: 2, price: 3, volume: 1},
+				{offset: 70 * time.Minute, open: 3, price: 4, volume: 2},
+			},
+			want: []Candle{
+				{Start: base, Open: 2, High: 3, Low: 2, Close: 3, Volume0: big.NewInt(1)},
+			},
+			current: Candle{Start: base.Add(time.Hour), Open: 3, High: 4, Low: 3, Close: 4, Volume0: big.NewInt(2)},
+		},
+		{
+			name: "empty intervals carry the close forward",
+			blocks: []block{
+				{offset: 10 * time.Minute, open: 2, price: 3, volume: 1},
+				{offset: 190 * time.Minute, open: 3, price: 1, volume: 2},
+			},
+			want: []Candle{
+				{Start: base, Open: 2, High: 3, Low: 2, Close: 3, Volume0: big.NewInt(1)},
+				{Start: base.Add(time.Hour), Open: 3, High: 3, Low: 3, Close: 3, Volume0: big.NewInt(0)},
+				{Start: base.Add(2 * time.Hour), Open: 3, High: 3, Low: 3, Close: 3, Volume0: big.NewInt(0)},
+			},
+			current: Candle{Start: base.Add(3 * time.Hour), Open: 3, High: 3, Low: 1, Close: 1, Volume0: big.NewInt(2)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			candles, err := NewCandles("1h")
+			if err != nil {
+				t.Fatalf("could not create candles: %v", err)
+			}
+
+			var completed []Candle
+			for _, b := range test.blocks {
+				point := Datapoint{
+					Timestamp: base.Add(b.offset),
+					Volume0:   big.NewInt(b.volume),
+					Volume1:   big.NewInt(0),
+					Fee0:      big.NewInt(0),
+					Fee1:      big.NewInt(0),
+				}
+				completed = append(completed, candles.Add(point, b.open, b.price)...)
+			}
+
+			if len(completed) != len(test.want) {
+				t.Fatalf("got %d completed candles, want %d", len(completed), len(test.want))
+			}
+			for i, candle := range completed {
+				checkCandle(t, candle, test.want[i])
+			}
+			current, ok := candles.Current()
+			if !ok {
+				t.Fatalf("no current candle")
+			}
+			checkCandle(t, current, test.current)
+		})
+	}
+}
+
+func checkCandle(t *testing.T, got Candle, want Candle) {
+	t.Helper()
+	if !got.Start.Equal(want.Start) {
+		t.Errorf("start = %s, want %s", got.Start, want.Start)
+	}
+	if got.Open != want.Open || got.High != want.High || got.Low != want.Low || got.Close != want.Close {
+		t.Errorf("OHLC = %v/%v/%v/%v, want %v/%v/%v/%v", got.Open, got.High, got.Low, got.Close, want.Open, want.High, want.Low, want.Close)
+	}
+	if got.Volume0.Cmp(want.Volume0) != 0 {
+		t.Errorf("volume0 = %s, want %s", got.Volume0, want.Volume0)
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+
+	tests := []struct {
+		label string
+		want  time.Duration
+		fails bool
+	}{
+		{label: "1m", want: time.Minute},
+		{label: "4h", want: 4 * time.Hour},
+		{label: "1d", want: 24 * time.Hour},
+		{label: "7d", want: 7 * 24 * time.Hour},
+		{label: "0d", fails: true},
+		{label: "0s", fails: true},
+		{label: "-1h", fails: true},
+		{label: "xd", fails: true},
+		{label: "1w", fails: true},
+		{label: "", fails: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			got, err := parseInterval(test.label)
+			if test.fails {
+				if err == nil {
+					t.Errorf("parsed invalid interval as %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not parse interval: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("interval = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
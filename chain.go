@@ -1,8 +1,35 @@
 package main
 
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
 type Chain struct {
 	Name      string `json:"name"`
 	ChainID   uint64 `json:"chainId"`
 	ShortName string `json:"shortName"`
 	NetworID  uint64 `json:"networkId"`
 }
+
+func loadChains(path string) (map[uint64]string, error) {
+
+	chainsData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read chain list: %w", err)
+	}
+
+	var chains []Chain
+	err = json.Unmarshal(chainsData, &chains)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode chain list: %w", err)
+	}
+
+	chainLookup := make(map[uint64]string)
+	for _, chain := range chains {
+		chainLookup[chain.ChainID] = chain.Name
+	}
+
+	return chainLookup, nil
+}
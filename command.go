@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// newLogger creates the logger of a command, writing to standard output with
+// the given minimum severity level.
+func newLogger(logLevel string) zerolog.Logger {
+
+	zerolog.TimestampFunc = func() time.Time { return time.Now().UTC() }
+	log := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	level, err := zerolog.ParseLevel(logLevel)
+	if err != nil {
+		log.Fatal().Str("log_level", logLevel).Err(err).Msg("invalid log level")
+	}
+
+	return log.Level(level)
+}
+
+// connectChain connects to the JSON RPC API and returns the client along with
+// the name of its chain.
+func connectChain(log zerolog.Logger, apiURL string) (*ethclient.Client, string) {
+
+	client, err := ethclient.Dial(apiURL)
+	if err != nil {
+		log.Fatal().Str("api_url", apiURL).Err(err).Msg("could not connect to JSON RPC API")
+	}
+
+	return client, lookupChain(log, client)
+}
+
+// lookupChain returns the name of the chain of the client from the chain list.
+func lookupChain(log zerolog.Logger, client *ethclient.Client) string {
+
+	chainLookup, err := loadChains(chainList)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not load chain list")
+	}
+
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not get chain ID")
+	}
+
+	chainName, ok := chainLookup[chainID.Uint64()]
+	if !ok {
+		log.Fatal().Uint64("chain_id", chainID.Uint64()).Msg("unknown chain ID")
+	}
+
+	return chainName
+}
+
+// processRange processes the given heights, inclusively, in batches of the
+// given size.
+func processRange(log zerolog.Logger, miner *Miner, startHeight uint64, endHeight uint64, batchSize uint) {
+
+	for from := startHeight; from <= endHeight; from += uint64(batchSize) {
+
+		to := from + uint64(batchSize) - 1
+		if to > endHeight {
+			to = endHeight
+		}
+
+		err := miner.Process(from, to)
+		if err != nil {
+			log.Fatal().Uint64("from", from).Uint64("to", to).Err(err).Msg("could not process block range")
+		}
+	}
+}
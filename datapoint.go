@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math/big"
+	"time"
+)
+
+// Datapoint is the state of a pair at one block height, as written to the
+// InfluxDB measurement by the miner. The fees are those earned by the
+// liquidity providers, excluding the protocol share.
+type Datapoint struct {
+	Height    uint64
+	Timestamp time.Time
+	Reserve0  *big.Int
+	Reserve1  *big.Int
+	Volume0   *big.Int
+	Volume1   *big.Int
+	Fee0      *big.Int
+	Fee1      *big.Int
+
+	Transactions uint64
+	Swaps        uint64
+}
+
+// Source reads back the datapoints and candles stored for the pairs. A limit
+// caps the number of datapoints or candles read, with zero reading all of them.
+type Source interface {
+	Datapoints(chainName string, pool *Pool, start time.Time, end time.Time, limit int, process func(Datapoint) error) error
+	Heights(chainName string, pool *Pool, start time.Time, end time.Time) (map[uint64]struct{}, error)
+	Candles(chainName string, pool *Pool, interval string, start time.Time, end time.Time, limit int, process func(Candle) error) error
+}
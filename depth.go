@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Depth returns the input amount of a token that a swap needs to move the price
+// of the other token up by the given fraction, such as 0.01 for 1%, given the
+// reserve of the input token. As the constant product keeps the price at the
+// ratio of the reserves, the reserve of the input token has to grow by the
+// square root of the price change, and the fee on the input has to be paid on
+// top of that.
+func (f Fees) Depth(reserve *big.Int, level float64) *big.Int {
+
+	factor := (math.Sqrt(1+level) - 1) / (1 - float64(f.bps)/10000)
+
+	depth := new(big.Float).SetInt(reserve)
+	depth.Mul(depth, big.NewFloat(factor))
+	amount, _ := depth.Int(nil)
+
+	return amount
+}
+
+// priceImpact returns the relative change of the price of token0 in units of
+// token1 between two sets of reserves.
+func priceImpact(before0 *big.Int, before1 *big.Int, after0 *big.Int, after1 *big.Int) float64 {
+
+	if before0.Sign() == 0 || before1.Sign() == 0 || after0.Sign() == 0 {
+		return 0
+	}
+
+	before := new(big.Rat).SetFrac(before1, before0)
+	after := new(big.Rat).SetFrac(after1, after0)
+	change, _ := new(big.Rat).Quo(after, before).Float64()
+
+	return change - 1
+}
+
+// depthField returns the field name for the depth of a token at the given
+// level, such as "depth0_1pct" for 1%.
+func depthField(index int, level float64) string {
+	return fmt.Sprintf("depth%d_%spct", index, strconv.FormatFloat(level*100, 'f', -1, 64))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestFeesDepth(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		bps     uint
+		reserve int64
+		level   float64
+		want    int64
+	}{
+		{name: "no fee", bps: 0, reserve: 1000000, level: 0.01, want: 4987},
+		{name: "one percent", bps: 30, reserve: 1000000, level: 0.01, want: 5002},
+		{name: "two percent", bps: 30, reserve: 1000000, level: 0.02, want: 9980},
+		{name: "quadrupled price", bps: 0, reserve: 1000000, level: 3, want: 1000000},
+		{name: "no change", bps: 30, reserve: 1000000, level: 0, want: 0},
+		{name: "empty pair", bps: 30, reserve: 0, level: 0.01, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := NewFees(test.bps).Depth(big.NewInt(test.reserve), test.level)
+			if got.Cmp(big.NewInt(test.want)) != 0 {
+				t.Errorf("depth = %s, want %d", got, test.want)
+			}
+		})
+	}
+}
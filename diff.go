@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+const (
+	diffBatch = 1000
+)
+
+// Diff is a sink that compares the datapoints with the ones already stored
+// in InfluxDB instead of writing them, and logs the datapoints that would be
+// added or changed.
+type Diff struct {
+	log    zerolog.Logger
+	query  api.QueryAPI
+	bucket string
+
+	points    []*write.Point
+	added     uint
+	changed   uint
+	unchanged uint
+}
+
+func NewDiff(log zerolog.Logger, query api.QueryAPI, bucket string) *Diff {
+
+	d := Diff{
+		log:    log,
+		query:  query,
+		bucket: bucket,
+	}
+
+	return &d
+}
+
+// Write queues the datapoints for comparison.
+func (d *Diff) Write(points []*write.Point) error {
+	d.points = append(d.points, points...)
+	if len(d.points) >= diffBatch {
+		return d.Flush()
+	}
+	return nil
+}
+
+// Flush compares the queued datapoints with the stored ones, querying each
+// series once for the time range of its queued datapoints.
+func (d *Diff) Flush() error {
+
+	series := make(map[string][]*write.Point)
+	for _, point := range d.points {
+		key := seriesKey(point)
+		series[key] = append(series[key], point)
+	}
+	d.points = nil
+
+	for _, points := range series {
+		err := d.compare(points)
+		if err != nil {
+			return fmt.Errorf("could not compare datapoints with stored values (%s): %w", points[0].Name(), err)
+		}
+	}
+
+	d.log.Info().
+		Uint("added", d.added).
+		Uint("changed", d.changed).
+		Uint("unchanged", d.unchanged).
+		Msg("compared datapoints with stored values")
+
+	return nil
+}
+
+// Checkpoint does nothing, as nothing is written.
+func (d *Diff) Checkpoint(height uint64) error {
+	return nil
+}
+
+func (d *Diff) Close() error {
+	return d.Flush()
+}
+
+// compare compares datapoints of a single series with the stored ones.
+func (d *Diff) compare(points []*write.Point) error {
+
+	start, end := points[0].Time(), points[0].Time()
+	for _, point := range points {
+		if point.Time().Before(start) {
+			start = point.Time()
+		}
+		if point.Time().After(end) {
+			end = point.Time()
+		}
+	}
+
+	filters := []string{fmt.Sprintf("r._measurement == %q", points[0].Name())}
+	block := false
+	for _, tag := range points[0].TagList() {
+		filters = append(filters, fmt.Sprintf("r[%q] == %q", tag.Key, tag.Value))
+		block = block || tag.Key == "block"
+	}
+
+	// Datapoints carried forward to interval boundaries have no block, and
+	// must not be compared with the datapoint of a block on the boundary.
+	if !block {
+		filters = append(filters, "not exists r.block")
+	}
+
+	flux := fmt.Sprintf(`from(bucket: %q)
+	|> range(start: %s, stop: %s)
+	|> filter(fn: (r) => %s)
+	|> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`,
+		d.bucket,
+		start.UTC().Format(time.RFC3339Nano),
+		end.Add(time.Nanosecond).UTC().Format(time.RFC3339Nano),
+		strings.Join(filters, " and "),
+	)
+
+	result, err := d.query.Query(context.Background(), flux)
+	if err != nil {
+		return fmt.Errorf("could not execute query: %w", err)
+	}
+	defer result.Close()
+
+	stored := make(map[int64]map[string]interface{})
+	for result.Next() {
+		record := result.Record()
+		stored[record.Time().UnixNano()] = record.Values()
+	}
+	err = result.Err()
+	if err != nil {
+		return fmt.Errorf("could not read query result: %w", err)
+	}
+
+	for _, point := range points {
+
+		log := d.log.With().
+			Str("measurement", point.Name()).
+			Str("series", seriesKey(point)).
+			Time("timestamp", point.Time()).
+			Logger()
+
+		values, ok := stored[point.Time().UnixNano()]
+		if !ok {
+			d.added++
+			log.Info().Msg("datapoint would be added")
+			continue
+		}
+
+		var fields []string
+		for _, field := range point.FieldList() {
+			written := fmt.Sprint(field.Value)
+			previous, ok := values[field.Key]
+			if ok && previous != nil && fmt.Sprint(previous) == written {
+				continue
+			}
+			fields = append(fields, field.Key)
+			log.Debug().
+				Str("field", field.Key).
+				Str("stored", fmt.Sprint(previous)).
+				Str("written", written).
+				Msg("field would change")
+		}
+
+		if len(fields) == 0 {
+			d.unchanged++
+			continue
+		}
+
+		d.changed++
+		log.Info().Strs("fields", fields).Msg("datapoint would change")
+	}
+
+	return nil
+}
+
+// seriesKey identifies the series of a datapoint by its measurement and tags.
+func seriesKey(point *write.Point) string {
+
+	tags := make([]string, 0, len(point.TagList()))
+	for _, tag := range point.TagList() {
+		tags = append(tags, tag.Key+"="+tag.Value)
+	}
+	sort.Strings(tags)
+
+	return point.Name() + "," + strings.Join(tags, ",")
+}
@@ -1,19 +1,30 @@
 package main
 
 import (
+	"fmt"
 	"math/big"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
 const (
 	EventSwap = "Swap(address,uint256,uint256,uint256,uint256,address)"
 	EventSync = "Sync(uint112,uint112)"
+	EventMint = "Mint(address,uint256,uint256)"
+	EventBurn = "Burn(address,uint256,uint256,address)"
+
+	EventTransfer = "Transfer(address,address,uint256)"
 )
 
 var (
 	SigSwap = crypto.Keccak256Hash([]byte(EventSwap))
 	SigSync = crypto.Keccak256Hash([]byte(EventSync))
+	SigMint = crypto.Keccak256Hash([]byte(EventMint))
+	SigBurn = crypto.Keccak256Hash([]byte(EventBurn))
+
+	SigTransfer = crypto.Keccak256Hash([]byte(EventTransfer))
 )
 
 type Swap struct {
@@ -27,3 +38,73 @@ type Sync struct {
 	Reserve0 *big.Int
 	Reserve1 *big.Int
 }
+
+type Mint struct {
+	Amount0 *big.Int
+	Amount1 *big.Int
+}
+
+type Burn struct {
+	Amount0 *big.Int
+	Amount1 *big.Int
+}
+
+// decodeEvent decodes a swap, sync, mint or burn event of a pair into its kind
+// and its amounts by name; the kind is empty for other log entries.
+func decodeEvent(pairABI abi.ABI, entry types.Log) (string, map[string]*big.Int, error) {
+
+	switch entry.Topics[0] {
+
+	case SigSwap:
+		var swap Swap
+		err := pairABI.UnpackIntoInterface(&swap, "Swap", entry.Data)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not unpack swap event: %w", err)
+		}
+		amounts := map[string]*big.Int{
+			"amount0_in":  swap.Amount0In,
+			"amount1_in":  swap.Amount1In,
+			"amount0_out": swap.Amount0Out,
+			"amount1_out": swap.Amount1Out,
+		}
+		return "swap", amounts, nil
+
+	case SigSync:
+		var sick Sync
+		err := pairABI.UnpackIntoInterface(&sick, "Sync", entry.Data)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not unpack sync event: %w", err)
+		}
+		amounts := map[string]*big.Int{
+			"reserve0": sick.Reserve0,
+			"reserve1": sick.Reserve1,
+		}
+		return "sync", amounts, nil
+
+	case SigMint:
+		var mint Mint
+		err := pairABI.UnpackIntoInterface(&mint, "Mint", entry.Data)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not unpack mint event: %w", err)
+		}
+		amounts := map[string]*big.Int{
+			"amount0": mint.Amount0,
+			"amount1": mint.Amount1,
+		}
+		return "mint", amounts, nil
+
+	case SigBurn:
+		var burn Burn
+		err := pairABI.UnpackIntoInterface(&burn, "Burn", entry.Data)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not unpack burn event: %w", err)
+		}
+		amounts := map[string]*big.Int{
+			"amount0": burn.Amount0,
+			"amount1": burn.Amount1,
+		}
+		return "burn", amounts, nil
+	}
+
+	return "", nil, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// exportDatapoints writes the stored datapoints of a set of pairs between two
+// timestamps to a single CSV or Parquet file, with the rows of the file sinks,
+// reading them from InfluxDB or from a SQLite database.
+func exportDatapoints(args []string) {
+
+	var (
+		logLevel string
+
+		pairAddresses []string
+		startTime     string
+		endTime       string
+
+		apiURL string
+
+		influxURL    string
+		influxToken  string
+		influxOrg    string
+		influxBucket string
+
+		sqlitePath string
+
+		format string
+		output string
+	)
+
+	flags := pflag.NewFlagSet("export", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+
+	flags.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL")
+	flags.StringSliceVarP(&pairAddresses, "pair-addresses", "p", []string{"0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc"}, "Ethereum addresses for Uniswap v2 pairs")
+	flags.StringVar(&startTime, "start-time", "2020-05-05T00:00:00Z", "start time of the datapoints to export (RFC3339)")
+	flags.StringVar(&endTime, "end-time", "", "end time of the datapoints to export (RFC3339, defaults to now)")
+
+	flags.StringVarP(&influxURL, "influx-url", "i", "https://eu-central-1-1.aws.cloud2.influxdata.com", "InfluxDB API URL")
+	flags.StringVarP(&influxOrg, "influx-org", "o", "optakt", "InfluxDB organization name")
+	flags.StringVarP(&influxBucket, "influx-metrics-bucket", "m", "metrics", "InfluxDB bucket name")
+	flags.StringVarP(&influxToken, "influx-token", "t", "", "InfluxDB authentication token")
+
+	flags.StringVar(&sqlitePath, "sqlite-path", "", "path of a SQLite database file to use instead of InfluxDB")
+
+	flags.StringVar(&format, "format", FormatCSV, "format of the exported file (csv, parquet)")
+	flags.StringVar(&output, "output", "", "path of the exported file (defaults to datapoints.<format>)")
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	if format != FormatCSV && format != FormatParquet {
+		log.Fatal().Str("format", format).Msg("unknown file format")
+	}
+	if output == "" {
+		output = fmt.Sprintf("datapoints.%s", format)
+	}
+
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		log.Fatal().Str("start_time", startTime).Err(err).Msg("invalid start time")
+	}
+	end := time.Now().UTC()
+	if endTime != "" {
+		end, err = time.Parse(time.RFC3339, endTime)
+		if err != nil {
+			log.Fatal().Str("end_time", endTime).Err(err).Msg("invalid end time")
+		}
+	}
+
+	client, chainName := connectChain(log, apiURL)
+
+	var source Source
+	if sqlitePath != "" {
+		store, err := OpenStore(sqlitePath)
+		if err != nil {
+			log.Fatal().Str("sqlite_path", sqlitePath).Err(err).Msg("could not open SQLite database")
+		}
+		source = store
+	} else {
+		influx, err := connectInflux(influxURL, influxToken)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not connect to InfluxDB API")
+		}
+		source = NewInfluxSource(influx.QueryAPI(influxOrg), influxBucket)
+	}
+
+	log = log.With().
+		Str("measurement", measurement).
+		Str("chain_name", chainName).
+		Str("output", output).
+		Logger()
+
+	file, err := os.Create(output)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not create file")
+	}
+
+	var rows *csv.Writer
+	var parquet *writer.ParquetWriter
+	switch format {
+	case FormatCSV:
+		rows = csv.NewWriter(file)
+		err = rows.Write(rowHeader)
+	case FormatParquet:
+		parquet, err = writer.NewParquetWriterFromWriter(file, new(Row), 1)
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not initialize file")
+	}
+
+	exported := 0
+	for _, pairAddress := range pairAddresses {
+
+		pool, err := fetchPool(client, common.HexToAddress(pairAddress))
+		if err != nil {
+			log.Fatal().Str("pair_address", pairAddress).Err(err).Msg("could not get pair metadata")
+		}
+
+		err = source.Datapoints(chainName, pool, start, end, 0, func(point Datapoint) error {
+			row := newDatapointRow(chainName, pool, point)
+			if rows != nil {
+				err = rows.Write(row.record())
+			} else {
+				err = parquet.Write(row)
+			}
+			if err != nil {
+				return fmt.Errorf("could not write row (%d): %w", point.Height, err)
+			}
+			exported++
+			return nil
+		})
+		if err != nil {
+			log.Fatal().Str("pair_name", pool.Name).Err(err).Msg("could not export datapoints")
+		}
+	}
+
+	if rows != nil {
+		rows.Flush()
+		err = rows.Error()
+	} else {
+		err = parquet.WriteStop()
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not complete file")
+	}
+
+	err = file.Close()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not close file")
+	}
+
+	log.Info().Int("datapoints", exported).Msg("exported datapoints")
+
+	os.Exit(0)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math/big"
+	"time"
+)
+
+const (
+	year = 365 * 24 * time.Hour
+)
+
+// Fees computes the fee revenue of a Uniswap v2 pair, which charges its fee on
+// the input amounts of swaps. When the protocol fee is switched on, one sixth
+// of the fee growth goes to the protocol instead of the liquidity providers.
+type Fees struct {
+	bps int64
+}
+
+func NewFees(bps uint) Fees {
+	return Fees{bps: int64(bps)}
+}
+
+// Split returns the share of the fee on the given input volume that goes to
+// the liquidity providers and the share that goes to the protocol.
+func (f Fees) Split(volume *big.Int, protocol bool) (*big.Int, *big.Int) {
+
+	total := new(big.Int).Mul(volume, big.NewInt(f.bps))
+	total.Quo(total, big.NewInt(10000))
+
+	share := big.NewInt(0)
+	if protocol {
+		share.Quo(total, big.NewInt(6))
+	}
+
+	return total.Sub(total, share), share
+}
+
+// protocolMint estimates the token amounts behind the liquidity minted to the
+// protocol on a mint or burn, given the reserves before the operation. The
+// pair mints liquidity worth (√k - √kLast) / 6√k of the pool, which is what the
+// protocol accrued since the last mint or burn.
+func protocolMint(reserve0 *big.Int, reserve1 *big.Int, kLast *big.Int) (*big.Int, *big.Int) {
+
+	if kLast == nil || kLast.Sign() == 0 {
+		return big.NewInt(0), big.NewInt(0)
+	}
+
+	rootK := new(big.Int).Sqrt(new(big.Int).Mul(reserve0, reserve1))
+	rootKLast := new(big.Int).Sqrt(kLast)
+	if rootK.Cmp(rootKLast) <= 0 {
+		return big.NewInt(0), big.NewInt(0)
+	}
+
+	growth := new(big.Int).Sub(rootK, rootKLast)
+	denominator := new(big.Int).Mul(rootK, big.NewInt(6))
+
+	amount0 := new(big.Int).Mul(reserve0, growth)
+	amount0.Quo(amount0, denominator)
+	amount1 := new(big.Int).Mul(reserve1, growth)
+	amount1.Quo(amount1, denominator)
+
+	return amount0, amount1
+}
+
+// APR keeps a rolling window of the fees earned by the liquidity providers of
+// a pair to compute their annualized return on the reserves.
+type APR struct {
+	window  time.Duration
+	entries []aprEntry
+	sum     float64
+}
+
+type aprEntry struct {
+	timestamp time.Time
+	value     float64
+}
+
+func NewAPR(window time.Duration) *APR {
+	a := APR{
+		window: window,
+	}
+	return &a
+}
+
+// Add includes the fees earned in one block, valued in the same unit as the
+// given reserves, and returns the annualized fees over the window relative to
+// the current value of the reserves. Until a full window has been observed, the
+// missing part of the window counts as having earned no fees.
+func (a *APR) Add(timestamp time.Time, fees float64, reserves float64) float64 {
+
+	a.entries = append(a.entries, aprEntry{timestamp: timestamp, value: fees})
+	a.sum += fees
+
+	cutoff := timestamp.Add(-a.window)
+	expired := 0
+	for _, entry := range a.entries {
+		if entry.timestamp.After(cutoff) {
+			break
+		}
+		a.sum -= entry.value
+		expired++
+	}
+	a.entries = a.entries[expired:]
+
+	if reserves <= 0 {
+		return 0
+	}
+
+	return a.sum / reserves * float64(year) / float64(a.window)
+}
+
+// clone returns a copy of the rolling window that can be continued
+// independently.
+func (a *APR) clone() *APR {
+	clone := *a
+	clone.entries = append([]aprEntry(nil), a.entries...)
+	return &clone
+}
+
+// AmountOut returns the output amount of a swap with the given input amount,
+// as computed by the pair after charging the fee on the input.
+func (f Fees) AmountOut(amountIn *big.Int, reserveIn *big.Int, reserveOut *big.Int) *big.Int {
+
+	amountInWithFee := new(big.Int).Mul(amountIn, big.NewInt(10000-f.bps))
+	numerator := new(big.Int).Mul(amountInWithFee, reserveOut)
+	denominator := new(big.Int).Mul(reserveIn, big.NewInt(10000))
+	denominator.Add(denominator, amountInWithFee)
+	if denominator.Sign() == 0 {
+		return big.NewInt(0)
+	}
+
+	return numerator.Quo(numerator, denominator)
+}
+
+// AmountIn returns the input amount a swap needs for the given output amount,
+// as computed by the router, or nil if the reserves cannot cover the output.
+func (f Fees) AmountIn(amountOut *big.Int, reserveIn *big.Int, reserveOut *big.Int) *big.Int {
+
+	if amountOut.Cmp(reserveOut) >= 0 {
+		return nil
+	}
+
+	numerator := new(big.Int).Mul(reserveIn, amountOut)
+	numerator.Mul(numerator, big.NewInt(10000))
+	denominator := new(big.Int).Sub(reserveOut, amountOut)
+	denominator.Mul(denominator, big.NewInt(10000-f.bps))
+
+	numerator.Quo(numerator, denominator)
+	return numerator.Add(numerator, big.NewInt(1))
+}
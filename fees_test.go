@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestProtocolMint(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		reserve0 int64
+		reserve1 int64
+		kLast    *big.Int
+		want0    int64
+		want1    int64
+	}{
+		{
+			name:     "protocol fee off",
+			reserve0: 1000,
+			reserve1: 1000,
+			kLast:    nil,
+		},
+		{
+			name:     "first mint",
+			reserve0: 1000,
+			reserve1: 1000,
+			kLast:    big.NewInt(0),
+		},
+		{
+			name:     "no growth",
+			reserve0: 1000,
+			reserve1: 1000,
+			kLast:    big.NewInt(1000000),
+		},
+		{
+			name:     "shrunk",
+			reserve0: 900,
+			reserve1: 900,
+			kLast:    big.NewInt(1000000),
+		},
+		{
+			name:     "balanced growth",
+			reserve0: 1000,
+			reserve1: 1000,
+			kLast:    big.NewInt(810000),
+			want0:    16,
+			want1:    16,
+		},
+		{
+			name:     "unbalanced growth",
+			reserve0: 4000,
+			reserve1: 1000,
+			kLast:    big.NewInt(2560000),
+			want0:    133,
+			want1:    33,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			amount0, amount1 := protocolMint(big.NewInt(test.reserve0), big.NewInt(test.reserve1), test.kLast)
+			if amount0.Cmp(big.NewInt(test.want0)) != 0 || amount1.Cmp(big.NewInt(test.want1)) != 0 {
+				t.Errorf("amounts = %s/%s, want %d/%d", amount0, amount1, test.want0, test.want1)
+			}
+		})
+	}
+}
+
+func TestFeesAmountOut(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		bps        uint
+		amountIn   int64
+		reserveIn  int64
+		reserveOut int64
+		want       int64
+	}{
+		{name: "no fee", bps: 0, amountIn: 1000, reserveIn: 1000000, reserveOut: 1000000, want: 999},
+		{name: "default fee", bps: 30, amountIn: 1000, reserveIn: 1000000, reserveOut: 2000000, want: 1992},
+		{name: "large input", bps: 30, amountIn: 100000, reserveIn: 1000000, reserveOut: 1000000, want: 90661},
+		{name: "no input", bps: 30, amountIn: 0, reserveIn: 1000000, reserveOut: 1000000, want: 0},
+		{name: "empty pair", bps: 30, amountIn: 1000, reserveIn: 0, reserveOut: 0, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := NewFees(test.bps).AmountOut(big.NewInt(test.amountIn), big.NewInt(test.reserveIn), big.NewInt(test.reserveOut))
+			if got.Cmp(big.NewInt(test.want)) != 0 {
+				t.Errorf("amount out = %s, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFeesAmountIn(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		bps        uint
+		amountOut  int64
+		reserveIn  int64
+		reserveOut int64
+		want       *big.Int
+	}{
+		{name: "no fee", bps: 0, amountOut: 500, reserveIn: 1000, reserveOut: 1000, want: big.NewInt(1001)},
+		{name: "inverse of amount out", bps: 30, amountOut: 90661, reserveIn: 1000000, reserveOut: 1000000, want: big.NewInt(100000)},
+		{name: "no output", bps: 30, amountOut: 0, reserveIn: 1000000, reserveOut: 1000000, want: big.NewInt(1)},
+		{name: "whole reserve", bps: 30, amountOut: 1000000, reserveIn: 1000000, reserveOut: 1000000, want: nil},
+		{name: "beyond reserve", bps: 30, amountOut: 2000000, reserveIn: 1000000, reserveOut: 1000000, want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := NewFees(test.bps).AmountIn(big.NewInt(test.amountOut), big.NewInt(test.reserveIn), big.NewInt(test.reserveOut))
+			if test.want == nil {
+				if got != nil {
+					t.Errorf("amount in = %s, want none", got)
+				}
+				return
+			}
+			if got == nil || got.Cmp(test.want) != 0 {
+				t.Errorf("amount in = %v, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,616 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/xitongsys/parquet-go-source/local"
+	pqreader "github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// Row is a raw datapoint of a pair as written to files, with its amounts as
+// exact decimal strings in the smallest unit of each token.
+type Row struct {
+	Chain          string `parquet:"name=chain, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PairAddress    string `parquet:"name=pair_address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Pair           string `parquet:"name=pair, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Height         int64  `parquet:"name=height, type=INT64"`
+	Timestamp      int64  `parquet:"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Token0Address  string `parquet:"name=token0_address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Token0Symbol   string `parquet:"name=token0_symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Token0Decimals int32  `parquet:"name=token0_decimals, type=INT32"`
+	Token1Address  string `parquet:"name=token1_address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Token1Symbol   string `parquet:"name=token1_symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Token1Decimals int32  `parquet:"name=token1_decimals, type=INT32"`
+	Reserve0       string `parquet:"name=reserve0, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Reserve1       string `parquet:"name=reserve1, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Volume0        string `parquet:"name=volume0, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Volume1        string `parquet:"name=volume1, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Fee0           string `parquet:"name=fee0, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Fee1           string `parquet:"name=fee1, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Transactions   int64  `parquet:"name=transactions, type=INT64"`
+	Swaps          int64  `parquet:"name=swaps, type=INT64"`
+}
+
+var rowHeader = []string{
+	"chain", "pair_address", "pair", "height", "timestamp",
+	"token0_address", "token0_symbol", "token0_decimals",
+	"token1_address", "token1_symbol", "token1_decimals",
+	"reserve0", "reserve1", "volume0", "volume1", "fee0", "fee1",
+	"transactions", "swaps",
+}
+
+func (r Row) record() []string {
+	return []string{
+		r.Chain, r.PairAddress, r.Pair,
+		strconv.FormatInt(r.Height, 10),
+		time.UnixMilli(r.Timestamp).UTC().Format(time.RFC3339),
+		r.Token0Address, r.Token0Symbol, strconv.FormatInt(int64(r.Token0Decimals), 10),
+		r.Token1Address, r.Token1Symbol, strconv.FormatInt(int64(r.Token1Decimals), 10),
+		r.Reserve0, r.Reserve1, r.Volume0, r.Volume1, r.Fee0, r.Fee1,
+		strconv.FormatInt(r.Transactions, 10),
+		strconv.FormatInt(r.Swaps, 10),
+	}
+}
+
+// newRow converts a raw datapoint of the given pool into a row.
+func newRow(chainName string, pool *Pool, point *write.Point) (Row, error) {
+
+	r := Row{
+		Chain:          chainName,
+		PairAddress:    pool.Address.Hex(),
+		Pair:           pool.Name,
+		Timestamp:      point.Time().Truncate(time.Second).UnixMilli(),
+		Token0Address:  pool.Token0.Address.Hex(),
+		Token0Symbol:   pool.Token0.Symbol,
+		Token0Decimals: int32(pool.Token0.Decimals),
+		Token1Address:  pool.Token1.Address.Hex(),
+		Token1Symbol:   pool.Token1.Symbol,
+		Token1Decimals: int32(pool.Token1.Decimals),
+	}
+
+	amounts := map[string]*string{
+		"reserve0": &r.Reserve0,
+		"reserve1": &r.Reserve1,
+		"volume0":  &r.Volume0,
+		"volume1":  &r.Volume1,
+		"fee0":     &r.Fee0,
+		"fee1":     &r.Fee1,
+	}
+	counts := map[string]*int64{
+		"height":       &r.Height,
+		"transactions": &r.Transactions,
+		"swaps":        &r.Swaps,
+	}
+	for _, field := range point.FieldList() {
+		amount, ok := amounts[field.Key]
+		if ok {
+			encoded, _ := field.Value.(string)
+			decoded, err := decodeAmount(encoded)
+			if err != nil {
+				return Row{}, fmt.Errorf("could not decode field (%s): %w", field.Key, err)
+			}
+			*amount = decoded.String()
+			continue
+		}
+		count, ok := counts[field.Key]
+		if ok {
+			value, _ := field.Value.(uint64)
+			*count = int64(value)
+		}
+	}
+
+	return r, nil
+}
+
+// newDatapointRow converts a datapoint read back from storage into a row.
+func newDatapointRow(chainName string, pool *Pool, point Datapoint) Row {
+	return Row{
+		Chain:          chainName,
+		PairAddress:    pool.Address.Hex(),
+		Pair:           pool.Name,
+		Height:         int64(point.Height),
+		Timestamp:      point.Timestamp.Truncate(time.Second).UnixMilli(),
+		Token0Address:  pool.Token0.Address.Hex(),
+		Token0Symbol:   pool.Token0.Symbol,
+		Token0Decimals: int32(pool.Token0.Decimals),
+		Token1Address:  pool.Token1.Address.Hex(),
+		Token1Symbol:   pool.Token1.Symbol,
+		Token1Decimals: int32(pool.Token1.Decimals),
+		Reserve0:       point.Reserve0.String(),
+		Reserve1:       point.Reserve1.String(),
+		Volume0:        point.Volume0.String(),
+		Volume1:        point.Volume1.String(),
+		Fee0:           point.Fee0.String(),
+		Fee1:           point.Fee1.String(),
+		Transactions:   int64(point.Transactions),
+		Swaps:          int64(point.Swaps),
+	}
+}
+
+// FileSink writes the raw datapoints to CSV or Parquet files, partitioned by
+// chain and pair. Files are rotated when the heights cross a multiple of the
+// block range, or when they reach the given size; zero disables either
+// rotation. Each file is named after the first height of its block range and
+// its sequence number within the range. When a run first writes to a block
+// range, the rows from its first height on are removed from the existing files
+// of the range, so processing a range again replaces its rows, wherever the
+// range starts.
+type FileSink struct {
+	dir          string
+	format       string
+	chainName    string
+	rotateBlocks uint64
+	rotateSize   int64
+	pools        map[common.Address]*Pool
+	files        map[string]*rowFile
+	prepared     map[string]struct{}
+}
+
+// rowFile is the file currently written for a partition.
+type rowFile struct {
+	file    *os.File
+	counter *countingWriter
+	csv     *csv.Writer
+	parquet *writer.ParquetWriter
+	bucket  uint64
+}
+
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (c *countingWriter) Write(data []byte) (int, error) {
+	n, err := c.writer.Write(data)
+	c.count += int64(n)
+	return n, err
+}
+
+func NewFileSink(dir string, format string, rotateBlocks uint64, rotateSize int64) (*FileSink, error) {
+
+	if format != FormatCSV && format != FormatParquet {
+		return nil, fmt.Errorf("unknown file format (%s)", format)
+	}
+
+	s := FileSink{
+		dir:          dir,
+		format:       format,
+		rotateBlocks: rotateBlocks,
+		rotateSize:   rotateSize,
+		pools:        make(map[common.Address]*Pool),
+		files:        make(map[string]*rowFile),
+		prepared:     make(map[string]struct{}),
+	}
+
+	return &s, nil
+}
+
+// Track sets the chain and pools whose datapoints are written to files.
+func (s *FileSink) Track(chainName string, pools []*Pool) error {
+	s.chainName = chainName
+	for _, pool := range pools {
+		s.pools[pool.Address] = pool
+	}
+	return nil
+}
+
+// Write writes the raw datapoints of the known pairs and skips all others.
+func (s *FileSink) Write(points []*write.Point) error {
+
+	for _, point := range points {
+
+		if point.Name() != measurement {
+			continue
+		}
+		var pool *Pool
+		for _, tag := range point.TagList() {
+			if tag.Key == "pair_address" {
+				pool = s.pools[common.HexToAddress(tag.Value)]
+			}
+		}
+		if pool == nil {
+			continue
+		}
+
+		row, err := newRow(s.chainName, pool, point)
+		if err != nil {
+			return err
+		}
+
+		partition := filepath.Join(s.dir, "chain="+s.chainName, "pair="+pool.Address.Hex())
+		file, err := s.file(partition, uint64(row.Height))
+		if err != nil {
+			return fmt.Errorf("could not open file for partition (%s): %w", partition, err)
+		}
+
+		if file.csv != nil {
+			err = file.csv.Write(row.record())
+		} else {
+			err = file.parquet.Write(row)
+		}
+		if err != nil {
+			return fmt.Errorf("could not write row (%s, %d): %w", pool.Name, row.Height, err)
+		}
+	}
+
+	return nil
+}
+
+// file returns the file of a partition for the given height, which rotates the
+// current file if needed.
+func (s *FileSink) file(partition string, height uint64) (*rowFile, error) {
+
+	bucket := uint64(0)
+	if s.rotateBlocks > 0 {
+		bucket = height / s.rotateBlocks
+	}
+
+	current, ok := s.files[partition]
+	if ok && current.bucket == bucket && (s.rotateSize == 0 || current.counter.count < s.rotateSize) {
+		return current, nil
+	}
+	if ok {
+		err := current.close()
+		if err != nil {
+			return nil, err
+		}
+		delete(s.files, partition)
+	}
+
+	err := os.MkdirAll(partition, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create directory: %w", err)
+	}
+
+	// The rows that are written again are removed from the files of the
+	// block range once per run; rotating by size afterwards only adds files.
+	key := filepath.Join(partition, strconv.FormatUint(bucket, 10))
+	_, ok = s.prepared[key]
+	if !ok {
+		err = s.drop(partition, bucket, height, false)
+		if err != nil {
+			return nil, err
+		}
+		s.prepared[key] = struct{}{}
+	}
+
+	files, err := s.list(partition, bucket, false)
+	if err != nil {
+		return nil, err
+	}
+	sequence := 0
+	if len(files) > 0 {
+		sequence = files[len(files)-1].sequence + 1
+	}
+
+	file, err := os.Create(filepath.Join(partition, s.name(bucket, sequence)))
+	if err != nil {
+		return nil, fmt.Errorf("could not create file: %w", err)
+	}
+
+	next := rowFile{
+		file:    file,
+		counter: &countingWriter{writer: file},
+		bucket:  bucket,
+	}
+	switch s.format {
+	case FormatCSV:
+		next.csv = csv.NewWriter(next.counter)
+		err = next.csv.Write(rowHeader)
+	case FormatParquet:
+		next.parquet, err = writer.NewParquetWriterFromWriter(next.counter, new(Row), 1)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("could not initialize file: %w", err)
+	}
+
+	s.files[partition] = &next
+
+	return &next, nil
+}
+
+// partFile is an existing file of a partition.
+type partFile struct {
+	path     string
+	bucket   uint64
+	sequence int
+}
+
+// name returns the name of the file with the given sequence number within the
+// given block range.
+func (s *FileSink) name(bucket uint64, sequence int) string {
+	return fmt.Sprintf("%010d-%04d.%s", bucket*s.rotateBlocks, sequence, s.format)
+}
+
+// list returns the files of a partition for the given block range, or for all
+// block ranges from the given one on, ordered by range and sequence number.
+func (s *FileSink) list(partition string, bucket uint64, later bool) ([]partFile, error) {
+
+	entries, err := os.ReadDir(partition)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list files: %w", err)
+	}
+
+	var files []partFile
+	for _, entry := range entries {
+
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, "."+s.format) {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(name, "."+s.format), "-")
+		if len(parts) != 2 {
+			continue
+		}
+		start, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		sequence, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+
+		file := partFile{
+			path:     filepath.Join(partition, name),
+			sequence: sequence,
+		}
+		if s.rotateBlocks > 0 {
+			file.bucket = start / s.rotateBlocks
+		}
+		if file.bucket == bucket || (later && file.bucket > bucket) {
+			files = append(files, file)
+		}
+	}
+
+	sort.Slice(files, func(i int, j int) bool {
+		if files[i].bucket != files[j].bucket {
+			return files[i].bucket < files[j].bucket
+		}
+		return files[i].sequence < files[j].sequence
+	})
+
+	return files, nil
+}
+
+// drop removes the rows from the given height on from the files of a
+// partition for the given block range, or for all block ranges from the given
+// one on; files without rows left are removed.
+func (s *FileSink) drop(partition string, bucket uint64, height uint64, later bool) error {
+
+	files, err := s.list(partition, bucket, later)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		switch s.format {
+		case FormatCSV:
+			err = dropRecords(file.path, height)
+		case FormatParquet:
+			err = dropRows(file.path, height)
+		}
+		if err != nil {
+			return fmt.Errorf("could not remove rows from file (%s): %w", file.path, err)
+		}
+	}
+
+	return nil
+}
+
+// dropRecords removes the records from the given height on from a CSV file.
+// A record cut short by an interrupted write is removed as well.
+func dropRecords(path string, height uint64) error {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	_ = file.Close()
+	if err != nil {
+		return fmt.Errorf("could not read records: %w", err)
+	}
+
+	kept := make([][]string, 0, len(records))
+	for i, record := range records {
+		if len(record) != len(rowHeader) {
+			continue
+		}
+		if i == 0 {
+			kept = append(kept, record)
+			continue
+		}
+		recorded, err := strconv.ParseUint(record[3], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid height (%s): %w", record[3], err)
+		}
+		if recorded < height {
+			kept = append(kept, record)
+		}
+	}
+
+	if len(kept) <= 1 {
+		return os.Remove(path)
+	}
+	if len(kept) == len(records) {
+		return nil
+	}
+
+	return replaceFile(path, func(w io.Writer) error {
+		records := csv.NewWriter(w)
+		return records.WriteAll(kept)
+	})
+}
+
+// dropRows removes the rows from the given height on from a Parquet file. A
+// file that was not closed has no footer and cannot be read, so it is removed.
+func dropRows(path string, height uint64) error {
+
+	source, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return err
+	}
+	reader, err := pqreader.NewParquetReader(source, new(Row), 1)
+	if err != nil {
+		_ = source.Close()
+		return os.Remove(path)
+	}
+	rows := make([]Row, reader.GetNumRows())
+	err = reader.Read(&rows)
+	reader.ReadStop()
+	_ = source.Close()
+	if err != nil {
+		return fmt.Errorf("could not read rows: %w", err)
+	}
+
+	kept := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		if uint64(row.Height) < height {
+			kept = append(kept, row)
+		}
+	}
+
+	if len(kept) == 0 {
+		return os.Remove(path)
+	}
+	if len(kept) == len(rows) {
+		return nil
+	}
+
+	return replaceFile(path, func(w io.Writer) error {
+		rows, err := writer.NewParquetWriterFromWriter(w, new(Row), 1)
+		if err != nil {
+			return err
+		}
+		for _, row := range kept {
+			err = rows.Write(row)
+			if err != nil {
+				return err
+			}
+		}
+		return rows.WriteStop()
+	})
+}
+
+// replaceFile writes a new version of a file next to it and then replaces the
+// file with it, so that an interrupted write leaves the file as it was.
+func replaceFile(path string, write func(io.Writer) error) error {
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func (s *FileSink) Flush() error {
+	for partition, file := range s.files {
+		err := file.flush()
+		if err != nil {
+			return fmt.Errorf("could not flush file for partition (%s): %w", partition, err)
+		}
+	}
+	return nil
+}
+
+// Checkpoint flushes the rows to the files; for Parquet files, this completes
+// a row group, which only becomes readable once the file is closed.
+func (s *FileSink) Checkpoint(height uint64) error {
+	return s.Flush()
+}
+
+func (s *FileSink) Close() error {
+	for partition, file := range s.files {
+		err := file.close()
+		if err != nil {
+			return fmt.Errorf("could not close file for partition (%s): %w", partition, err)
+		}
+		delete(s.files, partition)
+	}
+	return nil
+}
+
+// Revert closes the current files and removes the rows from the given height
+// on from the files of all partitions.
+func (s *FileSink) Revert(height uint64, since time.Time) error {
+
+	err := s.Close()
+	if err != nil {
+		return err
+	}
+
+	bucket := uint64(0)
+	if s.rotateBlocks > 0 {
+		bucket = height / s.rotateBlocks
+	}
+	for _, pool := range s.pools {
+		partition := filepath.Join(s.dir, "chain="+s.chainName, "pair="+pool.Address.Hex())
+		err = s.drop(partition, bucket, height, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *rowFile) flush() error {
+	if f.csv != nil {
+		f.csv.Flush()
+		return f.csv.Error()
+	}
+	return f.parquet.Flush(true)
+}
+
+func (f *rowFile) close() error {
+	if f.csv != nil {
+		f.csv.Flush()
+		err := f.csv.Error()
+		if err != nil {
+			return err
+		}
+	} else {
+		err := f.parquet.WriteStop()
+		if err != nil {
+			return err
+		}
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Gap is a range of heights with events of a pair for which no datapoints are
+// stored; the heights in between without events are part of the range.
+type Gap struct {
+	From    uint64
+	To      uint64
+	Missing int
+}
+
+// findGaps returns the ranges of consecutive event heights, in ascending order,
+// that have no stored datapoint.
+func findGaps(events []uint64, stored map[uint64]struct{}) []Gap {
+
+	var gaps []Gap
+	var current *Gap
+	for _, height := range events {
+		_, ok := stored[height]
+		if ok {
+			current = nil
+			continue
+		}
+		if current == nil {
+			gaps = append(gaps, Gap{From: height})
+			current = &gaps[len(gaps)-1]
+		}
+		current.To = height
+		current.Missing++
+	}
+
+	return gaps
+}
+
+// mergeGaps combines the gaps of several pairs into non-overlapping ranges.
+func mergeGaps(gaps []Gap) []Gap {
+
+	sorted := append([]Gap{}, gaps...)
+	sort.Slice(sorted, func(i int, j int) bool {
+		return sorted[i].From < sorted[j].From
+	})
+
+	var merged []Gap
+	for _, gap := range sorted {
+		if len(merged) > 0 && gap.From <= merged[len(merged)-1].To+1 {
+			last := &merged[len(merged)-1]
+			if gap.To > last.To {
+				last.To = gap.To
+			}
+			last.Missing += gap.Missing
+			continue
+		}
+		merged = append(merged, gap)
+	}
+
+	return merged
+}
+
+// backfillGaps compares the datapoints stored for a set of pairs with the
+// heights at which the pairs had events, lists the ranges without datapoints,
+// and runs the ingestion again for exactly those ranges. The stored heights are
+// read from the SQLite store when it is one of the sinks, and from InfluxDB
+// otherwise. Only the raw datapoints are written again; candles can then be
+// rebuilt with the candles command, and the rolling APR restarts at the
+// beginning of each range.
+func backfillGaps(args []string) {
+
+	var (
+		logLevel  string
+		batchSize uint
+		scanSize  uint
+		list      bool
+
+		pairAddresses []string
+		startHeight   uint64
+		endHeight     uint64
+
+		apiURL string
+
+		sinks SinkOptions
+
+		config Config
+	)
+
+	flags := pflag.NewFlagSet("gaps", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+	flags.UintVarP(&batchSize, "batch-size", "b", 100, "number of blocks to cover per request for log entries when backfilling")
+	flags.UintVar(&scanSize, "scan-size", 10000, "number of blocks to cover per request when scanning for event heights")
+	flags.BoolVar(&list, "list", false, "whether to only list the missing ranges without backfilling them")
+
+	flags.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL of an archive node")
+	flags.StringSliceVarP(&pairAddresses, "pair-addresses", "p", []string{"0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc"}, "Ethereum addresses for Uniswap v2 pairs")
+	flags.Uint64VarP(&startHeight, "start-height", "s", 10019997, "start height of the range to inspect")
+	flags.Uint64VarP(&endHeight, "end-height", "e", 0, "end height of the range to inspect (defaults to the last height)")
+
+	bindSinkFlags(flags, &sinks)
+
+	// Candles, anomaly reports and arbitrage opportunities span more than the
+	// blocks of a range, so they would only cover the backfilled part of their
+	// intervals and are not derived; their settings are hidden.
+	bindConfigFlags(flags, &config)
+	spanning := []string{"candle-intervals", "detect-arbitrage", "max-hops", "detect-wash-trading", "anomaly-interval", "circular-window", "dominance-threshold", "turnover-threshold"}
+	for _, name := range spanning {
+		_ = flags.MarkHidden(name)
+	}
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	config.CandleIntervals = nil
+	config.DetectArbitrage = false
+	config.DetectWashTrading = false
+
+	if scanSize == 0 || batchSize == 0 {
+		log.Fatal().Uint("scan_size", scanSize).Uint("batch_size", batchSize).Msg("need positive scan and batch sizes")
+	}
+
+	client, chainName := connectChain(log, apiURL)
+
+	var err error
+	if endHeight == 0 {
+		endHeight, err = client.BlockNumber(context.Background())
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not get last block height")
+		}
+	}
+	if endHeight < startHeight {
+		log.Fatal().Uint64("start_height", startHeight).Uint64("end_height", endHeight).Msg("end height before start height")
+	}
+
+	first, err := client.HeaderByNumber(context.Background(), new(big.Int).SetUint64(startHeight))
+	if err != nil {
+		log.Fatal().Uint64("height", startHeight).Err(err).Msg("could not get header for start height")
+	}
+	last, err := client.HeaderByNumber(context.Background(), new(big.Int).SetUint64(endHeight))
+	if err != nil {
+		log.Fatal().Uint64("height", endHeight).Err(err).Msg("could not get header for end height")
+	}
+	start := time.Unix(int64(first.Time), 0).UTC()
+	end := time.Unix(int64(last.Time), 0).UTC().Add(time.Second)
+
+	// The missing datapoints are written to all sinks, while the stored
+	// heights are read from one of them.
+	sink, store, err := openSinks(log, sinks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not open sinks")
+	}
+	source, err := openSource(sinks, store)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not connect to InfluxDB API")
+	}
+
+	log = log.With().
+		Str("bucket", sinks.InfluxBucket).
+		Str("measurement", measurement).
+		Str("chain_name", chainName).
+		Logger()
+
+	// Every operation of a pair emits a sync event, so the heights with sync
+	// events are exactly the heights that should have a datapoint.
+	addresses := make([]common.Address, 0, len(pairAddresses))
+	for _, pairAddress := range pairAddresses {
+		addresses = append(addresses, common.HexToAddress(pairAddress))
+	}
+	events := make(map[common.Address][]uint64)
+	for from := startHeight; from <= endHeight; from += uint64(scanSize) {
+
+		to := from + uint64(scanSize) - 1
+		if to > endHeight {
+			to = endHeight
+		}
+
+		filter := ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: addresses,
+			Topics:    [][]common.Hash{{SigSync}},
+		}
+		entries, err := client.FilterLogs(context.Background(), filter)
+		if err != nil {
+			log.Fatal().Uint64("from", from).Uint64("to", to).Err(err).Msg("could not retrieve filtered log entries")
+		}
+
+		for _, entry := range entries {
+			heights := events[entry.Address]
+			if len(heights) > 0 && heights[len(heights)-1] == entry.BlockNumber {
+				continue
+			}
+			events[entry.Address] = append(heights, entry.BlockNumber)
+		}
+
+		log.Debug().Uint64("from", from).Uint64("to", to).Int("entries", len(entries)).Msg("scanned block range for event heights")
+	}
+
+	var gaps []Gap
+	for _, address := range addresses {
+
+		pool, err := fetchPool(client, address)
+		if err != nil {
+			log.Fatal().Str("pair_address", address.Hex()).Err(err).Msg("could not get pair metadata")
+		}
+
+		stored, err := source.Heights(chainName, pool, start, end)
+		if err != nil {
+			log.Fatal().Str("pair_name", pool.Name).Err(err).Msg("could not query stored heights")
+		}
+
+		missing := findGaps(events[address], stored)
+		for _, gap := range missing {
+			log.Info().
+				Str("pair_name", pool.Name).
+				Uint64("from", gap.From).
+				Uint64("to", gap.To).
+				Int("missing", gap.Missing).
+				Msg("missing datapoints for block range")
+		}
+
+		log.Info().
+			Str("pair_name", pool.Name).
+			Int("events", len(events[address])).
+			Int("stored", len(stored)).
+			Int("gaps", len(missing)).
+			Msg("inspected stored datapoints")
+
+		gaps = append(gaps, missing...)
+	}
+
+	if list || len(gaps) == 0 {
+		os.Exit(0)
+	}
+
+	config.WriteMetrics = true
+	miner, err := NewMiner(log, client, sink, chainName, pairAddresses, config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not initialize miner")
+	}
+
+	// Each range is processed from the state of the pools right before it, so
+	// the datapoints of pairs that were not missing are rewritten identically.
+	for _, gap := range mergeGaps(gaps) {
+
+		// No pair exists before the genesis block, so there is no state to load.
+		if gap.From > 0 {
+			miner.Load(gap.From - 1)
+		}
+
+		for from := gap.From; from <= gap.To; from += uint64(batchSize) {
+
+			to := from + uint64(batchSize) - 1
+			if to > gap.To {
+				to = gap.To
+			}
+
+			err = miner.Process(from, to)
+			if err != nil {
+				log.Fatal().Uint64("from", from).Uint64("to", to).Err(err).Msg("could not process block range")
+			}
+		}
+
+		log.Info().Uint64("from", gap.From).Uint64("to", gap.To).Msg("backfilled missing block range")
+	}
+
+	err = miner.Close()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not close miner")
+	}
+
+	log.Info().Int("gaps", len(gaps)).Msg("backfill of missing datapoints completed")
+
+	os.Exit(0)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindGaps(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		events []uint64
+		stored []uint64
+		want   []Gap
+	}{
+		{
+			name:   "no events",
+			events: nil,
+			stored: []uint64{10},
+			want:   nil,
+		},
+		{
+			name:   "all stored",
+			events: []uint64{10, 20, 30},
+			stored: []uint64{10, 20, 30},
+			want:   nil,
+		},
+		{
+			name:   "nothing stored",
+			events: []uint64{10, 20, 30},
+			stored: nil,
+			want:   []Gap{{From: 10, To: 30, Missing: 3}},
+		},
+		{
+			name:   "split by a stored height",
+			events: []uint64{10, 20, 30, 40},
+			stored: []uint64{20},
+			want:   []Gap{{From: 10, To: 10, Missing: 1}, {From: 30, To: 40, Missing: 2}},
+		},
+		{
+			name:   "stored heights without events",
+			events: []uint64{10, 30},
+			stored: []uint64{20},
+			want:   []Gap{{From: 10, To: 30, Missing: 2}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stored := make(map[uint64]struct{})
+			for _, height := range test.stored {
+				stored[height] = struct{}{}
+			}
+			got := findGaps(test.events, stored)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("gaps = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeGaps(t *testing.T) {
+
+	tests := []struct {
+		name string
+		gaps []Gap
+		want []Gap
+	}{
+		{
+			name: "no gaps",
+			gaps: nil,
+			want: nil,
+		},
+		{
+			name: "disjoint",
+			gaps: []Gap{{From: 30, To: 40, Missing: 2}, {From: 10, To: 20, Missing: 2}},
+			want: []Gap{{From: 10, To: 20, Missing: 2}, {From: 30, To: 40, Missing: 2}},
+		},
+		{
+			name: "adjacent",
+			gaps: []Gap{{From: 10, To: 20, Missing: 2}, {From: 21, To: 30, Missing: 1}},
+			want: []Gap{{From: 10, To: 30, Missing: 3}},
+		},
+		{
+			name: "overlapping",
+			gaps: []Gap{{From: 15, To: 40, Missing: 3}, {From: 10, To: 20, Missing: 2}},
+			want: []Gap{{From: 10, To: 40, Missing: 5}},
+		},
+		{
+			name: "contained",
+			gaps: []Gap{{From: 10, To: 40, Missing: 4}, {From: 20, To: 30, Missing: 2}},
+			want: []Gap{{From: 10, To: 40, Missing: 6}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := append([]Gap(nil), test.gaps...)
+			got := mergeGaps(test.gaps)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("merged gaps = %v, want %v", got, test.want)
+			}
+			if !reflect.DeepEqual(test.gaps, input) {
+				t.Errorf("input gaps changed to %v", test.gaps)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// connectInflux creates an InfluxDB client and checks that the API is ready.
+func connectInflux(url string, token string) (influxdb2.Client, error) {
+
+	influx := influxdb2.NewClient(url, token)
+	ok, err := influx.Ready(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("InfluxDB API not ready")
+	}
+
+	return influx, nil
+}
+
+// InfluxSource reads the datapoints stored in an InfluxDB bucket.
+type InfluxSource struct {
+	query  api.QueryAPI
+	bucket string
+}
+
+func NewInfluxSource(query api.QueryAPI, bucket string) *InfluxSource {
+
+	s := InfluxSource{
+		query:  query,
+		bucket: bucket,
+	}
+
+	return &s
+}
+
+// Datapoints reads the datapoints of a pair, which are found by the address of
+// the pair, as several pairs can share a name.
+func (s *InfluxSource) Datapoints(chainName string, pool *Pool, start time.Time, end time.Time, limit int, process func(Datapoint) error) error {
+	return queryDatapoints(s.query, s.bucket, chainName, pool.Address, start, end, limit, process)
+}
+
+func (s *InfluxSource) Heights(chainName string, pool *Pool, start time.Time, end time.Time) (map[uint64]struct{}, error) {
+	return queryHeights(s.query, s.bucket, chainName, pool.Address, start, end)
+}
+
+func (s *InfluxSource) Candles(chainName string, pool *Pool, interval string, start time.Time, end time.Time, limit int, process func(Candle) error) error {
+	return queryCandles(s.query, s.bucket, chainName, pool.Address, interval, start, end, limit, process)
+}
+
+// queryDatapoints reads the datapoints of a pair between the given timestamps,
+// in chronological order, and hands them to the given callback. A limit above
+// zero caps the number of datapoints, so that the server does not read the
+// whole range when only a page of it is needed.
+func queryDatapoints(query api.QueryAPI, bucket string, chainName string, pairAddress common.Address, start time.Time, end time.Time, limit int, process func(Datapoint) error) error {
+
+	flux := fmt.Sprintf(`from(bucket: %q)
+	|> range(start: %s, stop: %s)
+	|> filter(fn: (r) => r._measurement == %q and r.chain == %q and r.pair_address == %q)
+	|> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+	|> group()
+	|> sort(columns: ["_time", "height"])`,
+		bucket,
+		start.UTC().Format(time.RFC3339Nano),
+		end.UTC().Format(time.RFC3339Nano),
+		measurement,
+		chainName,
+		pairAddress.Hex(),
+	)
+	if limit > 0 {
+		flux += fmt.Sprintf("\n\t|> limit(n: %d)", limit)
+	}
+
+	result, err := query.Query(context.Background(), flux)
+	if err != nil {
+		return fmt.Errorf("could not execute query: %w", err)
+	}
+	defer result.Close()
+
+	for result.Next() {
+
+		record := result.Record()
+		point := Datapoint{
+			Timestamp: record.Time(),
+		}
+
+		// Datapoints written before the height and counts were added have none,
+		// which are left at zero.
+		counts := map[string]*uint64{
+			"height":       &point.Height,
+			"transactions": &point.Transactions,
+			"swaps":        &point.Swaps,
+		}
+		for name, value := range counts {
+			switch count := record.ValueByKey(name).(type) {
+			case uint64:
+				*value = count
+			case int64:
+				*value = uint64(count)
+			}
+		}
+
+		fields := map[string]**big.Int{
+			"reserve0": &point.Reserve0,
+			"reserve1": &point.Reserve1,
+			"volume0":  &point.Volume0,
+			"volume1":  &point.Volume1,
+			"fee0":     &point.Fee0,
+			"fee1":     &point.Fee1,
+		}
+		for name, value := range fields {
+			encoded, _ := record.ValueByKey(name).(string)
+			amount, err := decodeAmount(encoded)
+			if err != nil {
+				return fmt.Errorf("could not decode field (%s, %s): %w", name, record.Time(), err)
+			}
+			*value = amount
+		}
+
+		err = process(point)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = result.Err()
+	if err != nil {
+		return fmt.Errorf("could not read query result: %w", err)
+	}
+
+	return nil
+}
+
+// decodeAmount decodes an amount stored as the hexadecimal encoding of its
+// big-endian bytes; zero is stored as the empty string.
+func decodeAmount(encoded string) (*big.Int, error) {
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// queryHeights reads the heights of the datapoints of a pair between the given
+// timestamps. Datapoints written before the height was added are skipped.
+func queryHeights(query api.QueryAPI, bucket string, chainName string, pairAddress common.Address, start time.Time, end time.Time) (map[uint64]struct{}, error) {
+
+	flux := fmt.Sprintf(`from(bucket: %q)
+	|> range(start: %s, stop: %s)
+	|> filter(fn: (r) => r._measurement == %q and r.chain == %q and r.pair_address == %q and r._field == "height")
+	|> keep(columns: ["_time", "_value"])`,
+		bucket,
+		start.UTC().Format(time.RFC3339Nano),
+		end.UTC().Format(time.RFC3339Nano),
+		measurement,
+		chainName,
+		pairAddress.Hex(),
+	)
+
+	result, err := query.Query(context.Background(), flux)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %w", err)
+	}
+	defer result.Close()
+
+	heights := make(map[uint64]struct{})
+	for result.Next() {
+		switch height := result.Record().Value().(type) {
+		case uint64:
+			heights[height] = struct{}{}
+		case int64:
+			heights[uint64(height)] = struct{}{}
+		}
+	}
+
+	err = result.Err()
+	if err != nil {
+		return nil, fmt.Errorf("could not read query result: %w", err)
+	}
+
+	return heights, nil
+}
+
+// queryCandles reads the candles of a pair with the given interval that start
+// between the given timestamps, in chronological order, and hands them to the
+// given callback. A limit above zero caps the number of candles.
+func queryCandles(query api.QueryAPI, bucket string, chainName string, pairAddress common.Address, interval string, start time.Time, end time.Time, limit int, process func(Candle) error) error {
+
+	flux := fmt.Sprintf(`from(bucket: %q)
+	|> range(start: %s, stop: %s)
+	|> filter(fn: (r) => r._measurement == %q and r.chain == %q and r.pair_address == %q and r.interval == %q)
+	|> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+	|> group()
+	|> sort(columns: ["_time"])`,
+		bucket,
+		start.UTC().Format(time.RFC3339Nano),
+		end.UTC().Format(time.RFC3339Nano),
+		candleMeasurement,
+		chainName,
+		pairAddress.Hex(),
+		interval,
+	)
+	if limit > 0 {
+		flux += fmt.Sprintf("\n\t|> limit(n: %d)", limit)
+	}
+
+	result, err := query.Query(context.Background(), flux)
+	if err != nil {
+		return fmt.Errorf("could not execute query: %w", err)
+	}
+	defer result.Close()
+
+	for result.Next() {
+
+		record := result.Record()
+		candle := Candle{
+			Start: record.Time(),
+		}
+
+		prices := map[string]*float64{
+			"open":  &candle.Open,
+			"high":  &candle.High,
+			"low":   &candle.Low,
+			"close": &candle.Close,
+		}
+		for name, value := range prices {
+			*value, _ = record.ValueByKey(name).(float64)
+		}
+
+		fields := map[string]**big.Int{
+			"volume0": &candle.Volume0,
+			"volume1": &candle.Volume1,
+			"fee0":    &candle.Fee0,
+			"fee1":    &candle.Fee1,
+		}
+		for name, value := range fields {
+			encoded, _ := record.ValueByKey(name).(string)
+			amount, err := decodeAmount(encoded)
+			if err != nil {
+				return fmt.Errorf("could not decode field (%s, %s): %w", name, record.Time(), err)
+			}
+			*value = amount
+		}
+
+		err = process(candle)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = result.Err()
+	if err != nil {
+		return fmt.Errorf("could not read query result: %w", err)
+	}
+
+	return nil
+}
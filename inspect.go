@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	FormatHuman = "human"
+	FormatJSON  = "json"
+
+	// liquidityDecimals are the decimals of the liquidity tokens of all pairs.
+	liquidityDecimals = 18
+)
+
+// Inspection is the on-chain state of a pair at one height. Amounts are exact
+// decimal strings adjusted for the decimals of their token, except for the
+// invariant and the cumulative prices, which are raw contract values; the
+// cumulative prices are UQ112x112 fixed point numbers.
+type Inspection struct {
+	Chain     string    `json:"chain"`
+	Height    uint64    `json:"height"`
+	Timestamp time.Time `json:"timestamp"`
+
+	Pair    string         `json:"pair"`
+	Name    string         `json:"name"`
+	Factory string         `json:"factory"`
+	Token0  InspectedToken `json:"token0"`
+	Token1  InspectedToken `json:"token1"`
+
+	Reserve0   string    `json:"reserve0"`
+	Reserve1   string    `json:"reserve1"`
+	Price0     float64   `json:"price0"`
+	Price1     float64   `json:"price1"`
+	LastUpdate time.Time `json:"last_update"`
+
+	KLast                string `json:"k_last"`
+	Price0CumulativeLast string `json:"price0_cumulative_last"`
+	Price1CumulativeLast string `json:"price1_cumulative_last"`
+	TotalSupply          string `json:"total_supply"`
+
+	Swaps []InspectedSwap `json:"swaps"`
+}
+
+// InspectedToken is the metadata of a token of an inspected pair.
+type InspectedToken struct {
+	Address  string `json:"address"`
+	Symbol   string `json:"symbol"`
+	Decimals uint8  `json:"decimals"`
+}
+
+// InspectedSwap is a swap of an inspected pair, with its amounts adjusted for
+// the decimals of their token.
+type InspectedSwap struct {
+	Height     uint64    `json:"height"`
+	Timestamp  time.Time `json:"timestamp"`
+	TxHash     string    `json:"tx_hash"`
+	LogIndex   uint      `json:"log_index"`
+	Sender     string    `json:"sender"`
+	To         string    `json:"to"`
+	Amount0In  string    `json:"amount0_in"`
+	Amount1In  string    `json:"amount1_in"`
+	Amount0Out string    `json:"amount0_out"`
+	Amount1Out string    `json:"amount1_out"`
+}
+
+// inspectPair prints the state of pairs as returned by their contract at the
+// given height, along with their last swaps up to that height, so that a pair
+// can be debugged without writing scripts against its contract. Heights that
+// are not recent require an archive node.
+func inspectPair(args []string) {
+
+	var (
+		logLevel string
+
+		pairAddresses []string
+		height        uint64
+		swapCount     int
+		scanSize      uint64
+		maxScan       uint64
+		format        string
+
+		apiURL string
+	)
+
+	flags := pflag.NewFlagSet("inspect", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+
+	flags.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL")
+	flags.StringSliceVarP(&pairAddresses, "pair-addresses", "p", []string{"0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc"}, "Ethereum addresses for Uniswap v2 pairs")
+	flags.StringSliceVar(&pairAddresses, "pair-address", []string{"0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc"}, "Ethereum address for Uniswap v2 pair")
+	flags.Uint64Var(&height, "height", 0, "height at which to inspect the pair (defaults to the last height)")
+	flags.IntVarP(&swapCount, "swaps", "n", 10, "number of swaps up to the height to show")
+	flags.Uint64Var(&scanSize, "scan-size", 10000, "number of blocks to cover per request when searching for swaps")
+	flags.Uint64Var(&maxScan, "max-scan", 1000000, "maximum number of blocks to search for swaps before the height")
+	flags.StringVar(&format, "format", FormatHuman, "output format (human, json)")
+
+	_ = flags.MarkDeprecated("pair-address", "use --pair-addresses instead")
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	if format != FormatHuman && format != FormatJSON {
+		log.Fatal().Str("format", format).Msg("unknown output format")
+	}
+	if scanSize == 0 {
+		log.Fatal().Msg("need positive scan size")
+	}
+	if maxScan == 0 {
+		log.Fatal().Msg("need positive maximum number of blocks to scan")
+	}
+
+	client, chainName := connectChain(log, apiURL)
+
+	if height == 0 {
+		last, err := client.BlockNumber(context.Background())
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not get last block height")
+		}
+		height = last
+	}
+
+	// In JSON, each pair is printed as its own document.
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	for i, pairAddress := range pairAddresses {
+
+		inspection, err := inspect(client, chainName, common.HexToAddress(pairAddress), height)
+		if err != nil {
+			log.Fatal().Str("pair_address", pairAddress).Uint64("height", height).Err(err).Msg("could not inspect pair")
+		}
+
+		inspection.Swaps, err = lastSwaps(client, common.HexToAddress(pairAddress), inspection.Token0, inspection.Token1, height, swapCount, scanSize, maxScan)
+		if err != nil {
+			log.Fatal().Str("pair_address", pairAddress).Uint64("height", height).Err(err).Msg("could not get last swaps")
+		}
+
+		switch format {
+		case FormatJSON:
+			err = encoder.Encode(inspection)
+		case FormatHuman:
+			if i > 0 {
+				fmt.Println()
+			}
+			err = printInspection(inspection)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not print inspection")
+		}
+	}
+
+	os.Exit(0)
+}
+
+// inspect reads the state of the pair at the given height from its contract.
+func inspect(client *ethclient.Client, chainName string, address common.Address, height uint64) (*Inspection, error) {
+
+	header, err := client.HeaderByNumber(context.Background(), new(big.Int).SetUint64(height))
+	if err != nil {
+		return nil, fmt.Errorf("could not get header: %w", err)
+	}
+
+	pool, err := fetchPool(client, address)
+	if err != nil {
+		return nil, fmt.Errorf("could not get pair metadata: %w", err)
+	}
+
+	pairContract, err := NewPairCaller(address, client)
+	if err != nil {
+		return nil, fmt.Errorf("could not bind pair contract: %w", err)
+	}
+
+	opts := bind.CallOpts{
+		BlockNumber: new(big.Int).SetUint64(height),
+	}
+	factory, err := pairContract.Factory(&opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not get factory: %w", err)
+	}
+	reserves, err := pairContract.GetReserves(&opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not get reserves: %w", err)
+	}
+	kLast, err := pairContract.KLast(&opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not get last invariant: %w", err)
+	}
+	price0, err := pairContract.Price0CumulativeLast(&opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not get first cumulative price: %w", err)
+	}
+	price1, err := pairContract.Price1CumulativeLast(&opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not get second cumulative price: %w", err)
+	}
+	supply, err := pairContract.TotalSupply(&opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not get total supply: %w", err)
+	}
+
+	i := Inspection{
+		Chain:     chainName,
+		Height:    height,
+		Timestamp: time.Unix(int64(header.Time), 0).UTC(),
+
+		Pair:    pool.Address.Hex(),
+		Name:    pool.Name,
+		Factory: factory.Hex(),
+		Token0:  InspectedToken{Address: pool.Token0.Address.Hex(), Symbol: pool.Token0.Symbol, Decimals: pool.Token0.Decimals},
+		Token1:  InspectedToken{Address: pool.Token1.Address.Hex(), Symbol: pool.Token1.Symbol, Decimals: pool.Token1.Decimals},
+
+		Reserve0:   formatAmount(reserves.Reserve0, pool.Token0.Decimals),
+		Reserve1:   formatAmount(reserves.Reserve1, pool.Token1.Decimals),
+		Price0:     spotPrice(reserves.Reserve0, reserves.Reserve1, pool.Token0.Decimals, pool.Token1.Decimals),
+		Price1:     spotPrice(reserves.Reserve1, reserves.Reserve0, pool.Token1.Decimals, pool.Token0.Decimals),
+		LastUpdate: time.Unix(int64(reserves.BlockTimestampLast), 0).UTC(),
+
+		KLast:                kLast.String(),
+		Price0CumulativeLast: price0.String(),
+		Price1CumulativeLast: price1.String(),
+		TotalSupply:          formatAmount(supply, liquidityDecimals),
+	}
+
+	return &i, nil
+}
+
+// lastSwaps searches the swaps of the pair backwards from the given height, in
+// ranges of the scan size, until it found the given number of swaps or went
+// through the maximum number of blocks. The swaps are returned newest first.
+func lastSwaps(client *ethclient.Client, address common.Address, token0 InspectedToken, token1 InspectedToken, height uint64, count int, scanSize uint64, maxScan uint64) ([]InspectedSwap, error) {
+
+	pairABI, err := abi.JSON(strings.NewReader(PairMetaData.ABI))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Uniswap Pair ABI: %w", err)
+	}
+
+	swaps := make([]InspectedSwap, 0, count)
+	timestamps := make(map[uint64]time.Time)
+	scanned := uint64(0)
+	for to := height; len(swaps) < count && scanned < maxScan; {
+
+		size := scanSize
+		if size > maxScan-scanned {
+			size = maxScan - scanned
+		}
+		from := uint64(0)
+		if to >= size {
+			from = to - size + 1
+		}
+
+		filter := ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: []common.Address{address},
+			Topics:    [][]common.Hash{{SigSwap}},
+		}
+		entries, err := client.FilterLogs(context.Background(), filter)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve swap log entries (%d, %d): %w", from, to, err)
+		}
+
+		for i := len(entries) - 1; i >= 0 && len(swaps) < count; i-- {
+
+			entry := entries[i]
+			_, amounts, err := decodeEvent(pairABI, entry)
+			if err != nil {
+				return nil, err
+			}
+
+			timestamp, ok := timestamps[entry.BlockNumber]
+			if !ok {
+				header, err := client.HeaderByNumber(context.Background(), new(big.Int).SetUint64(entry.BlockNumber))
+				if err != nil {
+					return nil, fmt.Errorf("could not get header (%d): %w", entry.BlockNumber, err)
+				}
+				timestamp = time.Unix(int64(header.Time), 0).UTC()
+				timestamps[entry.BlockNumber] = timestamp
+			}
+
+			swaps = append(swaps, InspectedSwap{
+				Height:     entry.BlockNumber,
+				Timestamp:  timestamp,
+				TxHash:     entry.TxHash.Hex(),
+				LogIndex:   entry.Index,
+				Sender:     common.BytesToAddress(entry.Topics[1].Bytes()).Hex(),
+				To:         common.BytesToAddress(entry.Topics[2].Bytes()).Hex(),
+				Amount0In:  formatAmount(amounts["amount0_in"], token0.Decimals),
+				Amount1In:  formatAmount(amounts["amount1_in"], token1.Decimals),
+				Amount0Out: formatAmount(amounts["amount0_out"], token0.Decimals),
+				Amount1Out: formatAmount(amounts["amount1_out"], token1.Decimals),
+			})
+		}
+
+		scanned += to - from + 1
+		if from == 0 {
+			break
+		}
+		to = from - 1
+	}
+
+	return swaps, nil
+}
+
+// printInspection prints the inspection as aligned text.
+func printInspection(i *Inspection) error {
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Pair\t%s (%s)\n", i.Pair, i.Name)
+	fmt.Fprintf(w, "Chain\t%s\n", i.Chain)
+	fmt.Fprintf(w, "Height\t%d (%s)\n", i.Height, i.Timestamp.Format(time.RFC3339))
+	fmt.Fprintf(w, "Factory\t%s\n", i.Factory)
+	fmt.Fprintf(w, "Token0\t%s %s (%d decimals)\n", i.Token0.Address, i.Token0.Symbol, i.Token0.Decimals)
+	fmt.Fprintf(w, "Token1\t%s %s (%d decimals)\n", i.Token1.Address, i.Token1.Symbol, i.Token1.Decimals)
+	fmt.Fprintf(w, "Reserve0\t%s %s\n", i.Reserve0, i.Token0.Symbol)
+	fmt.Fprintf(w, "Reserve1\t%s %s\n", i.Reserve1, i.Token1.Symbol)
+	fmt.Fprintf(w, "Price0\t%g %s per %s\n", i.Price0, i.Token1.Symbol, i.Token0.Symbol)
+	fmt.Fprintf(w, "Price1\t%g %s per %s\n", i.Price1, i.Token0.Symbol, i.Token1.Symbol)
+	fmt.Fprintf(w, "Last update\t%s\n", i.LastUpdate.Format(time.RFC3339))
+	fmt.Fprintf(w, "kLast\t%s\n", i.KLast)
+	fmt.Fprintf(w, "Price0 cumulative\t%s\n", i.Price0CumulativeLast)
+	fmt.Fprintf(w, "Price1 cumulative\t%s\n", i.Price1CumulativeLast)
+	fmt.Fprintf(w, "LP supply\t%s\n", i.TotalSupply)
+
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nLast %d swaps:\n", len(i.Swaps))
+
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HEIGHT\tTIME\tTRANSACTION\tINDEX\tIN\tOUT\tTO")
+	for _, swap := range i.Swaps {
+		in, out := swapSides(swap, i.Token0.Symbol, i.Token1.Symbol)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			swap.Height, swap.Timestamp.Format(time.RFC3339), swap.TxHash, swap.LogIndex, in, out, swap.To)
+	}
+
+	return w.Flush()
+}
+
+// swapSides describes the amounts going into and out of the pair in a swap,
+// leaving out the zero amounts.
+func swapSides(swap InspectedSwap, symbol0 string, symbol1 string) (string, string) {
+
+	side := func(amount0 string, amount1 string) string {
+		var parts []string
+		if amount0 != "0" {
+			parts = append(parts, amount0+" "+symbol0)
+		}
+		if amount1 != "0" {
+			parts = append(parts, amount1+" "+symbol1)
+		}
+		return strings.Join(parts, " + ")
+	}
+
+	return side(swap.Amount0In, swap.Amount1In), side(swap.Amount0Out, swap.Amount1Out)
+}
@@ -2,28 +2,19 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
-	"encoding/json"
-	"math/big"
+	"fmt"
 	"os"
-	"sort"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/spf13/pflag"
 
-	_ "github.com/lib/pq"
-
-	"github.com/influxdata/influxdb-client-go/v2/api/write"
-
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 
-	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi"
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	_ "github.com/lib/pq"
 )
 
 const (
@@ -31,320 +22,364 @@ const (
 	chainList   = "chains.json"
 )
 
+// commands maps the name of each command to the function running it with the
+// remaining arguments.
+var commands = map[string]func(args []string){
+	"run":      runMiner,
+	"backfill": backfillRange,
+	"pairs":    pairsCommand,
+	"token":    tokenCommand,
+	"export":   exportDatapoints,
+	"status":   showStatus,
+	"inspect":  inspectPair,
+	"verify":   verifyReserves,
+	"gaps":     backfillGaps,
+	"candles":  recomputeCandles,
+	"position": trackPosition,
+	"mempool":  monitorMempool,
+	"replay":   replayArchive,
+}
+
 func main() {
 
+	// Without a command, the flags are those of the run command, as before
+	// the miner had commands.
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		runMiner(os.Args[1:])
+		return
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "help" {
+			fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
+	}
+
+	command(os.Args[2:])
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: klangbaach <command> [flags]
+
+Commands:
+  run          process the events of the pairs from a start height and optionally follow the chain head
+  backfill     process the events of the pairs for a fixed block range
+  pairs list   list the pairs stored in the SQLite database
+  token info   show the metadata of tokens from the chain
+  export       export the stored datapoints of pairs to a CSV or Parquet file
+  status       show the checkpoints of the SQLite database and the lag behind the chain head
+  inspect      show the on-chain state and last swaps of pairs
+  verify       compare stored reserves with the pair contracts
+  gaps         find and backfill heights with events but no datapoints
+  candles      recompute the candles of a pair from its datapoints
+  position     reconstruct the liquidity position of a holder
+  mempool      predict the moves of the pairs from pending transactions
+  replay       derive the datapoints again from the raw log entry archive
+
+Run "klangbaach <command> --help" for the flags of a command.
+`)
+}
+
+// runMiner processes the events of the pairs from the start height up to the
+// chain head, and then optionally keeps following the chain head.
+func runMiner(args []string) {
+
 	var (
-		logLevel     string
-		batchSize    uint
-		writeMetrics bool
+		logLevel  string
+		batchSize uint
+		dryRun    bool
+		follow    bool
 
-		pairAddress string
-		startHeight uint64
+		pairAddresses []string
+		startHeight   uint64
 
 		apiURL string
 
-		influxURL    string
-		influxToken  string
-		influxOrg    string
-		influxBucket string
+		sinks  SinkOptions
+		resume bool
+
+		metricsAddress string
+		httpAddress    string
+
+		config Config
 	)
 
-	pflag.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
-	pflag.BoolVarP(&writeMetrics, "write-metrics", "w", false, "whether to write the datapoints to InfluxDB")
-	pflag.UintVarP(&batchSize, "batch-size", "b", 100, "number of blocks to cover per request for log entries")
+	flags := pflag.NewFlagSet("run", pflag.ExitOnError)
 
-	pflag.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL")
-	pflag.StringVarP(&pairAddress, "pair-address", "p", "0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc", "Ethereum address for Uniswap v2 pair")
-	pflag.Uint64VarP(&startHeight, "start-height", "s", 10019997, "start height for parsing Uniswap v2 pair events")
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+	flags.BoolVarP(&config.WriteMetrics, "write-metrics", "w", false, "whether to write the datapoints to the sinks")
+	flags.UintVarP(&batchSize, "batch-size", "b", 100, "number of blocks to cover per request for log entries")
+	flags.BoolVarP(&follow, "follow", "f", false, "whether to keep following the chain head through a subscription after catching up, which requires a WebSocket API URL")
+	flags.BoolVar(&dryRun, "dry-run", false, "whether to compare the datapoints with the stored ones instead of writing them")
 
-	pflag.StringVarP(&influxURL, "influx-url", "i", "https://eu-central-1-1.aws.cloud2.influxdata.com", "InfluxDB API URL")
-	pflag.StringVarP(&influxOrg, "influx-org", "o", "optakt", "InfluxDB organization name")
-	pflag.StringVarP(&influxBucket, "influx-metrics-bucket", "m", "metrics", "InfluxDB bucket name")
-	pflag.StringVarP(&influxToken, "influx-token", "t", "", "InfluxDB authentication token")
+	flags.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL")
+	flags.StringSliceVarP(&pairAddresses, "pair-addresses", "p", []string{"0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc"}, "Ethereum addresses for Uniswap v2 pairs")
+	flags.StringSliceVar(&pairAddresses, "pair-address", []string{"0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc"}, "Ethereum address for Uniswap v2 pair")
+	flags.Uint64VarP(&startHeight, "start-height", "s", 10019997, "start height for parsing Uniswap v2 pair events")
 
-	pflag.Parse()
+	bindSinkFlags(flags, &sinks)
+	flags.BoolVar(&resume, "resume", false, "whether to resume after the last height checkpointed in the SQLite database instead of the start height")
 
-	zerolog.TimestampFunc = func() time.Time { return time.Now().UTC() }
-	log := zerolog.New(os.Stdout).With().Timestamp().Logger()
-	level, err := zerolog.ParseLevel(logLevel)
-	if err != nil {
-		log.Fatal().Str("log_level", logLevel).Err(err).Msg("invalid log level")
-	}
-	log = log.Level(level)
+	flags.StringVar(&metricsAddress, "metrics-address", "", "address to serve Prometheus metrics on under /metrics (e.g. :9100), empty to disable")
+	flags.StringVar(&httpAddress, "http-address", "", "address to serve the query API for the pair data on (e.g. :8080), empty to disable")
 
-	pairABI, err := abi.JSON(strings.NewReader(PairMetaData.ABI))
-	if err != nil {
-		log.Fatal().Err(err).Msg("invalid Uniswap Pair ABI")
-	}
+	bindConfigFlags(flags, &config)
 
-	chainsData, err := os.ReadFile(chainList)
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not read chain list")
-	}
+	_ = flags.MarkDeprecated("pair-address", "use --pair-addresses instead")
 
-	var chains []Chain
-	err = json.Unmarshal(chainsData, &chains)
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not decode chain list")
-	}
+	_ = flags.Parse(args)
 
-	chainLookup := make(map[uint64]string)
-	for _, chain := range chains {
-		chainLookup[chain.ChainID] = chain.Name
-	}
+	log := newLogger(logLevel)
 
-	client, err := ethclient.Dial(apiURL)
-	if err != nil {
-		log.Fatal().Str("api_url", apiURL).Err(err).Msg("could not connect to JSON RPC API")
+	if batchSize == 0 {
+		log.Fatal().Uint("batch_size", batchSize).Msg("need positive batch size")
 	}
 
-	chainID, err := client.ChainID(context.Background())
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not get chain ID")
+	if metricsAddress != "" {
+		serveMetrics(log, metricsAddress)
 	}
 
-	chainName, ok := chainLookup[chainID.Uint64()]
-	if !ok {
-		log.Fatal().Uint64("chain_id", chainID.Uint64()).Msg("unknown chain ID")
-	}
+	client, chainName := connectChain(log, apiURL)
 
 	lastHeight, err := client.BlockNumber(context.Background())
 	if err != nil {
 		log.Fatal().Err(err).Msg("could not get last block height")
 	}
+	observeHead(lastHeight)
 
-	pairContract, err := NewPairCaller(common.HexToAddress(pairAddress), client)
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not bind pair contract")
+	log = log.With().
+		Str("bucket", sinks.InfluxBucket).
+		Str("measurement", measurement).
+		Str("chain_name", chainName).
+		Logger()
+
+	// In dry-run mode, the datapoints are compared with the stored ones, which
+	// shows what processing the range again would change; nothing is written.
+	var sink *Fanout
+	var store *Store
+	if dryRun {
+		config.WriteMetrics = true
+		influx, err := connectInflux(sinks.InfluxURL, sinks.InfluxToken)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not connect to InfluxDB API")
+		}
+		sink = NewFanout(log)
+		sink.Add("diff", NewDiff(log, influx.QueryAPI(sinks.InfluxOrg), sinks.InfluxBucket), false)
+	} else {
+		sink, store, err = openSinks(log, sinks)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not open sinks")
+		}
 	}
 
-	address0, err := pairContract.Token0(nil)
+	miner, err := NewMiner(log, client, sink, chainName, pairAddresses, config)
 	if err != nil {
-		log.Fatal().Err(err).Msg("could not get first token address")
+		log.Fatal().Err(err).Msg("could not initialize miner")
 	}
-	address1, err := pairContract.Token1(nil)
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not get second token address")
+
+	// The query API reads from the SQLite store when it is one of the sinks,
+	// and from InfluxDB otherwise; the GraphQL API needs the events, which
+	// only the SQLite store keeps.
+	if httpAddress != "" {
+		source, err := openSource(sinks, store)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not connect to InfluxDB API")
+		}
+		server := NewServer(log, chainName, miner.Pools(), source)
+		if store != nil {
+			subgraph, err := NewSubgraph(store, chainName)
+			if err != nil {
+				log.Fatal().Err(err).Msg("could not initialize GraphQL API")
+			}
+			server.Handle("/graphql", subgraph)
+		}
+		server.Listen(httpAddress)
 	}
 
-	token0, err := NewERC20Caller(address0, client)
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not bind first token contract")
+	if resume {
+		if store == nil {
+			log.Fatal().Msg("resuming requires the sqlite sink")
+		}
+		height, ok, err := store.Checkpointed(chainName)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not read checkpoint")
+		}
+		if ok {
+			startHeight = height + 1
+			log.Info().Uint64("start_height", startHeight).Msg("resuming after checkpoint")
+		}
 	}
-	token1, err := NewERC20Caller(address1, client)
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not bind second token contract")
+
+	// No pair exists before the genesis block, so there is no state to load.
+	if startHeight > 0 {
+		miner.Load(startHeight - 1)
 	}
 
-	symbol0, err := token0.Symbol(nil)
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not get first token symbol")
+	processRange(log, miner, startHeight, lastHeight, batchSize)
+
+	if follow {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err = miner.Stream(ctx, lastHeight+1, uint64(batchSize))
+		cancel()
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not follow chain head")
+		}
+	}
+
+	// Without following the chain head, the query API keeps serving the
+	// processed range until the miner is interrupted.
+	if httpAddress != "" && !follow {
+		log.Info().Str("http_address", httpAddress).Msg("serving query API until interrupted")
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		<-ctx.Done()
+		cancel()
 	}
-	symbol1, err := token1.Symbol(nil)
+
+	err = miner.Close()
 	if err != nil {
-		log.Fatal().Err(err).Msg("could not get second token symbol")
+		log.Fatal().Err(err).Msg("could not close miner")
 	}
 
-	pairName := symbol0 + "/" + symbol1
+	log.Info().Msg("stopping klangbaach data miner")
 
-	log = log.With().
-		Str("bucket", influxBucket).
-		Str("measurement", measurement).
-		Str("chain_name", chainName).
-		Str("pair_name", pairName).
-		Logger()
+	os.Exit(0)
+}
 
-	log.Info().Msg("determined labels for datapoints")
+// SinkOptions holds the settings of the sinks the datapoints are written to.
+type SinkOptions struct {
+	Names      []string
+	BestEffort []string
 
-	influx := influxdb2.NewClient(influxURL, influxToken)
-	ok, err = influx.Ready(context.Background())
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not connect to InfluxDB API")
-	}
-	if !ok {
-		log.Fatal().Msg("InfluxDB API not ready")
-	}
+	InfluxURL    string
+	InfluxToken  string
+	InfluxOrg    string
+	InfluxBucket string
 
-	batch := influx.WriteAPI(influxOrg, influxBucket)
-	go func() {
-		for err := range batch.Errors() {
-			log.Fatal().Err(err).Msg("encountered InfluxDB error")
-		}
-	}()
+	FileDirectory    string
+	FileRotateBlocks uint64
+	FileRotateSize   int64
 
-	for from := startHeight; from < lastHeight; from += uint64(batchSize) {
+	SQLitePath  string
+	ArchivePath string
 
-		if from > lastHeight {
-			from = lastHeight
-		}
+	NATSURL       string
+	NATSPrefix    string
+	NATSJetStream bool
+}
 
-		to := from + uint64(batchSize) - 1
+// bindSinkFlags binds the flags of the sink settings to the given flag set.
+func bindSinkFlags(flags *pflag.FlagSet, options *SinkOptions) {
 
-		log = log.With().Uint64("from", from).Uint64("to", to).Logger()
+	flags.StringVarP(&options.InfluxURL, "influx-url", "i", "https://eu-central-1-1.aws.cloud2.influxdata.com", "InfluxDB API URL")
+	flags.StringVarP(&options.InfluxOrg, "influx-org", "o", "optakt", "InfluxDB organization name")
+	flags.StringVarP(&options.InfluxBucket, "influx-metrics-bucket", "m", "metrics", "InfluxDB bucket name")
+	flags.StringVarP(&options.InfluxToken, "influx-token", "t", "", "InfluxDB authentication token")
 
-		query := ethereum.FilterQuery{
-			FromBlock: big.NewInt(0).SetUint64(from),
-			ToBlock:   big.NewInt(0).SetUint64(to),
-			Addresses: []common.Address{common.HexToAddress(pairAddress)},
-			Topics:    [][]common.Hash{{SigSwap, SigSync}},
-		}
+	flags.StringSliceVar(&options.Names, "sinks", []string{"influx"}, "outputs the datapoints are written to (influx, csv, parquet, sqlite, archive, nats)")
+	flags.StringSliceVar(&options.BestEffort, "best-effort-sinks", nil, "outputs whose errors are logged instead of stopping the miner")
 
-		entries, err := client.FilterLogs(context.Background(), query)
-		if err != nil {
-			log.Fatal().Err(err).Msg("could not retrieve filtered log entries")
-		}
+	flags.StringVar(&options.FileDirectory, "file-directory", "data", "directory the csv and parquet files are written to, partitioned by chain and pair")
+	flags.Uint64Var(&options.FileRotateBlocks, "file-rotate-blocks", 100000, "block range after which a new file is started for each pair, zero to disable")
+	flags.Int64Var(&options.FileRotateSize, "file-rotate-size", 0, "size in bytes after which a new file is started for each pair, zero to disable")
 
-		log.Debug().Int("entries", len(entries)).Msg("processing log entries for block range")
-
-		timestamps := make(map[uint64]time.Time)
-		reserves0 := make(map[uint64]*big.Int)
-		reserves1 := make(map[uint64]*big.Int)
-		volumes0 := make(map[uint64]*big.Int)
-		volumes1 := make(map[uint64]*big.Int)
-
-		var swap Swap
-		var sick Sync
-		for _, entry := range entries {
-
-			height := entry.BlockNumber
-			timestamps[height] = time.Time{}
-
-			switch entry.Topics[0] {
-
-			case SigSync:
-
-				err := pairABI.UnpackIntoInterface(&sick, "Sync", entry.Data)
-				if err != nil {
-					log.Fatal().Err(err).Msg("could not unpack sync event")
-				}
-
-				reserve0, ok := reserves0[height]
-				if !ok {
-					reserve0 = big.NewInt(0)
-					reserves0[height] = reserve0
-				}
-				reserve0.Add(reserve0, sick.Reserve0)
-
-				reserve1, ok := reserves1[height]
-				if !ok {
-					reserve1 = big.NewInt(0)
-					reserves1[height] = reserve1
-				}
-				reserve1.Add(reserve1, sick.Reserve1)
-
-				log.Debug().
-					Str("reserve0", sick.Reserve0.String()).
-					Str("reserve1", sick.Reserve1.String()).
-					Msg("sync decoded")
-
-			case SigSwap:
-
-				err := pairABI.UnpackIntoInterface(&swap, "Swap", entry.Data)
-				if err != nil {
-					log.Fatal().Err(err).Msg("could not unpack swap event")
-				}
-
-				volume0, ok := volumes0[height]
-				if !ok {
-					volume0 = big.NewInt(0)
-					volumes0[height] = volume0
-				}
-				volume0.Add(volume0, swap.Amount0In)
-
-				volume1, ok := volumes1[height]
-				if !ok {
-					volume1 = big.NewInt(0)
-					volumes1[height] = volume1
-				}
-				volume1.Add(volume1, swap.Amount1In)
-
-				log.Debug().
-					Str("volume0", volume0.String()).
-					Str("volume1", volume1.String()).
-					Msg("swap decoded")
-			}
-		}
+	flags.StringVar(&options.SQLitePath, "sqlite-path", "klangbaach.db", "path of the SQLite database file of the sqlite sink")
+	flags.StringVar(&options.ArchivePath, "archive-path", "archive", "directory of the raw log entry archive of the archive sink")
+
+	flags.StringVar(&options.NATSURL, "nats-url", "nats://127.0.0.1:4222", "NATS server URL of the nats sink")
+	flags.StringVar(&options.NATSPrefix, "nats-subject-prefix", "klangbaach", "prefix of the subjects the events are published to")
+	flags.BoolVar(&options.NATSJetStream, "nats-jetstream", false, "whether to publish through JetStream and wait for the stream to store the messages")
+}
+
+// openSinks opens the sinks with the given settings and combines them. The
+// SQLite store is also returned on its own when it is one of them, so that
+// its checkpoint can be read.
+func openSinks(log zerolog.Logger, options SinkOptions) (*Fanout, *Store, error) {
 
-		heights := make([]uint64, 0, len(timestamps))
-		for height := range timestamps {
-			heights = append(heights, height)
+	optional := make(map[string]struct{})
+	for _, name := range options.BestEffort {
+		optional[name] = struct{}{}
+	}
+
+	fanout := NewFanout(log)
+	var store *Store
+	for _, name := range options.Names {
+
+		var sink Sink
+		var err error
+		switch name {
+		case "influx":
+			var influx influxdb2.Client
+			influx, err = connectInflux(options.InfluxURL, options.InfluxToken)
+			if err == nil {
+				sink = NewInfluxSink(influx, options.InfluxOrg, options.InfluxBucket)
+			}
+		case FormatCSV, FormatParquet:
+			sink, err = NewFileSink(options.FileDirectory, name, options.FileRotateBlocks, options.FileRotateSize)
+		case "sqlite":
+			store, err = OpenStore(options.SQLitePath)
+			sink = store
+		case "archive":
+			sink, err = OpenArchive(options.ArchivePath)
+		case "nats":
+			sink, err = NewPublisher(options.NATSURL, options.NATSPrefix, options.NATSJetStream)
+		default:
+			return nil, nil, fmt.Errorf("unknown sink (%s)", name)
 		}
-		sort.Slice(heights, func(i int, j int) bool {
-			return heights[i] < heights[j]
-		})
-
-		log.Debug().Int("heights", len(heights)).Msg("retrieving timestamps for heights")
-
-		wg := &sync.WaitGroup{}
-		for _, height := range heights {
-			wg.Add(1)
-			go func(height uint64) {
-				defer wg.Done()
-				header, err := client.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(height))
-				if err != nil {
-					log.Fatal().Uint64("height", height).Err(err).Msg("could not get header for height")
-				}
-				timestamps[height] = time.Unix(int64(header.Time), 0).UTC()
-			}(height)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open sink (%s): %w", name, err)
 		}
-		wg.Wait()
 
-		log.Debug().Int("heights", len(heights)).Msg("writing datapoints for heights")
+		_, bestEffort := optional[name]
+		fanout.Add(name, sink, bestEffort)
+	}
 
-		for _, height := range heights {
+	return fanout, store, nil
+}
 
-			timestamp := timestamps[height]
+// openSource returns the source the stored datapoints are read from, which is
+// the given SQLite store when it is one of the sinks, and InfluxDB otherwise.
+func openSource(options SinkOptions, store *Store) (Source, error) {
 
-			reserve0, ok := reserves0[height]
-			if !ok {
-				reserve0 = big.NewInt(0)
-			}
-			reserve1, ok := reserves1[height]
-			if !ok {
-				reserve1 = big.NewInt(0)
-			}
+	if store != nil {
+		return store, nil
+	}
 
-			volume0, ok := volumes0[height]
-			if !ok {
-				volume0 = big.NewInt(0)
-			}
-			volume1, ok := volumes1[height]
-			if !ok {
-				volume1 = big.NewInt(0)
-			}
+	influx, err := connectInflux(options.InfluxURL, options.InfluxToken)
+	if err != nil {
+		return nil, err
+	}
 
-			if writeMetrics {
-				tags := map[string]string{
-					"chain": chainName,
-					"pair":  pairName,
-				}
-				fields := map[string]interface{}{
-					"reserve0": hex.EncodeToString(reserve0.Bytes()),
-					"reserve1": hex.EncodeToString(reserve1.Bytes()),
-					"volume0":  hex.EncodeToString(volume0.Bytes()),
-					"volume1":  hex.EncodeToString(volume1.Bytes()),
-				}
-
-				point := write.NewPoint(measurement, tags, fields, timestamp)
-				batch.WritePoint(point)
-			}
+	return NewInfluxSource(influx.QueryAPI(options.InfluxOrg), options.InfluxBucket), nil
+}
 
-			log.Debug().
-				Time("timestamp", timestamp).
-				Str("reserve0", reserve0.String()).
-				Str("reserve1", reserve1.String()).
-				Str("volume0", volume0.String()).
-				Str("volume1", volume1.String()).
-				Msg("datapoint queued for writing")
+// bindConfigFlags binds the flags of the miner settings that determine the
+// derived datapoints to the given flag set.
+func bindConfigFlags(flags *pflag.FlagSet, config *Config) {
 
-		}
+	flags.StringSliceVarP(&config.CandleIntervals, "candle-intervals", "c", []string{"1m", "5m", "1h", "1d"}, "intervals for the OHLCV candles aggregated from the datapoints")
 
-		log.Info().Int("entries", len(entries)).Int("heights", len(heights)).Msg("processed log entries for block range")
-	}
+	flags.StringSliceVar(&config.Stablecoins, "stablecoins", nil, "token addresses valued at one USD for the USD valuation of datapoints")
+	flags.StringSliceVar(&config.ReferencePairs, "reference-pairs", nil, "additional Uniswap v2 pair addresses used to route token prices to the stablecoins")
 
-	batch.Flush()
+	flags.UintVar(&config.FeeBps, "fee-bps", 30, "swap fee charged on input amounts by the pairs, in basis points")
+	flags.DurationVar(&config.APRWindow, "apr-window", 24*time.Hour, "rolling window of fees used for the liquidity provider APR")
 
-	log.Info().Msg("stopping klangbaach data miner")
+	flags.BoolVar(&config.DetectMEV, "detect-mev", false, "whether to detect sandwiches and arbitrages in the swaps of each block")
+	flags.BoolVar(&config.DetectArbitrage, "detect-arbitrage", false, "whether to detect arbitrage opportunities across the tracked pairs")
+	flags.IntVar(&config.MaxHops, "max-hops", 3, "maximum number of swaps in the arbitrage cycles")
 
-	os.Exit(0)
+	flags.BoolVar(&config.DetectWashTrading, "detect-wash-trading", false, "whether to score the swaps of each pair for wash trading and anomalous volume")
+	flags.StringVar(&config.AnomalyInterval, "anomaly-interval", "1h", "interval over which the anomaly score of each pair is computed")
+	flags.DurationVar(&config.CircularWindow, "circular-window", 10*time.Minute, "window within which a trader reversing a swap counts as a circular trade")
+	flags.Float64Var(&config.DominanceThreshold, "dominance-threshold", 0.5, "share of the volume of an interval from a single trader above which it is anomalous")
+	flags.Float64Var(&config.TurnoverThreshold, "turnover-threshold", 1, "ratio of the volume of an interval to the reserves above which it is anomalous")
+
+	flags.BoolVar(&config.CheckTransfers, "check-transfers", false, "whether to reconcile token transfers with reserves to detect fee-on-transfer and rebasing tokens")
+
+	flags.StringVar(&config.Dense, "dense", "", "emit datapoints without events, for every block (block) or at every multiple of an interval of block time (e.g. 15s)")
+
+	flags.Float64SliceVar(&config.DepthLevels, "depth-levels", []float64{0.01, 0.02, 0.05}, "price changes for which to compute the liquidity depth of the pairs")
 }
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	pendingMeasurement = "Uniswap v2 Pending"
+
+	// pendingWorkers is the number of concurrent requests for the pending
+	// transactions announced by the node.
+	pendingWorkers = 16
+)
+
+// Move is the predicted effect of a pending swap on the reserves of a pool.
+type Move struct {
+	TxHash     common.Hash
+	Seen       time.Time
+	Pool       *Pool
+	ZeroForOne bool
+	AmountIn   *big.Int
+	AmountOut  *big.Int
+	Before0    *big.Int
+	Before1    *big.Int
+	After0     *big.Int
+	After1     *big.Int
+}
+
+// PriceMove returns the relative change of the spot price of the pool.
+func (m Move) PriceMove() float64 {
+	return priceMove(m.Pool, m.Before0, m.Before1, m.After0, m.After1)
+}
+
+// Point converts the move into an InfluxDB datapoint for the pending
+// measurement, keyed by the time the transaction was seen.
+func (m Move) Point(chainName string) *write.Point {
+
+	direction := 1
+	if m.ZeroForOne {
+		direction = 0
+	}
+
+	tags := map[string]string{
+		"chain": chainName,
+		"pair":  m.Pool.Name,
+	}
+	fields := map[string]interface{}{
+		"tx_hash":      m.TxHash.Hex(),
+		"direction":    direction,
+		"amount_in":    hex.EncodeToString(m.AmountIn.Bytes()),
+		"amount_out":   hex.EncodeToString(m.AmountOut.Bytes()),
+		"price_before": spotPrice(m.Before0, m.Before1, m.Pool.Token0.Decimals, m.Pool.Token1.Decimals),
+		"price_after":  spotPrice(m.After0, m.After1, m.Pool.Token0.Decimals, m.Pool.Token1.Decimals),
+		"price_move":   m.PriceMove(),
+		"mined":        false,
+	}
+
+	return write.NewPoint(pendingMeasurement, tags, fields, m.Seen)
+}
+
+// priceMove returns the relative change of the spot price of a pool between
+// two sets of reserves.
+func priceMove(pool *Pool, before0 *big.Int, before1 *big.Int, after0 *big.Int, after1 *big.Int) float64 {
+	before := spotPrice(before0, before1, pool.Token0.Decimals, pool.Token1.Decimals)
+	after := spotPrice(after0, after1, pool.Token0.Decimals, pool.Token1.Decimals)
+	if before == 0 {
+		return 0
+	}
+	return after/before - 1
+}
+
+// pairKey identifies a pool by its tokens, regardless of their order.
+func pairKey(a common.Address, b common.Address) [2]common.Address {
+	if bytes.Compare(a.Bytes(), b.Bytes()) > 0 {
+		a, b = b, a
+	}
+	return [2]common.Address{a, b}
+}
+
+// simulateRoute predicts the moves of a swap through the router on the current
+// reserves of the pools along its path. Exact input swaps are simulated
+// forward, and exact output swaps backward, the same way the router computes
+// the amounts. All pools of the path have to be tracked.
+func simulateRoute(fees Fees, pools map[[2]common.Address]*Pool, call RouterCall) ([]Move, bool) {
+
+	moves := make([]Move, 0, len(call.Path)-1)
+	for i := 0; i < len(call.Path)-1; i++ {
+		pool, ok := pools[pairKey(call.Path[i], call.Path[i+1])]
+		if !ok || pool.Reserve0 == nil || pool.Reserve1 == nil {
+			return nil, false
+		}
+		moves = append(moves, Move{
+			Pool:       pool,
+			ZeroForOne: pool.Token0.Address == call.Path[i],
+		})
+	}
+
+	if call.AmountIn != nil {
+		amount := call.AmountIn
+		for i := range moves {
+			reserveIn, reserveOut := moveReserves(moves[i])
+			moves[i].AmountIn = amount
+			moves[i].AmountOut = fees.AmountOut(amount, reserveIn, reserveOut)
+			amount = moves[i].AmountOut
+		}
+	} else {
+		amount := call.AmountOut
+		for i := len(moves) - 1; i >= 0; i-- {
+			reserveIn, reserveOut := moveReserves(moves[i])
+			moves[i].AmountOut = amount
+			moves[i].AmountIn = fees.AmountIn(amount, reserveIn, reserveOut)
+			if moves[i].AmountIn == nil {
+				return nil, false
+			}
+			amount = moves[i].AmountIn
+		}
+	}
+
+	for i := range moves {
+		applyMove(&moves[i])
+	}
+
+	return moves, true
+}
+
+// moveReserves returns the reserves of the pool of a move in its direction.
+func moveReserves(m Move) (*big.Int, *big.Int) {
+	if m.ZeroForOne {
+		return m.Pool.Reserve0, m.Pool.Reserve1
+	}
+	return m.Pool.Reserve1, m.Pool.Reserve0
+}
+
+// applyMove sets the reserves of a move before and after the swap, from the
+// current reserves of its pool and its amounts.
+func applyMove(m *Move) {
+	m.Before0, m.Before1 = m.Pool.Reserve0, m.Pool.Reserve1
+	if m.ZeroForOne {
+		m.After0 = new(big.Int).Add(m.Before0, m.AmountIn)
+		m.After1 = new(big.Int).Sub(m.Before1, m.AmountOut)
+	} else {
+		m.After0 = new(big.Int).Sub(m.Before0, m.AmountOut)
+		m.After1 = new(big.Int).Add(m.Before1, m.AmountIn)
+	}
+}
+
+// monitorMempool subscribes to the pending transactions of a node, decodes the
+// swaps they make through the router or directly on the indexed pairs, and
+// predicts their price moves on the latest reserves of the pairs. Once a
+// transaction is mined, its actual price move is recorded alongside the
+// prediction.
+func monitorMempool(args []string) {
+
+	var (
+		logLevel     string
+		writeMetrics bool
+
+		pairAddresses   []string
+		referencePairs  []string
+		routerAddresses []string
+		feeBps          uint
+		pendingTTL      time.Duration
+
+		apiURL string
+
+		influxURL    string
+		influxToken  string
+		influxOrg    string
+		influxBucket string
+	)
+
+	flags := pflag.NewFlagSet("mempool", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+	flags.BoolVarP(&writeMetrics, "write-metrics", "w", false, "whether to write the predicted moves to InfluxDB")
+
+	flags.StringVarP(&apiURL, "api-url", "a", "", "WebSocket JSON RPC API URL of a node exposing its pending transactions")
+	flags.StringSliceVarP(&pairAddresses, "pair-addresses", "p", []string{"0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc"}, "Ethereum addresses for Uniswap v2 pairs")
+	flags.StringSliceVar(&referencePairs, "reference-pairs", nil, "additional Uniswap v2 pair addresses used to simulate swaps routed through them")
+	flags.StringSliceVar(&routerAddresses, "router-addresses", []string{"0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D"}, "Ethereum addresses for Uniswap v2 routers")
+	flags.UintVar(&feeBps, "fee-bps", 30, "swap fee charged on input amounts by the pairs, in basis points")
+	flags.DurationVar(&pendingTTL, "pending-ttl", 10*time.Minute, "duration after which pending swaps that were not mined are dropped")
+
+	flags.StringVarP(&influxURL, "influx-url", "i", "https://eu-central-1-1.aws.cloud2.influxdata.com", "InfluxDB API URL")
+	flags.StringVarP(&influxOrg, "influx-org", "o", "optakt", "InfluxDB organization name")
+	flags.StringVarP(&influxBucket, "influx-metrics-bucket", "m", "metrics", "InfluxDB bucket name")
+	flags.StringVarP(&influxToken, "influx-token", "t", "", "InfluxDB authentication token")
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	pairABI, err := abi.JSON(strings.NewReader(PairMetaData.ABI))
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid Uniswap Pair ABI")
+	}
+	router, err := abi.JSON(strings.NewReader(routerABI))
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid Uniswap Router ABI")
+	}
+
+	rpcClient, err := rpc.Dial(apiURL)
+	if err != nil {
+		log.Fatal().Str("api_url", apiURL).Err(err).Msg("could not connect to JSON RPC API")
+	}
+	client := ethclient.NewClient(rpcClient)
+	node := gethclient.New(rpcClient)
+
+	chainName := lookupChain(log, client)
+
+	head, err := client.BlockNumber(context.Background())
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not get last block height")
+	}
+
+	log = log.With().
+		Str("bucket", influxBucket).
+		Str("measurement", pendingMeasurement).
+		Str("chain_name", chainName).
+		Logger()
+
+	// The indexed pools get their moves published, while the reference pools
+	// are only tracked to simulate routes through them.
+	indexed := make(map[common.Address]*Pool)
+	tracked := make(map[common.Address]*Pool)
+	byTokens := make(map[[2]common.Address]*Pool)
+	for i, pairAddress := range append(append([]string{}, pairAddresses...), referencePairs...) {
+		address := common.HexToAddress(pairAddress)
+		if _, ok := tracked[address]; ok {
+			continue
+		}
+		pool, err := fetchPool(client, address)
+		if err != nil {
+			log.Fatal().Str("pair_address", pairAddress).Err(err).Msg("could not get pair metadata")
+		}
+		err = pool.fetchState(client, head)
+		if err != nil {
+			log.Fatal().Str("pair_name", pool.Name).Err(err).Msg("could not get pair state")
+		}
+		tracked[address] = pool
+		byTokens[pairKey(pool.Token0.Address, pool.Token1.Address)] = pool
+		if i < len(pairAddresses) {
+			indexed[address] = pool
+		}
+	}
+
+	routers := make(map[common.Address]struct{})
+	for _, routerAddress := range routerAddresses {
+		routers[common.HexToAddress(routerAddress)] = struct{}{}
+	}
+
+	var sink Sink
+	if writeMetrics {
+		influx, err := connectInflux(influxURL, influxToken)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not connect to InfluxDB API")
+		}
+		sink = NewInfluxSink(influx, influxOrg, influxBucket)
+	}
+
+	// The latest reserves are kept up to date with the sync events, and the
+	// swap events are matched with the pending swaps once they are mined.
+	addresses := make([]common.Address, 0, len(tracked))
+	for address := range tracked {
+		addresses = append(addresses, address)
+	}
+	query := ethereum.FilterQuery{
+		Addresses: addresses,
+		Topics:    [][]common.Hash{{SigSync, SigSwap}},
+	}
+	entries := make(chan types.Log, 1024)
+	hashes := make(chan common.Hash, 1024)
+
+	// Failed subscriptions are retried after a delay, while the other one
+	// keeps being processed; a nil channel disables its case in the loop.
+	var logSub, pendingSub ethereum.Subscription
+	var logErrs, pendingErrs <-chan error
+	var logRetry, pendingRetry <-chan time.Time
+	subscribeLogs := func() bool {
+		logSub, err = client.SubscribeFilterLogs(context.Background(), query, entries)
+		if err != nil {
+			log.Warn().Err(err).Msg("could not subscribe to log entries")
+			logRetry = time.After(resubscribeDelay)
+			return false
+		}
+		logErrs, logRetry = logSub.Err(), nil
+		return true
+	}
+	subscribePending := func() {
+		pendingSub, err = node.SubscribePendingTransactions(context.Background(), hashes)
+		if err != nil {
+			log.Warn().Err(err).Msg("could not subscribe to pending transactions")
+			pendingRetry = time.After(resubscribeDelay)
+			return
+		}
+		pendingErrs, pendingRetry = pendingSub.Err(), nil
+	}
+	subscribeLogs()
+	subscribePending()
+
+	// Pending transactions are announced by hash only, so they are retrieved
+	// concurrently, and only the swaps are handed over to the main loop.
+	transactions := make(chan *types.Transaction, 1024)
+	for i := 0; i < pendingWorkers; i++ {
+		go func() {
+			for hash := range hashes {
+				tx, isPending, err := client.TransactionByHash(context.Background(), hash)
+				if err != nil || !isPending || tx.To() == nil {
+					continue
+				}
+				_, isRouter := routers[*tx.To()]
+				_, isPair := indexed[*tx.To()]
+				if isRouter || isPair {
+					transactions <- tx
+				}
+			}
+		}()
+	}
+
+	log.Info().Int("pairs", len(indexed)).Int("routers", len(routers)).Msg("monitoring pending swaps")
+
+	fees := NewFees(feeBps)
+	pending := make(map[common.Hash][]Move)
+	priors := make(map[common.Address][2]*big.Int)
+	expiry := time.NewTicker(time.Minute)
+	var sick Sync
+	var swap Swap
+	for {
+		select {
+
+		case err := <-logErrs:
+			logSub.Unsubscribe()
+			log.Warn().Err(err).Msg("log subscription dropped")
+			logErrs = nil
+			logRetry = time.After(resubscribeDelay)
+
+		case <-logRetry:
+			if !subscribeLogs() {
+				continue
+			}
+			// The sync events missed in the meantime are not delivered, so the
+			// reserves are read again from the chain head.
+			head, err := client.BlockNumber(context.Background())
+			if err != nil {
+				log.Warn().Err(err).Msg("could not get last block height")
+				continue
+			}
+			for _, pool := range tracked {
+				err = pool.fetchState(client, head)
+				if err != nil {
+					log.Warn().Str("pair_name", pool.Name).Err(err).Msg("could not get pair state")
+				}
+			}
+			log.Info().Uint64("height", head).Msg("log subscription restored")
+
+		case err := <-pendingErrs:
+			pendingSub.Unsubscribe()
+			log.Warn().Err(err).Msg("pending transaction subscription dropped")
+			pendingErrs = nil
+			pendingRetry = time.After(resubscribeDelay)
+
+		case <-pendingRetry:
+			subscribePending()
+
+		case tx := <-transactions:
+
+			var moves []Move
+			pool, isPair := indexed[*tx.To()]
+			if isPair {
+				zeroForOne, amountOut, ok := decodePairCall(pairABI, tx)
+				if !ok || pool.Reserve0 == nil || pool.Reserve1 == nil {
+					continue
+				}
+				move := Move{
+					Pool:       pool,
+					ZeroForOne: zeroForOne,
+					AmountOut:  amountOut,
+				}
+				reserveIn, reserveOut := moveReserves(move)
+				move.AmountIn = fees.AmountIn(amountOut, reserveIn, reserveOut)
+				if move.AmountIn == nil {
+					continue
+				}
+				applyMove(&move)
+				moves = append(moves, move)
+			} else {
+				call, ok := decodeRouterCall(router, tx)
+				if !ok {
+					continue
+				}
+				moves, ok = simulateRoute(fees, byTokens, call)
+				if !ok {
+					log.Debug().Str("tx_hash", tx.Hash().Hex()).Msg("could not simulate route of pending swap")
+					continue
+				}
+			}
+
+			seen := time.Now().UTC()
+			var published []Move
+			for _, move := range moves {
+				if _, ok := indexed[move.Pool.Address]; !ok {
+					continue
+				}
+				move.TxHash = tx.Hash()
+				move.Seen = seen
+				published = append(published, move)
+
+				log.Info().
+					Str("tx_hash", move.TxHash.Hex()).
+					Str("pair_name", move.Pool.Name).
+					Bool("zero_for_one", move.ZeroForOne).
+					Str("amount_in", move.AmountIn.String()).
+					Str("amount_out", move.AmountOut.String()).
+					Float64("price_move", move.PriceMove()).
+					Msg("pending swap predicted")
+
+				if sink != nil {
+					err := sink.Write([]*write.Point{move.Point(chainName)})
+					if err != nil {
+						log.Error().Err(err).Msg("could not write predicted move")
+					}
+				}
+			}
+			if len(published) > 0 {
+				pending[tx.Hash()] = published
+			}
+
+		case entry := <-entries:
+
+			pool := tracked[entry.Address]
+			if entry.Removed {
+				continue
+			}
+
+			switch entry.Topics[0] {
+
+			case SigSync:
+				err := pairABI.UnpackIntoInterface(&sick, "Sync", entry.Data)
+				if err != nil {
+					log.Fatal().Err(err).Msg("could not unpack sync event")
+				}
+				priors[pool.Address] = [2]*big.Int{pool.Reserve0, pool.Reserve1}
+				pool.Reserve0 = new(big.Int).Set(sick.Reserve0)
+				pool.Reserve1 = new(big.Int).Set(sick.Reserve1)
+
+			case SigSwap:
+				moves, ok := pending[entry.TxHash]
+				if !ok {
+					continue
+				}
+				err := pairABI.UnpackIntoInterface(&swap, "Swap", entry.Data)
+				if err != nil {
+					log.Fatal().Err(err).Msg("could not unpack swap event")
+				}
+
+				// The sync event of the swap precedes it, so the reserves of the
+				// pool are those after the swap, and the priors those before.
+				prior := priors[pool.Address]
+				if prior[0] == nil || prior[1] == nil {
+					continue
+				}
+				actual := priceMove(pool, prior[0], prior[1], pool.Reserve0, pool.Reserve1)
+				for i, move := range moves {
+					if move.Pool != pool {
+						continue
+					}
+
+					log.Info().
+						Str("tx_hash", move.TxHash.Hex()).
+						Str("pair_name", pool.Name).
+						Uint64("height", entry.BlockNumber).
+						Float64("predicted_move", move.PriceMove()).
+						Float64("actual_move", actual).
+						Dur("delay", time.Since(move.Seen)).
+						Msg("pending swap mined")
+
+					if sink != nil {
+						tags := map[string]string{
+							"chain": chainName,
+							"pair":  pool.Name,
+						}
+						fields := map[string]interface{}{
+							"mined":             true,
+							"height":            entry.BlockNumber,
+							"actual_amount0_in": hex.EncodeToString(swap.Amount0In.Bytes()),
+							"actual_amount1_in": hex.EncodeToString(swap.Amount1In.Bytes()),
+							"actual_price_move": actual,
+							"delay":             time.Since(move.Seen).Seconds(),
+						}
+						err := sink.Write([]*write.Point{write.NewPoint(pendingMeasurement, tags, fields, move.Seen)})
+						if err != nil {
+							log.Error().Err(err).Msg("could not write mined move")
+						}
+					}
+
+					moves = append(moves[:i], moves[i+1:]...)
+					break
+				}
+				if len(moves) == 0 {
+					delete(pending, entry.TxHash)
+				} else {
+					pending[entry.TxHash] = moves
+				}
+			}
+
+		case <-expiry.C:
+			cutoff := time.Now().Add(-pendingTTL)
+			for hash, moves := range pending {
+				if moves[0].Seen.Before(cutoff) {
+					log.Debug().Str("tx_hash", hash.Hex()).Msg("pending swap dropped")
+					delete(pending, hash)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// pairsCommand runs the subcommands about the pairs.
+func pairsCommand(args []string) {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, "Usage: klangbaach pairs list [flags]")
+		os.Exit(2)
+	}
+	listPairs(args[1:])
+}
+
+// tokenCommand runs the subcommands about the tokens.
+func tokenCommand(args []string) {
+	if len(args) == 0 || args[0] != "info" {
+		fmt.Fprintln(os.Stderr, "Usage: klangbaach token info [flags]")
+		os.Exit(2)
+	}
+	showTokens(args[1:])
+}
+
+// listPairs lists the pairs stored in the SQLite database with their tokens,
+// for one chain or for all of them.
+func listPairs(args []string) {
+
+	var (
+		logLevel string
+
+		chainName  string
+		sqlitePath string
+	)
+
+	flags := pflag.NewFlagSet("pairs list", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+
+	flags.StringVar(&chainName, "chain", "", "name of the chain whose pairs to list (defaults to all chains)")
+	flags.StringVar(&sqlitePath, "sqlite-path", "klangbaach.db", "path of the SQLite database file")
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	store, err := OpenStore(sqlitePath)
+	if err != nil {
+		log.Fatal().Str("sqlite_path", sqlitePath).Err(err).Msg("could not open SQLite database")
+	}
+
+	chains := []string{chainName}
+	if chainName == "" {
+		chains, err = store.Chains()
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not read stored chains")
+		}
+	}
+
+	for _, chain := range chains {
+
+		pools, err := store.Pairs(chain)
+		if err != nil {
+			log.Fatal().Str("chain_name", chain).Err(err).Msg("could not read stored pairs")
+		}
+
+		for _, pool := range pools {
+			log.Info().
+				Str("chain_name", chain).
+				Str("pair_address", pool.Address.Hex()).
+				Str("pair_name", pool.Name).
+				Str("token0_address", pool.Token0.Address.Hex()).
+				Str("token0_symbol", pool.Token0.Symbol).
+				Uint8("token0_decimals", pool.Token0.Decimals).
+				Str("token1_address", pool.Token1.Address.Hex()).
+				Str("token1_symbol", pool.Token1.Symbol).
+				Uint8("token1_decimals", pool.Token1.Decimals).
+				Msg("stored pair")
+		}
+	}
+
+	err = store.Close()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not close SQLite database")
+	}
+
+	os.Exit(0)
+}
+
+// showTokens shows the metadata and total supply of tokens as returned by
+// their contracts.
+func showTokens(args []string) {
+
+	var (
+		logLevel string
+
+		tokenAddresses []string
+
+		apiURL string
+	)
+
+	flags := pflag.NewFlagSet("token info", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+
+	flags.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL")
+	flags.StringSliceVar(&tokenAddresses, "token-addresses", nil, "Ethereum addresses for ERC20 tokens")
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	if len(tokenAddresses) == 0 {
+		log.Fatal().Msg("need at least one token address")
+	}
+
+	client, chainName := connectChain(log, apiURL)
+
+	for _, tokenAddress := range tokenAddresses {
+
+		address := common.HexToAddress(tokenAddress)
+		token, err := fetchToken(client, address)
+		if err != nil {
+			log.Fatal().Str("token_address", tokenAddress).Err(err).Msg("could not get token metadata")
+		}
+
+		contract, err := NewERC20Caller(address, client)
+		if err != nil {
+			log.Fatal().Str("token_address", tokenAddress).Err(err).Msg("could not bind token contract")
+		}
+		name, err := contract.Name(nil)
+		if err != nil {
+			log.Fatal().Str("token_address", tokenAddress).Err(err).Msg("could not get token name")
+		}
+		supply, err := contract.TotalSupply(nil)
+		if err != nil {
+			log.Fatal().Str("token_address", tokenAddress).Err(err).Msg("could not get token total supply")
+		}
+
+		log.Info().
+			Str("chain_name", chainName).
+			Str("token_address", token.Address.Hex()).
+			Str("token_name", name).
+			Str("token_symbol", token.Symbol).
+			Uint8("token_decimals", token.Decimals).
+			Str("total_supply", formatAmount(supply, token.Decimals)).
+			Msg("token metadata")
+	}
+
+	os.Exit(0)
+}
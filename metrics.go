@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+)
+
+const (
+	metricsNamespace = "klangbaach"
+)
+
+var (
+	blocksProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "blocks_processed_total",
+		Help:      "Number of block heights processed.",
+	})
+	logEntries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "log_entries_total",
+		Help:      "Number of log entries processed.",
+	})
+	currentHeight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "height",
+		Help:      "Last block height processed.",
+	})
+	headHeight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "head_height",
+		Help:      "Last known block height of the chain head.",
+	})
+	headLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "head_lag_blocks",
+		Help:      "Number of blocks between the chain head and the last block height processed.",
+	})
+	rpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Duration of the calls to the JSON RPC API.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"method"})
+	rpcErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rpc_errors_total",
+		Help:      "Number of failed calls to the JSON RPC API.",
+	}, []string{"method"})
+	sinkDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "sink_duration_seconds",
+		Help:      "Duration of the operations on the sinks.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"sink", "operation"})
+	sinkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sink_errors_total",
+		Help:      "Number of failed operations on the sinks.",
+	}, []string{"sink", "operation"})
+	sinkQueue = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "sink_queue_datapoints",
+		Help:      "Number of datapoints queued for the sinks by the last processed range.",
+	})
+	pairUpdate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pair_last_update_timestamp_seconds",
+		Help:      "Block timestamp of the last datapoint of each pair.",
+	}, []string{"chain", "pair"})
+)
+
+// heights holds the processed and head heights, from which the lag is derived.
+var heights struct {
+	sync.Mutex
+	current uint64
+	head    uint64
+}
+
+// observeRPC records the duration of a call to the JSON RPC API that started at
+// the given time, and whether it failed.
+func observeRPC(method string, start time.Time, err error) {
+	rpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		rpcErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// observeHeight records the last processed height.
+func observeHeight(height uint64) {
+	heights.Lock()
+	defer heights.Unlock()
+	heights.current = height
+	currentHeight.Set(float64(height))
+	updateLag()
+}
+
+// observeHead records the height of the chain head, unless a higher one is
+// already known.
+func observeHead(head uint64) {
+	heights.Lock()
+	defer heights.Unlock()
+	if head <= heights.head {
+		return
+	}
+	heights.head = head
+	headHeight.Set(float64(head))
+	updateLag()
+}
+
+func updateLag() {
+	lag := 0.0
+	if heights.head > heights.current {
+		lag = float64(heights.head - heights.current)
+	}
+	headLag.Set(lag)
+}
+
+// serveMetrics exposes the metrics for Prometheus on the given address, under
+// the /metrics path.
+func serveMetrics(log zerolog.Logger, address string) {
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			log.Error().Str("metrics_address", address).Err(err).Msg("could not serve metrics")
+		}
+	}()
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	mevMeasurement = "Uniswap v2 MEV"
+
+	KindSandwich  = "sandwich"
+	KindArbitrage = "arbitrage"
+)
+
+// SwapRecord is a decoded swap along with its position in the block and the
+// reserves of the pool right before it was executed.
+type SwapRecord struct {
+	Pool     *Pool
+	TxHash   common.Hash
+	TxIndex  uint
+	LogIndex uint
+	Sender   common.Address
+	To       common.Address
+	Swap     Swap
+	Before0  *big.Int
+	Before1  *big.Int
+}
+
+func NewSwapRecord(pool *Pool, entry types.Log, swap Swap, before0 *big.Int, before1 *big.Int) SwapRecord {
+
+	s := SwapRecord{
+		Pool:     pool,
+		TxHash:   entry.TxHash,
+		TxIndex:  entry.TxIndex,
+		LogIndex: entry.Index,
+		Swap: Swap{
+			Amount0In:  new(big.Int).Set(swap.Amount0In),
+			Amount1In:  new(big.Int).Set(swap.Amount1In),
+			Amount0Out: new(big.Int).Set(swap.Amount0Out),
+			Amount1Out: new(big.Int).Set(swap.Amount1Out),
+		},
+		Before0: before0,
+		Before1: before1,
+	}
+	if len(entry.Topics) > 2 {
+		s.Sender = common.BytesToAddress(entry.Topics[1].Bytes())
+		s.To = common.BytesToAddress(entry.Topics[2].Bytes())
+	}
+
+	return s
+}
+
+// Direction returns 0 for swaps of token0 into token1, 1 for swaps of token1
+// into token0, and -1 for swaps that do not go clearly in one direction.
+func (s SwapRecord) Direction() int {
+	switch {
+	case s.Swap.Amount0In.Sign() > 0 && s.Swap.Amount1Out.Sign() > 0 && s.Swap.Amount1In.Sign() == 0:
+		return 0
+	case s.Swap.Amount1In.Sign() > 0 && s.Swap.Amount0Out.Sign() > 0 && s.Swap.Amount0In.Sign() == 0:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// Flows returns the input and output amounts of the swap in its direction.
+func (s SwapRecord) Flows() (*big.Int, *big.Int) {
+	if s.Direction() == 0 {
+		return s.Swap.Amount0In, s.Swap.Amount1Out
+	}
+	return s.Swap.Amount1In, s.Swap.Amount0Out
+}
+
+// Tokens returns the input and output tokens of the swap in its direction.
+func (s SwapRecord) Tokens() (Token, Token) {
+	if s.Direction() == 0 {
+		return s.Pool.Token0, s.Pool.Token1
+	}
+	return s.Pool.Token1, s.Pool.Token0
+}
+
+// MEVEvent is a labelled occurrence of extracted value within a block.
+type MEVEvent struct {
+	Kind        string
+	Height      uint64
+	LogIndex    uint
+	Pools       []*Pool
+	Attacker    common.Address
+	FrontRun    common.Hash
+	Victims     []common.Hash
+	BackRun     common.Hash
+	ProfitToken Token
+	Profit      *big.Int
+	LossToken   Token
+	VictimLoss  *big.Int
+}
+
+// detectSandwiches finds the swaps of a block that were sandwiched between a
+// front-run swap in the same direction and a back-run swap in the opposite
+// direction by the same actor. The attacker profit is the amount of the input
+// token it got back beyond what it paid, scaled to the amount it sold again;
+// the victim loss is the output the victims would have received without the
+// front-run, minus what they received. Each victim traded after the previous
+// ones, so its expected output is computed at the reserves before the
+// front-run, advanced by the expected swaps of the victims before it.
+func detectSandwiches(swaps []SwapRecord, fees Fees) []MEVEvent {
+
+	byPool := make(map[common.Address][]SwapRecord)
+	for _, swap := range swaps {
+		byPool[swap.Pool.Address] = append(byPool[swap.Pool.Address], swap)
+	}
+
+	var events []MEVEvent
+	for _, records := range byPool {
+
+		sort.Slice(records, func(i int, j int) bool {
+			return records[i].LogIndex < records[j].LogIndex
+		})
+
+		used := make(map[int]struct{})
+		for i, front := range records {
+
+			_, ok := used[i]
+			if ok || front.Direction() < 0 || front.Before0 == nil || front.Before1 == nil {
+				continue
+			}
+
+			for k := i + 1; k < len(records); k++ {
+
+				back := records[k]
+				if back.TxHash == front.TxHash || back.Direction() != 1-front.Direction() {
+					continue
+				}
+				if !sameActor(front, back) {
+					continue
+				}
+
+				var victims []SwapRecord
+				for j := i + 1; j < k; j++ {
+					victim := records[j]
+					if victim.TxHash == front.TxHash || victim.TxHash == back.TxHash {
+						continue
+					}
+					if victim.Direction() != front.Direction() || victim.To == front.To {
+						continue
+					}
+					victims = append(victims, victim)
+				}
+				if len(victims) == 0 {
+					continue
+				}
+
+				frontIn, frontOut := front.Flows()
+				backIn, backOut := back.Flows()
+				if frontOut.Sign() == 0 {
+					continue
+				}
+
+				cost := new(big.Int).Mul(frontIn, backIn)
+				cost.Quo(cost, frontOut)
+				profit := new(big.Int).Sub(backOut, cost)
+				if profit.Sign() <= 0 {
+					continue
+				}
+
+				reserveIn, reserveOut := new(big.Int).Set(front.Before0), new(big.Int).Set(front.Before1)
+				if front.Direction() == 1 {
+					reserveIn, reserveOut = reserveOut, reserveIn
+				}
+				loss := big.NewInt(0)
+				hashes := make([]common.Hash, 0, len(victims))
+				for _, victim := range victims {
+					victimIn, victimOut := victim.Flows()
+					expected := fees.AmountOut(victimIn, reserveIn, reserveOut)
+					if expected.Cmp(victimOut) > 0 {
+						loss.Add(loss, new(big.Int).Sub(expected, victimOut))
+					}
+					reserveIn.Add(reserveIn, victimIn)
+					reserveOut.Sub(reserveOut, expected)
+					hashes = append(hashes, victim.TxHash)
+				}
+
+				profitToken, lossToken := front.Tokens()
+				event := MEVEvent{
+					Kind:        KindSandwich,
+					LogIndex:    front.LogIndex,
+					Pools:       []*Pool{front.Pool},
+					Attacker:    front.To,
+					FrontRun:    front.TxHash,
+					Victims:     hashes,
+					BackRun:     back.TxHash,
+					ProfitToken: profitToken,
+					Profit:      profit,
+					LossToken:   lossToken,
+					VictimLoss:  loss,
+				}
+				events = append(events, event)
+
+				used[k] = struct{}{}
+				break
+			}
+		}
+	}
+
+	return events
+}
+
+// sameActor returns whether two swaps were most likely executed by the same
+// actor, which is the case when the recipient of the first swap is the one
+// executing the second, or when both use the same executor and recipient.
+func sameActor(first SwapRecord, second SwapRecord) bool {
+	return first.To == second.Sender || (first.Sender == second.Sender && first.To == second.To)
+}
+
+// detectArbitrages finds the transactions of a block that swap through at
+// least two of the tracked pools and end up with more of one token than they
+// started with, while not owing any of the other tokens.
+func detectArbitrages(swaps []SwapRecord) []MEVEvent {
+
+	byTx := make(map[common.Hash][]SwapRecord)
+	for _, swap := range swaps {
+		byTx[swap.TxHash] = append(byTx[swap.TxHash], swap)
+	}
+
+	var events []MEVEvent
+	for hash, records := range byTx {
+
+		pools := make(map[common.Address]*Pool)
+		for _, record := range records {
+			pools[record.Pool.Address] = record.Pool
+		}
+		if len(pools) < 2 {
+			continue
+		}
+
+		tokens := make(map[common.Address]Token)
+		nets := make(map[common.Address]*big.Int)
+		for _, record := range records {
+			flows := []struct {
+				token  Token
+				amount *big.Int
+			}{
+				{record.Pool.Token0, new(big.Int).Sub(record.Swap.Amount0Out, record.Swap.Amount0In)},
+				{record.Pool.Token1, new(big.Int).Sub(record.Swap.Amount1Out, record.Swap.Amount1In)},
+			}
+			for _, flow := range flows {
+				net, ok := nets[flow.token.Address]
+				if !ok {
+					net = big.NewInt(0)
+					nets[flow.token.Address] = net
+					tokens[flow.token.Address] = flow.token
+				}
+				net.Add(net, flow.amount)
+			}
+		}
+
+		var gains []common.Address
+		owing := false
+		for address, net := range nets {
+			switch net.Sign() {
+			case 1:
+				gains = append(gains, address)
+			case -1:
+				owing = true
+			}
+		}
+		if owing || len(gains) != 1 {
+			continue
+		}
+
+		sort.Slice(records, func(i int, j int) bool {
+			return records[i].LogIndex < records[j].LogIndex
+		})
+
+		involved := make([]*Pool, 0, len(pools))
+		for _, pool := range pools {
+			involved = append(involved, pool)
+		}
+		sort.Slice(involved, func(i int, j int) bool {
+			return involved[i].Name < involved[j].Name
+		})
+
+		event := MEVEvent{
+			Kind:        KindArbitrage,
+			LogIndex:    records[0].LogIndex,
+			Pools:       involved,
+			Attacker:    records[len(records)-1].To,
+			FrontRun:    hash,
+			ProfitToken: tokens[gains[0]],
+			Profit:      nets[gains[0]],
+			VictimLoss:  big.NewInt(0),
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// Point converts the event into an InfluxDB datapoint for the MEV measurement.
+// The timestamp of the block is offset by the log index of the first swap of
+// the event, so that several events of the same block do not overwrite each
+// other, while writing the same event again does.
+func (e MEVEvent) Point(chainName string, timestamp time.Time) *write.Point {
+
+	names := ""
+	for i, pool := range e.Pools {
+		if i > 0 {
+			names += ","
+		}
+		names += pool.Name
+	}
+
+	victims := ""
+	for i, victim := range e.Victims {
+		if i > 0 {
+			victims += ","
+		}
+		victims += victim.Hex()
+	}
+
+	// The first pool of the event ties it to a pair, so that it is removed
+	// along with the datapoints of the pair when its block is reverted.
+	tags := map[string]string{
+		"chain":        chainName,
+		"pair":         names,
+		"pair_address": e.Pools[0].Address.Hex(),
+		"kind":         e.Kind,
+	}
+	fields := map[string]interface{}{
+		"height":       e.Height,
+		"attacker":     e.Attacker.Hex(),
+		"front_run":    e.FrontRun.Hex(),
+		"victims":      victims,
+		"back_run":     e.BackRun.Hex(),
+		"profit_token": e.ProfitToken.Symbol,
+		"profit":       hex.EncodeToString(e.Profit.Bytes()),
+		"loss_token":   e.LossToken.Symbol,
+		"victim_loss":  hex.EncodeToString(e.VictimLoss.Bytes()),
+	}
+
+	return blockPoint(mevMeasurement, tags, fields, e.Height, timestamp, e.LogIndex)
+}
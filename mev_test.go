@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestDetectSandwiches(t *testing.T) {
+
+	pool := &Pool{
+		Address: common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		Name:    "AAA/BBB",
+		Token0:  Token{Symbol: "AAA"},
+		Token1:  Token{Symbol: "BBB"},
+	}
+	router := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	attacker := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	victim := common.HexToAddress("0x00000000000000000000000000000000000000cc")
+	other := common.HexToAddress("0x00000000000000000000000000000000000000dd")
+
+	// swap builds a swap record in the given direction; the reserves before
+	// it are only needed on the front-run.
+	swap := func(tx int64, index uint, sender common.Address, to common.Address, direction int, in int64, out int64) SwapRecord {
+		s := Swap{
+			Amount0In:  big.NewInt(0),
+			Amount1In:  big.NewInt(0),
+			Amount0Out: big.NewInt(0),
+			Amount1Out: big.NewInt(0),
+		}
+		if direction == 0 {
+			s.Amount0In.SetInt64(in)
+			s.Amount1Out.SetInt64(out)
+		} else {
+			s.Amount1In.SetInt64(in)
+			s.Amount0Out.SetInt64(out)
+		}
+		return SwapRecord{
+			Pool:     pool,
+			TxHash:   common.BigToHash(big.NewInt(tx)),
+			TxIndex:  uint(tx),
+			LogIndex: index,
+			Sender:   sender,
+			To:       to,
+			Swap:     s,
+			Before0:  big.NewInt(1000000),
+			Before1:  big.NewInt(1000000),
+		}
+	}
+
+	// With reserves of 1M/1M and a 0.3% fee, the attacker buys with 100k,
+	// the victim then gets 39423 instead of 47482 for 50k, and the attacker
+	// sells back into the moved pool for 108244.
+	front := swap(1, 1, router, attacker, 0, 100000, 90661)
+	target := swap(2, 2, router, victim, 0, 50000, 39423)
+	back := swap(3, 3, attacker, attacker, 1, 90661, 108244)
+
+	tests := []struct {
+		name   string
+		swaps  []SwapRecord
+		found  bool
+		profit int64
+		loss   int64
+	}{
+		{
+			name:   "sandwich",
+			swaps:  []SwapRecord{front, target, back},
+			found:  true,
+			profit: 8244,
+			loss:   8059,
+		},
+		{
+			name:   "unordered logs",
+			swaps:  []SwapRecord{back, front, target},
+			found:  true,
+			profit: 8244,
+			loss:   8059,
+		},
+		{
+			name:  "no victim",
+			swaps: []SwapRecord{front, swap(3, 3, attacker, attacker, 1, 90661, 99454)},
+		},
+		{
+			name:  "victim in the other direction",
+			swaps: []SwapRecord{front, swap(2, 2, router, victim, 1, 50000, 45000), back},
+		},
+		{
+			name:  "back-run by another actor",
+			swaps: []SwapRecord{front, target, swap(3, 3, other, other, 1, 90661, 108244)},
+		},
+		{
+			name:  "unprofitable",
+			swaps: []SwapRecord{front, target, swap(3, 3, attacker, attacker, 1, 90661, 99000)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			events := detectSandwiches(test.swaps, NewFees(30))
+			if !test.found {
+				if len(events) != 0 {
+					t.Fatalf("got %d events, want none", len(events))
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want one", len(events))
+			}
+
+			event := events[0]
+			if event.Kind != KindSandwich || event.Attacker != attacker {
+				t.Errorf("event = %s by %s, want %s by %s", event.Kind, event.Attacker.Hex(), KindSandwich, attacker.Hex())
+			}
+			if event.FrontRun != front.TxHash || event.BackRun != back.TxHash {
+				t.Errorf("front/back-run = %s/%s, want %s/%s", event.FrontRun.Hex(), event.BackRun.Hex(), front.TxHash.Hex(), back.TxHash.Hex())
+			}
+			if len(event.Victims) != 1 || event.Victims[0] != target.TxHash {
+				t.Errorf("victims = %v, want [%s]", event.Victims, target.TxHash.Hex())
+			}
+			if event.Profit.Cmp(big.NewInt(test.profit)) != 0 {
+				t.Errorf("profit = %s, want %d", event.Profit, test.profit)
+			}
+			if event.VictimLoss.Cmp(big.NewInt(test.loss)) != 0 {
+				t.Errorf("victim loss = %s, want %d", event.VictimLoss, test.loss)
+			}
+		})
+	}
+}
@@ -0,0 +1,1115 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Config holds the settings of the miner that determine which datapoints are
+// derived from the log entries.
+type Config struct {
+	WriteMetrics bool
+
+	CandleIntervals []string
+
+	Stablecoins    []string
+	ReferencePairs []string
+
+	FeeBps    uint
+	APRWindow time.Duration
+
+	DepthLevels []float64
+
+	DetectMEV       bool
+	DetectArbitrage bool
+	MaxHops         int
+
+	DetectWashTrading  bool
+	AnomalyInterval    string
+	CircularWindow     time.Duration
+	DominanceThreshold float64
+	TurnoverThreshold  float64
+
+	CheckTransfers bool
+
+	Dense string
+}
+
+// activity is what happened to a pool within one block; amounts that are not
+// set are zero.
+type activity struct {
+	Volume0 *big.Int
+	Volume1 *big.Int
+	Mint0   *big.Int
+	Mint1   *big.Int
+	Open0   *big.Int
+	Open1   *big.Int
+	Swaps   []SwapRecord
+
+	Transactions uint
+	SwapCount    uint
+}
+
+// Miner processes the log entries of the indexed pools one block range at a
+// time and writes the resulting datapoints.
+type Miner struct {
+	log       zerolog.Logger
+	client    *ethclient.Client
+	sink      Sink
+	points    []*write.Point
+	pairABI   abi.ABI
+	chainName string
+	config    Config
+
+	pools     map[common.Address]*Pool
+	indexed   []*Pool
+	tracked   []*Pool
+	addresses []common.Address
+
+	fees      Fees
+	pricer    *Pricer
+	aprs      map[common.Address]*APR
+	candles   map[common.Address][]*Candles
+	anomalies map[common.Address]*Anomalies
+	arbitrage *Arbitrage
+
+	check     *TransferCheck
+	filterers map[common.Address]*ERC20Filterer
+	holders   map[common.Address][]common.Address
+
+	denseBlocks   bool
+	denseInterval time.Duration
+	height        uint64
+	next          time.Time
+
+	states []minerState
+}
+
+func NewMiner(log zerolog.Logger, client *ethclient.Client, sink Sink, chainName string, pairAddresses []string, config Config) (*Miner, error) {
+
+	seen := make(map[common.Address]struct{})
+	var indexed, references []*Pool
+	for _, pairAddress := range pairAddresses {
+		address := common.HexToAddress(pairAddress)
+		if _, ok := seen[address]; ok {
+			continue
+		}
+		pool, err := fetchPool(client, address)
+		if err != nil {
+			return nil, fmt.Errorf("could not get pair metadata (%s): %w", pairAddress, err)
+		}
+		seen[address] = struct{}{}
+		indexed = append(indexed, pool)
+	}
+	for _, pairAddress := range config.ReferencePairs {
+		address := common.HexToAddress(pairAddress)
+		if _, ok := seen[address]; ok {
+			continue
+		}
+		pool, err := fetchPool(client, address)
+		if err != nil {
+			return nil, fmt.Errorf("could not get reference pair metadata (%s): %w", pairAddress, err)
+		}
+		seen[address] = struct{}{}
+		references = append(references, pool)
+	}
+
+	miner, err := newMiner(log, client, sink, chainName, indexed, references, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The protocol fee recipients are read once from the latest state, which
+	// any node serves; loading the state before the first processed height
+	// replaces them with the historical ones where the node has them.
+	err = miner.refreshFees()
+	if err != nil {
+		return nil, fmt.Errorf("could not get protocol fee recipients: %w", err)
+	}
+
+	return miner, nil
+}
+
+// NewReplayMiner creates a miner for pools whose metadata is already known,
+// which processes the log entries handed to it with Replay without a client.
+// The transfer check needs the token balances, so it is not available.
+func NewReplayMiner(log zerolog.Logger, sink Sink, chainName string, indexed []*Pool, references []*Pool, config Config) (*Miner, error) {
+	if config.CheckTransfers {
+		return nil, fmt.Errorf("transfer check not available without client")
+	}
+	return newMiner(log, nil, sink, chainName, indexed, references, config)
+}
+
+func newMiner(log zerolog.Logger, client *ethclient.Client, sink Sink, chainName string, indexed []*Pool, references []*Pool, config Config) (*Miner, error) {
+
+	pairABI, err := abi.JSON(strings.NewReader(PairMetaData.ABI))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Uniswap Pair ABI: %w", err)
+	}
+
+	m := Miner{
+		log:       log,
+		client:    client,
+		sink:      sink,
+		pairABI:   pairABI,
+		chainName: chainName,
+		config:    config,
+
+		pools: make(map[common.Address]*Pool),
+
+		fees:      NewFees(config.FeeBps),
+		aprs:      make(map[common.Address]*APR),
+		candles:   make(map[common.Address][]*Candles),
+		anomalies: make(map[common.Address]*Anomalies),
+
+		filterers: make(map[common.Address]*ERC20Filterer),
+		holders:   make(map[common.Address][]common.Address),
+	}
+
+	// The indexed pools get datapoints written for them, while the reference
+	// pools are only tracked to route token prices to the stablecoins.
+	for _, pool := range indexed {
+		m.pools[pool.Address] = pool
+		m.indexed = append(m.indexed, pool)
+
+		log.Info().Str("pair_address", pool.Address.Hex()).Str("pair_name", pool.Name).Msg("determined labels for datapoints")
+	}
+	for _, pool := range references {
+		m.pools[pool.Address] = pool
+	}
+
+	for address, pool := range m.pools {
+		m.addresses = append(m.addresses, address)
+		m.tracked = append(m.tracked, pool)
+	}
+
+	if len(config.Stablecoins) > 0 {
+		stables := make([]common.Address, 0, len(config.Stablecoins))
+		for _, stablecoin := range config.Stablecoins {
+			stables = append(stables, common.HexToAddress(stablecoin))
+		}
+		m.pricer = NewPricer(stables, m.tracked)
+	}
+
+	for _, pool := range m.indexed {
+		m.aprs[pool.Address] = NewAPR(config.APRWindow)
+		for _, label := range config.CandleIntervals {
+			aggregator, err := NewCandles(label)
+			if err != nil {
+				return nil, fmt.Errorf("invalid candle interval: %w", err)
+			}
+			m.candles[pool.Address] = append(m.candles[pool.Address], aggregator)
+		}
+	}
+
+	if config.DetectWashTrading {
+		for _, pool := range m.indexed {
+			detector, err := NewAnomalies(config.AnomalyInterval, config.CircularWindow, config.DominanceThreshold, config.TurnoverThreshold)
+			if err != nil {
+				return nil, fmt.Errorf("invalid anomaly interval: %w", err)
+			}
+			m.anomalies[pool.Address] = detector
+		}
+	}
+
+	// The transfer check needs the transfers of every token from and to the
+	// indexed pools that contain it.
+	if config.CheckTransfers {
+		m.check = NewTransferCheck(m.indexed)
+		for _, pool := range m.indexed {
+			for _, token := range []Token{pool.Token0, pool.Token1} {
+				_, ok := m.filterers[token.Address]
+				if !ok {
+					filterer, err := NewERC20Filterer(token.Address, client)
+					if err != nil {
+						return nil, fmt.Errorf("could not bind token filterer (%s): %w", token.Symbol, err)
+					}
+					m.filterers[token.Address] = filterer
+				}
+				m.holders[token.Address] = append(m.holders[token.Address], pool.Address)
+			}
+		}
+	}
+
+	if config.DetectArbitrage {
+		m.arbitrage = NewArbitrage(m.tracked, m.fees, config.MaxHops)
+	}
+
+	switch config.Dense {
+	case "":
+	case "block":
+		m.denseBlocks = true
+	default:
+		interval, err := parseInterval(config.Dense)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dense interval: %w", err)
+		}
+		m.denseInterval = interval
+	}
+
+	// Sinks that keep the metadata of the pools get it before any datapoint.
+	tracker, ok := sink.(Tracker)
+	if ok {
+		err = tracker.Track(chainName, m.indexed)
+		if err != nil {
+			return nil, fmt.Errorf("could not hand pair metadata to sinks: %w", err)
+		}
+	}
+
+	return &m, nil
+}
+
+// Pools returns the indexed pools, for which datapoints are written.
+func (m *Miner) Pools() []*Pool {
+	return m.indexed
+}
+
+// Load sets the state of all tracked pools to their state at the given height.
+// The state before the first processed height is needed for pools that have no
+// events in the first blocks; without an archive node, the reserves stay
+// unknown until the first sync event of the pool, and the protocol fee
+// recipients stay the current ones.
+func (m *Miner) Load(height uint64) {
+	m.height = height
+	for _, pool := range m.tracked {
+		start := time.Now()
+		err := pool.fetchState(m.client, height)
+		observeRPC("eth_call", start, err)
+		if err != nil {
+			m.log.Warn().Str("pair_name", pool.Name).Uint64("height", height).Err(err).Msg("could not get pair state")
+		}
+	}
+}
+
+// refreshFees updates the protocol fee recipients of the tracked pools to the
+// current ones of their factory, so that the fee being switched on or off is
+// picked up from the next processed block. It only reads the latest state, so
+// it is called when following the chain head rather than for every range.
+func (m *Miner) refreshFees() error {
+	recipients := make(map[common.Address]common.Address)
+	for _, pool := range m.tracked {
+		feeTo, ok := recipients[pool.Factory]
+		if !ok {
+			start := time.Now()
+			var err error
+			feeTo, err = fetchFeeTo(m.client, pool.Factory, nil)
+			observeRPC("eth_call", start, err)
+			if err != nil {
+				return fmt.Errorf("could not get protocol fee recipient (%s): %w", pool.Factory.Hex(), err)
+			}
+			recipients[pool.Factory] = feeTo
+		}
+		pool.FeeTo = feeTo
+	}
+	return nil
+}
+
+// Process retrieves the log entries of the tracked pools for the given block
+// range, inclusively, and writes the datapoints derived from them. The protocol
+// fee recipients are not read again for the range, so a fee switched on or off
+// within processed ranges is only picked up when following the chain head.
+func (m *Miner) Process(from uint64, to uint64) error {
+
+	query := ethereum.FilterQuery{
+		FromBlock: big.NewInt(0).SetUint64(from),
+		ToBlock:   big.NewInt(0).SetUint64(to),
+		Addresses: m.addresses,
+		Topics:    [][]common.Hash{{SigSwap, SigSync, SigMint, SigBurn}},
+	}
+
+	start := time.Now()
+	entries, err := m.client.FilterLogs(context.Background(), query)
+	observeRPC("eth_getLogs", start, err)
+	if err != nil {
+		return fmt.Errorf("could not retrieve filtered log entries: %w", err)
+	}
+
+	return m.Apply(from, to, entries)
+}
+
+// Apply processes the given log entries of the tracked pools, which have to
+// be all of their entries for the given block range, and writes the datapoints
+// derived from them.
+func (m *Miner) Apply(from uint64, to uint64, entries []types.Log) error {
+
+	log := m.log.With().Uint64("from", from).Uint64("to", to).Logger()
+
+	log.Debug().Int("entries", len(entries)).Msg("processing log entries for block range")
+
+	if m.check != nil {
+		transfers, err := m.transfers(from, to)
+		if err != nil {
+			return fmt.Errorf("could not retrieve token transfers: %w", err)
+		}
+		entries = append(entries, transfers...)
+		sort.SliceStable(entries, func(i int, j int) bool {
+			if entries[i].BlockNumber != entries[j].BlockNumber {
+				return entries[i].BlockNumber < entries[j].BlockNumber
+			}
+			return entries[i].Index < entries[j].Index
+		})
+	}
+
+	heights := m.heights(from, to, entries)
+
+	log.Debug().Int("heights", len(heights)).Msg("retrieving timestamps for heights")
+
+	timestamps := make(map[uint64]time.Time, len(heights))
+	var failed error
+	mutex := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for _, height := range heights {
+		wg.Add(1)
+		go func(height uint64) {
+			defer wg.Done()
+			start := time.Now()
+			header, err := m.client.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(height))
+			observeRPC("eth_getBlockByNumber", start, err)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				failed = fmt.Errorf("could not get header for height (%d): %w", height, err)
+				return
+			}
+			timestamps[height] = time.Unix(int64(header.Time), 0).UTC()
+		}(height)
+	}
+	wg.Wait()
+	if failed != nil {
+		return failed
+	}
+
+	return m.apply(log, from, to, entries, heights, timestamps)
+}
+
+// Replay processes the given log entries of the tracked pools like Apply, but
+// with the given block timestamps instead of the headers from the client. The
+// timestamps have to include every height that is processed.
+func (m *Miner) Replay(from uint64, to uint64, entries []types.Log, blocks map[uint64]time.Time) error {
+
+	log := m.log.With().Uint64("from", from).Uint64("to", to).Logger()
+
+	// Entries of other contracts, like token transfers, are skipped, as the
+	// transfer check is not available.
+	relevant := make([]types.Log, 0, len(entries))
+	for _, entry := range entries {
+		_, ok := m.pools[entry.Address]
+		if ok {
+			relevant = append(relevant, entry)
+		}
+	}
+
+	heights := m.heights(from, to, relevant)
+	timestamps := make(map[uint64]time.Time, len(heights))
+	for _, height := range heights {
+		timestamp, ok := blocks[height]
+		if !ok {
+			return fmt.Errorf("missing timestamp for height (%d)", height)
+		}
+		timestamps[height] = timestamp
+	}
+
+	return m.apply(log, from, to, relevant, heights, timestamps)
+}
+
+// Restore sets the state of the tracked pools to the given snapshots, which
+// were taken at the given height.
+func (m *Miner) Restore(height uint64, snapshots []Snapshot) {
+	m.height = height
+	for _, snapshot := range snapshots {
+		pool, ok := m.pools[snapshot.Address]
+		if !ok {
+			continue
+		}
+		snapshot.Apply(pool)
+	}
+}
+
+// heights returns the heights to process for the given log entries, in
+// ascending order.
+func (m *Miner) heights(from uint64, to uint64, entries []types.Log) []uint64 {
+
+	set := make(map[uint64]struct{})
+	for _, entry := range entries {
+		set[entry.BlockNumber] = struct{}{}
+	}
+
+	// In dense mode, heights without events are processed as well: every
+	// height of the range when there is a datapoint for every block, and the
+	// end of the range otherwise, up to which the intervals are filled.
+	switch {
+	case m.denseBlocks:
+		for height := from; height <= to; height++ {
+			set[height] = struct{}{}
+		}
+	case m.denseInterval > 0:
+		set[to] = struct{}{}
+	}
+
+	heights := make([]uint64, 0, len(set))
+	for height := range set {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i int, j int) bool {
+		return heights[i] < heights[j]
+	})
+
+	return heights
+}
+
+// apply derives the datapoints from the log entries of the given heights and
+// hands them to the sink.
+func (m *Miner) apply(log zerolog.Logger, from uint64, to uint64, entries []types.Log, heights []uint64, timestamps map[uint64]time.Time) error {
+
+	// Sinks that store the blocks and log entries themselves get them before
+	// the datapoints derived from them, along with the state of the pools
+	// before the range.
+	recorder, ok := m.sink.(Recorder)
+	if ok {
+		err := recorder.Record(from, to, m.tracked, timestamps, entries)
+		if err != nil {
+			return fmt.Errorf("could not record log entries: %w", err)
+		}
+	}
+
+	logs := make(map[uint64][]types.Log)
+	for _, entry := range entries {
+		logs[entry.BlockNumber] = append(logs[entry.BlockNumber], entry)
+	}
+
+	log.Debug().Int("heights", len(heights)).Msg("writing datapoints for heights")
+
+	if m.check != nil {
+		m.check.Reset()
+	}
+
+	var swap Swap
+	var sick Sync
+	var mint Mint
+	var burn Burn
+	priors0 := make(map[common.Address]*big.Int)
+	priors1 := make(map[common.Address]*big.Int)
+	for _, height := range heights {
+
+		timestamp := timestamps[height]
+
+		if m.denseInterval > 0 {
+			m.fill(log, timestamp)
+		}
+
+		touched := make(map[common.Address]struct{})
+		volumes0 := make(map[common.Address]*big.Int)
+		volumes1 := make(map[common.Address]*big.Int)
+		mints0 := make(map[common.Address]*big.Int)
+		mints1 := make(map[common.Address]*big.Int)
+		opens0 := make(map[common.Address]*big.Int)
+		opens1 := make(map[common.Address]*big.Int)
+		transactions := make(map[common.Address]map[common.Hash]struct{})
+		swapCounts := make(map[common.Address]uint)
+		var swaps []SwapRecord
+
+		// Log entries are returned in the order of execution, so the pools
+		// end up with their reserves at the end of the block.
+		for _, entry := range logs[height] {
+
+			if entry.Topics[0] == SigTransfer {
+				m.check.Transfer(entry)
+				continue
+			}
+
+			pool := m.pools[entry.Address]
+			_, ok := touched[pool.Address]
+			if !ok {
+				opens0[pool.Address] = pool.Reserve0
+				opens1[pool.Address] = pool.Reserve1
+			}
+			touched[pool.Address] = struct{}{}
+
+			_, ok = transactions[pool.Address]
+			if !ok {
+				transactions[pool.Address] = make(map[common.Hash]struct{})
+			}
+			transactions[pool.Address][entry.TxHash] = struct{}{}
+
+			switch entry.Topics[0] {
+
+			case SigSync:
+
+				err := m.pairABI.UnpackIntoInterface(&sick, "Sync", entry.Data)
+				if err != nil {
+					return fmt.Errorf("could not unpack sync event: %w", err)
+				}
+
+				if m.check != nil {
+					flagged := m.check.Sync(pool, sick.Reserve0, sick.Reserve1)
+					for _, token := range flagged {
+						log.Warn().
+							Str("token", token.Symbol).
+							Bool("fee_on_transfer", token.FeeOnTransfer).
+							Bool("rebasing", token.Rebasing).
+							Uint64("height", height).
+							Msg("token transfers do not match reserves")
+					}
+				}
+
+				priors0[pool.Address] = pool.Reserve0
+				priors1[pool.Address] = pool.Reserve1
+				pool.Reserve0 = new(big.Int).Set(sick.Reserve0)
+				pool.Reserve1 = new(big.Int).Set(sick.Reserve1)
+
+				log.Debug().
+					Str("pair_name", pool.Name).
+					Str("reserve0", sick.Reserve0.String()).
+					Str("reserve1", sick.Reserve1.String()).
+					Msg("sync decoded")
+
+			case SigSwap:
+
+				err := m.pairABI.UnpackIntoInterface(&swap, "Swap", entry.Data)
+				if err != nil {
+					return fmt.Errorf("could not unpack swap event: %w", err)
+				}
+
+				volume0, ok := volumes0[pool.Address]
+				if !ok {
+					volume0 = big.NewInt(0)
+					volumes0[pool.Address] = volume0
+				}
+				volume0.Add(volume0, swap.Amount0In)
+
+				volume1, ok := volumes1[pool.Address]
+				if !ok {
+					volume1 = big.NewInt(0)
+					volumes1[pool.Address] = volume1
+				}
+				volume1.Add(volume1, swap.Amount1In)
+
+				swapCounts[pool.Address]++
+
+				if m.config.DetectMEV || m.config.DetectWashTrading {
+					swaps = append(swaps, NewSwapRecord(pool, entry, swap, priors0[pool.Address], priors1[pool.Address]))
+				}
+
+				log.Debug().
+					Str("pair_name", pool.Name).
+					Str("volume0", volume0.String()).
+					Str("volume1", volume1.String()).
+					Msg("swap decoded")
+
+			case SigMint, SigBurn:
+
+				var err error
+				var amount0, amount1 *big.Int
+				if entry.Topics[0] == SigMint {
+					err = m.pairABI.UnpackIntoInterface(&mint, "Mint", entry.Data)
+					amount0, amount1 = mint.Amount0, mint.Amount1
+				} else {
+					err = m.pairABI.UnpackIntoInterface(&burn, "Burn", entry.Data)
+					amount0, amount1 = burn.Amount0, burn.Amount1
+				}
+				if err != nil {
+					return fmt.Errorf("could not unpack liquidity event: %w", err)
+				}
+
+				log.Debug().
+					Str("pair_name", pool.Name).
+					Str("amount0", amount0.String()).
+					Str("amount1", amount1.String()).
+					Msg("liquidity change decoded")
+
+				// The protocol fee is minted before the liquidity changes, based on
+				// the reserves that preceded the sync event of the operation; the
+				// invariant is then recorded with the reserves after it. With the
+				// fee switched off, the pair resets the invariant instead.
+				if !pool.FeeOn() {
+					pool.KLast = big.NewInt(0)
+					continue
+				}
+				prior0, prior1 := priors0[pool.Address], priors1[pool.Address]
+				if prior0 != nil && prior1 != nil {
+					minted0, minted1 := protocolMint(prior0, prior1, pool.KLast)
+
+					mint0, ok := mints0[pool.Address]
+					if !ok {
+						mint0 = big.NewInt(0)
+						mints0[pool.Address] = mint0
+					}
+					mint0.Add(mint0, minted0)
+
+					mint1, ok := mints1[pool.Address]
+					if !ok {
+						mint1 = big.NewInt(0)
+						mints1[pool.Address] = mint1
+					}
+					mint1.Add(mint1, minted1)
+				}
+				pool.KLast = new(big.Int).Mul(pool.Reserve0, pool.Reserve1)
+			}
+		}
+
+		var prices map[common.Address]Price
+		if m.pricer != nil {
+			prices = m.pricer.Prices()
+		}
+
+		if m.config.DetectMEV {
+			events := append(detectSandwiches(swaps, m.fees), detectArbitrages(swaps)...)
+			for _, event := range events {
+
+				event.Height = height
+
+				log.Info().
+					Str("kind", event.Kind).
+					Uint64("height", height).
+					Str("attacker", event.Attacker.Hex()).
+					Str("front_run", event.FrontRun.Hex()).
+					Str("profit_token", event.ProfitToken.Symbol).
+					Str("profit", event.Profit.String()).
+					Str("victim_loss", event.VictimLoss.String()).
+					Msg("MEV event detected")
+
+				if !m.config.WriteMetrics {
+					continue
+				}
+				point := event.Point(m.chainName, timestamp)
+				price, ok := prices[event.ProfitToken.Address]
+				if ok {
+					point.AddField("profit_usd", tokenAmount(event.Profit, event.ProfitToken.Decimals)*price.USD)
+				}
+				m.write(point)
+			}
+		}
+
+		if m.arbitrage != nil {
+			closed := m.arbitrage.Update(height, timestamp)
+			for _, opportunity := range closed {
+
+				log.Info().
+					Str("route", opportunity.Route).
+					Uint64("start_height", opportunity.StartHeight).
+					Uint64("end_height", opportunity.EndHeight).
+					Float64("profit", opportunity.Profit).
+					Msg("arbitrage opportunity closed")
+
+				if !m.config.WriteMetrics {
+					continue
+				}
+				point := opportunity.Point(m.chainName)
+				price, ok := prices[opportunity.Token.Address]
+				if ok {
+					point.AddField("profit_usd", opportunity.Profit*price.USD)
+				}
+				m.write(point)
+			}
+		}
+
+		for _, pool := range m.indexed {
+
+			// In dense mode, pools without events in the block still get their
+			// reserves carried forward, once their reserves are known.
+			_, ok := touched[pool.Address]
+			if !ok && (!m.denseBlocks || pool.Reserve0 == nil || pool.Reserve1 == nil) {
+				continue
+			}
+
+			change := activity{
+				Volume0: volumes0[pool.Address],
+				Volume1: volumes1[pool.Address],
+				Mint0:   mints0[pool.Address],
+				Mint1:   mints1[pool.Address],
+				Open0:   opens0[pool.Address],
+				Open1:   opens1[pool.Address],
+
+				Transactions: uint(len(transactions[pool.Address])),
+				SwapCount:    swapCounts[pool.Address],
+			}
+			for _, record := range swaps {
+				if record.Pool == pool {
+					change.Swaps = append(change.Swaps, record)
+				}
+			}
+
+			m.emit(log, pool, height, timestamp, false, prices, change)
+		}
+
+		m.height = height
+	}
+
+	// Rebasing tokens can change the balances of pools without any event, so
+	// the balances at the end of the range are compared to the reserves.
+	if m.check != nil {
+		opts := bind.CallOpts{
+			BlockNumber: new(big.Int).SetUint64(to),
+		}
+		for _, pool := range m.indexed {
+			token0, err := NewERC20Caller(pool.Token0.Address, m.client)
+			if err != nil {
+				return fmt.Errorf("could not bind first token contract: %w", err)
+			}
+			token1, err := NewERC20Caller(pool.Token1.Address, m.client)
+			if err != nil {
+				return fmt.Errorf("could not bind second token contract: %w", err)
+			}
+			start := time.Now()
+			balance0, err := token0.BalanceOf(&opts, pool.Address)
+			observeRPC("eth_call", start, err)
+			if err != nil {
+				log.Warn().Str("pair_name", pool.Name).Err(err).Msg("could not get first token balance")
+				continue
+			}
+			start = time.Now()
+			balance1, err := token1.BalanceOf(&opts, pool.Address)
+			observeRPC("eth_call", start, err)
+			if err != nil {
+				log.Warn().Str("pair_name", pool.Name).Err(err).Msg("could not get second token balance")
+				continue
+			}
+			flagged := m.check.Balances(pool, balance0, balance1)
+			for _, token := range flagged {
+				log.Warn().
+					Str("token", token.Symbol).
+					Bool("rebasing", token.Rebasing).
+					Uint64("height", to).
+					Msg("token balance of pair does not match reserves")
+			}
+		}
+	}
+
+	sinkQueue.Set(float64(len(m.points)))
+	err := m.sink.Write(m.points)
+	m.points = nil
+	if err != nil {
+		return err
+	}
+	err = m.sink.Checkpoint(to)
+	if err != nil {
+		return err
+	}
+
+	blocksProcessed.Add(float64(to - from + 1))
+	logEntries.Add(float64(len(entries)))
+	observeHeight(to)
+
+	log.Info().Int("entries", len(entries)).Int("heights", len(heights)).Msg("processed log entries for block range")
+
+	return nil
+}
+
+// emit derives the datapoint of a pool at the given height from its current
+// reserves and the given activity, and queues it for writing along with the
+// aggregates it completes. Datapoints carried forward to an interval boundary
+// are keyed by the boundary alone, as they belong to no block; this keeps them
+// apart from the datapoint of a block with the same timestamp.
+func (m *Miner) emit(log zerolog.Logger, pool *Pool, height uint64, timestamp time.Time, carried bool, prices map[common.Address]Price, change activity) {
+
+	reserve0 := pool.Reserve0
+	if reserve0 == nil {
+		reserve0 = big.NewInt(0)
+	}
+	reserve1 := pool.Reserve1
+	if reserve1 == nil {
+		reserve1 = big.NewInt(0)
+	}
+
+	volume0 := change.Volume0
+	if volume0 == nil {
+		volume0 = big.NewInt(0)
+	}
+	volume1 := change.Volume1
+	if volume1 == nil {
+		volume1 = big.NewInt(0)
+	}
+
+	mint0 := change.Mint0
+	if mint0 == nil {
+		mint0 = big.NewInt(0)
+	}
+	mint1 := change.Mint1
+	if mint1 == nil {
+		mint1 = big.NewInt(0)
+	}
+
+	fee0, protocol0 := m.fees.Split(volume0, pool.FeeOn())
+	fee1, protocol1 := m.fees.Split(volume1, pool.FeeOn())
+
+	point := Datapoint{
+		Height:    height,
+		Timestamp: timestamp,
+		Reserve0:  reserve0,
+		Reserve1:  reserve1,
+		Volume0:   volume0,
+		Volume1:   volume1,
+		Fee0:      fee0,
+		Fee1:      fee1,
+	}
+
+	// The APR is valued in units of token1, which avoids depending on
+	// the USD valuation being configured.
+	price := spotPrice(reserve0, reserve1, pool.Token0.Decimals, pool.Token1.Decimals)
+	earned := tokenAmount(fee0, pool.Token0.Decimals)*price + tokenAmount(fee1, pool.Token1.Decimals)
+	locked := tokenAmount(reserve0, pool.Token0.Decimals)*price + tokenAmount(reserve1, pool.Token1.Decimals)
+	apr := m.aprs[pool.Address].Add(timestamp, earned, locked)
+
+	// The price impact is that of all operations of the block, measured
+	// against the reserves at the start of the block.
+	impact := 0.0
+	open0, open1 := change.Open0, change.Open1
+	if open0 != nil && open1 != nil {
+		impact = priceImpact(open0, open1, reserve0, reserve1)
+	}
+
+	if m.config.WriteMetrics {
+		tags := map[string]string{
+			"chain":        m.chainName,
+			"pair":         pool.Name,
+			"pair_address": pool.Address.Hex(),
+		}
+		fields := map[string]interface{}{
+			"height":         height,
+			"reserve0":       hex.EncodeToString(reserve0.Bytes()),
+			"reserve1":       hex.EncodeToString(reserve1.Bytes()),
+			"volume0":        hex.EncodeToString(volume0.Bytes()),
+			"volume1":        hex.EncodeToString(volume1.Bytes()),
+			"fee0":           hex.EncodeToString(fee0.Bytes()),
+			"fee1":           hex.EncodeToString(fee1.Bytes()),
+			"protocol_fee0":  hex.EncodeToString(protocol0.Bytes()),
+			"protocol_fee1":  hex.EncodeToString(protocol1.Bytes()),
+			"protocol_mint0": hex.EncodeToString(mint0.Bytes()),
+			"protocol_mint1": hex.EncodeToString(mint1.Bytes()),
+			"lp_apr":         apr,
+			"price_impact":   impact,
+			"transactions":   change.Transactions,
+			"swaps":          change.SwapCount,
+		}
+
+		// Volumes are based on the amounts the pool actually received, so
+		// they are not affected by transfer fees; the reserves of flagged
+		// tokens are annotated with the changes transfers do not explain.
+		if m.check != nil {
+			unexplained0, unexplained1 := m.check.Unexplained(pool)
+			fields["unexplained0"] = tokenAmount(unexplained0, pool.Token0.Decimals)
+			fields["unexplained1"] = tokenAmount(unexplained1, pool.Token1.Decimals)
+			fields["fee_on_transfer0"] = pool.Token0.FeeOnTransfer
+			fields["fee_on_transfer1"] = pool.Token1.FeeOnTransfer
+			fields["rebasing0"] = pool.Token0.Rebasing
+			fields["rebasing1"] = pool.Token1.Rebasing
+		}
+
+		for _, level := range m.config.DepthLevels {
+			fields[depthField(0, level)] = hex.EncodeToString(m.fees.Depth(reserve0, level).Bytes())
+			fields[depthField(1, level)] = hex.EncodeToString(m.fees.Depth(reserve1, level).Bytes())
+		}
+
+		if m.pricer != nil {
+			amount0 := tokenAmount(volume0, pool.Token0.Decimals)
+			amount1 := tokenAmount(volume1, pool.Token1.Decimals)
+			valuation, ok := m.pricer.Value(prices, pool, amount0, amount1)
+			if ok {
+				fields["price0_usd"] = valuation.Price0
+				fields["price1_usd"] = valuation.Price1
+				fields["reserve_usd"] = valuation.ReserveUSD
+				fields["volume_usd"] = valuation.VolumeUSD
+				fields["tvl_usd"] = valuation.TVLUSD
+			}
+		}
+
+		if carried {
+			m.write(write.NewPoint(measurement, tags, fields, timestamp))
+		} else {
+			m.write(blockPoint(measurement, tags, fields, height, timestamp, 0))
+		}
+	}
+
+	pairUpdate.WithLabelValues(m.chainName, pool.Name).Set(float64(timestamp.Unix()))
+
+	detector, ok := m.anomalies[pool.Address]
+	if ok {
+		reports := detector.Add(timestamp, change.Swaps, reserve0)
+		for _, report := range reports {
+			if report.Score > 0 {
+				log.Info().
+					Str("pair_name", pool.Name).
+					Time("start", report.Start).
+					Float64("score", report.Score).
+					Strs("flags", report.Flags).
+					Msg("anomalous volume detected")
+			}
+			if m.config.WriteMetrics {
+				m.write(detector.Point(m.chainName, pool, report))
+			}
+		}
+	}
+
+	// The first candle opens at the price before the block, unless the pair
+	// had no reserves yet.
+	open := price
+	if open0 != nil && open1 != nil && open0.Sign() > 0 {
+		open = spotPrice(open0, open1, pool.Token0.Decimals, pool.Token1.Decimals)
+	}
+	for _, aggregator := range m.candles[pool.Address] {
+		completed := aggregator.Add(point, open, price)
+		if !m.config.WriteMetrics {
+			continue
+		}
+		for _, candle := range completed {
+			m.write(aggregator.Point(m.chainName, pool, candle))
+		}
+	}
+
+	log.Debug().
+		Str("pair_name", pool.Name).
+		Time("timestamp", timestamp).
+		Str("reserve0", reserve0.String()).
+		Str("reserve1", reserve1.String()).
+		Str("volume0", volume0.String()).
+		Str("volume1", volume1.String()).
+		Float64("lp_apr", apr).
+		Float64("price_impact", impact).
+		Msg("datapoint queued for writing")
+}
+
+// fill emits the datapoints of all indexed pools with known reserves at the
+// interval boundaries before the given block timestamp, with the reserves
+// carried forward from the last processed block and no volume. The boundaries
+// are based on block timestamps, so that the series of chains with different
+// block times line up, and the datapoints are keyed by the boundary alone, so
+// that they are replaced no matter where the processed ranges start, and do
+// not replace the datapoint of a block whose timestamp is on a boundary.
+func (m *Miner) fill(log zerolog.Logger, timestamp time.Time) {
+
+	if m.next.IsZero() {
+		m.next = timestamp.Truncate(m.denseInterval)
+		if m.next.Before(timestamp) {
+			m.next = m.next.Add(m.denseInterval)
+		}
+	}
+
+	// The reserves do not change between the boundaries, and neither do the
+	// prices.
+	var prices map[common.Address]Price
+	if m.pricer != nil && m.next.Before(timestamp) {
+		prices = m.pricer.Prices()
+	}
+
+	for ; m.next.Before(timestamp); m.next = m.next.Add(m.denseInterval) {
+		for _, pool := range m.indexed {
+			if pool.Reserve0 == nil || pool.Reserve1 == nil {
+				continue
+			}
+			m.emit(log, pool, m.height, m.next, true, prices, activity{})
+		}
+	}
+}
+
+// transfers retrieves the token transfers from and to the indexed pools for
+// the given block range. Transfers between two indexed pools show up both as
+// outgoing and as incoming transfers, so they are deduplicated on their
+// position.
+func (m *Miner) transfers(from uint64, to uint64) ([]types.Log, error) {
+
+	var entries []types.Log
+	seen := make(map[[2]uint64]struct{})
+	for address, filterer := range m.filterers {
+		opts := bind.FilterOpts{
+			Start:   from,
+			End:     &to,
+			Context: context.Background(),
+		}
+		start := time.Now()
+		outgoing, err := filterer.FilterTransfer(&opts, m.holders[address], nil)
+		observeRPC("eth_getLogs", start, err)
+		if err != nil {
+			return nil, fmt.Errorf("could not filter outgoing token transfers (%s): %w", address.Hex(), err)
+		}
+		start = time.Now()
+		incoming, err := filterer.FilterTransfer(&opts, nil, m.holders[address])
+		observeRPC("eth_getLogs", start, err)
+		if err != nil {
+			return nil, fmt.Errorf("could not filter incoming token transfers (%s): %w", address.Hex(), err)
+		}
+		for _, transfers := range []*ERC20TransferIterator{outgoing, incoming} {
+			for transfers.Next() {
+				raw := transfers.Event.Raw
+				position := [2]uint64{raw.BlockNumber, uint64(raw.Index)}
+				_, ok := seen[position]
+				if ok {
+					continue
+				}
+				seen[position] = struct{}{}
+				entries = append(entries, raw)
+			}
+			err = transfers.Error()
+			if err != nil {
+				return nil, fmt.Errorf("could not iterate token transfers (%s): %w", address.Hex(), err)
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// Close writes the aggregates that are still open and closes the sink.
+func (m *Miner) Close() error {
+
+	// The candles still being aggregated are written as well; they are
+	// overwritten with the complete values once a later run closes them.
+	for _, pool := range m.indexed {
+		for _, aggregator := range m.candles[pool.Address] {
+			candle, ok := aggregator.Current()
+			if ok {
+				m.write(aggregator.Point(m.chainName, pool, candle))
+			}
+		}
+	}
+
+	for _, pool := range m.indexed {
+		detector, ok := m.anomalies[pool.Address]
+		if !ok {
+			continue
+		}
+		report, ok := detector.Current()
+		if ok {
+			m.write(detector.Point(m.chainName, pool, report))
+		}
+	}
+
+	// The opportunities that are still open are written as well, so that a
+	// later run starting from the same height can close them.
+	if m.arbitrage != nil {
+		for _, opportunity := range m.arbitrage.Open() {
+			m.write(opportunity.Point(m.chainName))
+		}
+	}
+
+	err := m.sink.Write(m.points)
+	m.points = nil
+	if err != nil {
+		return err
+	}
+
+	return m.sink.Close()
+}
+
+// write queues a datapoint for the sink, if datapoints are written.
+func (m *Miner) write(point *write.Point) {
+	if m.config.WriteMetrics {
+		m.points = append(m.points, point)
+	}
+}
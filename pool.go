@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// factoryABI describes the recipient of the protocol fee of the Uniswap v2
+// factory.
+const factoryABI = `[{"constant":true,"inputs":[],"name":"feeTo","outputs":[{"internalType":"address","name":"","type":"address"}],"payable":false,"stateMutability":"view","type":"function"}]`
+
+// Pool is a Uniswap v2 pair tracked by the miner, along with its reserves as
+// of the last processed block.
+type Pool struct {
+	Address  common.Address
+	Name     string
+	Token0   Token
+	Token1   Token
+	Reserve0 *big.Int
+	Reserve1 *big.Int
+	KLast    *big.Int
+	Factory  common.Address
+	FeeTo    common.Address
+}
+
+func fetchPool(caller bind.ContractCaller, address common.Address) (*Pool, error) {
+
+	pairContract, err := NewPairCaller(address, caller)
+	if err != nil {
+		return nil, fmt.Errorf("could not bind pair contract: %w", err)
+	}
+
+	address0, err := pairContract.Token0(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not get first token address: %w", err)
+	}
+	address1, err := pairContract.Token1(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not get second token address: %w", err)
+	}
+
+	factory, err := pairContract.Factory(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not get factory address: %w", err)
+	}
+
+	token0, err := fetchToken(caller, address0)
+	if err != nil {
+		return nil, fmt.Errorf("could not get first token metadata: %w", err)
+	}
+	token1, err := fetchToken(caller, address1)
+	if err != nil {
+		return nil, fmt.Errorf("could not get second token metadata: %w", err)
+	}
+
+	p := Pool{
+		Address: address,
+		Name:    token0.Symbol + "/" + token1.Symbol,
+		Token0:  token0,
+		Token1:  token1,
+		Factory: factory,
+	}
+
+	return &p, nil
+}
+
+// fetchState loads the reserves, last invariant and protocol fee recipient of
+// the pool at the given height, which requires an archive node for heights
+// that are not recent.
+func (p *Pool) fetchState(caller bind.ContractCaller, height uint64) error {
+
+	pairContract, err := NewPairCaller(p.Address, caller)
+	if err != nil {
+		return fmt.Errorf("could not bind pair contract: %w", err)
+	}
+
+	opts := bind.CallOpts{
+		BlockNumber: new(big.Int).SetUint64(height),
+	}
+	reserves, err := pairContract.GetReserves(&opts)
+	if err != nil {
+		return fmt.Errorf("could not get reserves: %w", err)
+	}
+
+	kLast, err := pairContract.KLast(&opts)
+	if err != nil {
+		return fmt.Errorf("could not get last invariant: %w", err)
+	}
+
+	feeTo, err := fetchFeeTo(caller, p.Factory, &opts)
+	if err != nil {
+		return fmt.Errorf("could not get protocol fee recipient: %w", err)
+	}
+
+	p.Reserve0 = reserves.Reserve0
+	p.Reserve1 = reserves.Reserve1
+	p.KLast = kLast
+	p.FeeTo = feeTo
+
+	return nil
+}
+
+// fetchFeeTo loads the recipient of the protocol fee of a factory with the
+// given call options, at the latest height without them; the fee is switched
+// off when there is none.
+func fetchFeeTo(caller bind.ContractCaller, factory common.Address, opts *bind.CallOpts) (common.Address, error) {
+
+	factoryABI, err := abi.JSON(strings.NewReader(factoryABI))
+	if err != nil {
+		return common.Address{}, fmt.Errorf("invalid factory ABI: %w", err)
+	}
+	factoryContract := bind.NewBoundContract(factory, factoryABI, caller, nil, nil)
+
+	var out []interface{}
+	err = factoryContract.Call(opts, &out, "feeTo")
+	if err != nil {
+		return common.Address{}, fmt.Errorf("could not call factory: %w", err)
+	}
+
+	return *abi.ConvertType(out[0], new(common.Address)).(*common.Address), nil
+}
+
+// FeeOn returns whether the protocol fee is switched on for the pool, which is
+// the case whenever its factory has a recipient for it.
+func (p *Pool) FeeOn() bool {
+	return p.FeeTo != (common.Address{})
+}
+
+// Amounts returns the reserves of the pool as decimal-adjusted token amounts.
+func (p *Pool) Amounts() (float64, float64, bool) {
+	if p.Reserve0 == nil || p.Reserve1 == nil || p.Reserve0.Sign() == 0 || p.Reserve1.Sign() == 0 {
+		return 0, 0, false
+	}
+	return tokenAmount(p.Reserve0, p.Token0.Decimals), tokenAmount(p.Reserve1, p.Token1.Decimals), true
+}
+
+// clone returns a copy of the pool whose state can be changed independently.
+func (p *Pool) clone() Pool {
+	clone := *p
+	for _, amount := range []**big.Int{&clone.Reserve0, &clone.Reserve1, &clone.KLast} {
+		if *amount != nil {
+			*amount = new(big.Int).Set(*amount)
+		}
+	}
+	return clone
+}
+
+// tokenAmount converts a raw token amount into a decimal-adjusted amount.
+func tokenAmount(amount *big.Int, decimals uint8) float64 {
+	value, _ := new(big.Float).Quo(new(big.Float).SetInt(amount), pow10(decimals)).Float64()
+	return value
+}
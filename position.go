@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// PoolState is the state of a pool at the end of one block, including the
+// total supply of its liquidity tokens.
+type PoolState struct {
+	Height    uint64
+	Timestamp time.Time
+	Reserve0  *big.Int
+	Reserve1  *big.Int
+	Supply    *big.Int
+}
+
+// fetchPoolState loads the reserves and liquidity token supply of the pool at
+// the given height, which requires an archive node for heights that are not
+// recent.
+func fetchPoolState(caller bind.ContractCaller, address common.Address, height uint64) (PoolState, error) {
+
+	pairContract, err := NewPairCaller(address, caller)
+	if err != nil {
+		return PoolState{}, fmt.Errorf("could not bind pair contract: %w", err)
+	}
+
+	opts := bind.CallOpts{
+		BlockNumber: new(big.Int).SetUint64(height),
+	}
+	reserves, err := pairContract.GetReserves(&opts)
+	if err != nil {
+		return PoolState{}, fmt.Errorf("could not get reserves: %w", err)
+	}
+	supply, err := pairContract.TotalSupply(&opts)
+	if err != nil {
+		return PoolState{}, fmt.Errorf("could not get total supply: %w", err)
+	}
+
+	state := PoolState{
+		Height:   height,
+		Reserve0: reserves.Reserve0,
+		Reserve1: reserves.Reserve1,
+		Supply:   supply,
+	}
+
+	return state, nil
+}
+
+// Position reconstructs the liquidity position of a holder in a pool from the
+// transfers of its liquidity tokens. All values are expressed in decimal
+// units of token1, with token0 valued at the spot price of the pool.
+type Position struct {
+	pool *Pool
+
+	balance   *big.Int
+	hold0     float64
+	hold1     float64
+	cost      float64
+	liquidity float64
+}
+
+// PositionSnapshot is the performance of a position at one block height.
+type PositionSnapshot struct {
+	Height          uint64
+	Timestamp       time.Time
+	Balance         *big.Int
+	Amount0         float64
+	Amount1         float64
+	Value           float64
+	Hold            float64
+	Fees            float64
+	ImpermanentLoss float64
+	PnL             float64
+}
+
+func NewPosition(pool *Pool) *Position {
+
+	p := Position{
+		pool:    pool,
+		balance: big.NewInt(0),
+	}
+
+	return &p
+}
+
+// Transfer applies a change in the liquidity token balance of the holder at
+// the given pool state. Deposits add the underlying token amounts to the basket
+// the position is compared against when holding; withdrawals remove the same
+// share of the basket as they remove of the balance.
+func (p *Position) Transfer(amount *big.Int, state PoolState) {
+
+	if amount.Sign() == 0 || state.Supply.Sign() == 0 {
+		return
+	}
+
+	price := spotPrice(state.Reserve0, state.Reserve1, p.pool.Token0.Decimals, p.pool.Token1.Decimals)
+
+	if amount.Sign() > 0 {
+		share := ratio(amount, state.Supply)
+		amount0 := share * tokenAmount(state.Reserve0, p.pool.Token0.Decimals)
+		amount1 := share * tokenAmount(state.Reserve1, p.pool.Token1.Decimals)
+		p.hold0 += amount0
+		p.hold1 += amount1
+		p.cost += amount0*price + amount1
+		p.liquidity += toFloat(amount) * growth(state)
+		p.balance.Add(p.balance, amount)
+		return
+	}
+
+	remaining := 0.0
+	if p.balance.Sign() > 0 {
+		remaining = 1 - math.Min(1, ratio(new(big.Int).Neg(amount), p.balance))
+	}
+	p.hold0 *= remaining
+	p.hold1 *= remaining
+	p.cost *= remaining
+	p.liquidity *= remaining
+	p.balance.Add(p.balance, amount)
+	if p.balance.Sign() < 0 {
+		p.balance.SetUint64(0)
+	}
+}
+
+// Snapshot values the position at the given pool state. The liquidity of a
+// pool only grows per liquidity token through the fees, so the share of the
+// position value that is due to the growth since the deposits is the fees
+// earned. The impermanent loss compares the position without these fees to
+// holding the deposited tokens.
+func (p *Position) Snapshot(state PoolState) PositionSnapshot {
+
+	snapshot := PositionSnapshot{
+		Height:    state.Height,
+		Timestamp: state.Timestamp,
+		Balance:   new(big.Int).Set(p.balance),
+	}
+
+	if state.Supply.Sign() == 0 || p.balance.Sign() == 0 {
+		return snapshot
+	}
+
+	price := spotPrice(state.Reserve0, state.Reserve1, p.pool.Token0.Decimals, p.pool.Token1.Decimals)
+	share := ratio(p.balance, state.Supply)
+
+	snapshot.Amount0 = share * tokenAmount(state.Reserve0, p.pool.Token0.Decimals)
+	snapshot.Amount1 = share * tokenAmount(state.Reserve1, p.pool.Token1.Decimals)
+	snapshot.Value = snapshot.Amount0*price + snapshot.Amount1
+	snapshot.Hold = p.hold0*price + p.hold1
+
+	feeless := snapshot.Value * p.liquidity / (toFloat(p.balance) * growth(state))
+	snapshot.Fees = snapshot.Value - feeless
+	if snapshot.Hold > 0 {
+		snapshot.ImpermanentLoss = feeless/snapshot.Hold - 1
+	}
+	snapshot.PnL = snapshot.Value - p.cost
+
+	return snapshot
+}
+
+// growth returns the liquidity per liquidity token of the pool, which is the
+// square root of the invariant divided by the total supply.
+func growth(state PoolState) float64 {
+	k := new(big.Int).Mul(state.Reserve0, state.Reserve1)
+	return toFloat(new(big.Int).Sqrt(k)) / toFloat(state.Supply)
+}
+
+func ratio(numerator *big.Int, denominator *big.Int) float64 {
+	value, _ := new(big.Rat).SetFrac(numerator, denominator).Float64()
+	return value
+}
+
+func toFloat(amount *big.Int) float64 {
+	value, _ := new(big.Float).SetInt(amount).Float64()
+	return value
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Pricer derives the USD prices of tokens from the reserves of the tracked
+// pools, starting from the configured stablecoins and routing through the
+// pools that connect them to other tokens.
+type Pricer struct {
+	stablecoins []common.Address
+	pools       []*Pool
+}
+
+// Price is the USD price of a token, along with the USD liquidity of the
+// routes it was derived from.
+type Price struct {
+	USD       float64
+	Liquidity float64
+}
+
+func NewPricer(stablecoins []common.Address, pools []*Pool) *Pricer {
+
+	p := Pricer{
+		stablecoins: stablecoins,
+		pools:       pools,
+	}
+
+	return &p
+}
+
+// Prices returns the USD prices of all tokens that can be reached from one of
+// the stablecoins, given the current reserves of the pools. Tokens are priced
+// over the shortest routes available; when several routes of the same length
+// exist, such as WETH over both WETH/USDC and WETH/DAI, the price is the
+// average of the routes weighted by their liquidity, where the liquidity of a
+// route is that of its most shallow pool.
+func (p *Pricer) Prices() map[common.Address]Price {
+
+	prices := make(map[common.Address]Price)
+	for _, stablecoin := range p.stablecoins {
+		prices[stablecoin] = Price{USD: 1, Liquidity: math.Inf(1)}
+	}
+
+	type candidate struct {
+		weighted float64
+		weights  float64
+	}
+
+	for {
+
+		candidates := make(map[common.Address]*candidate)
+		for _, pool := range p.pools {
+
+			amount0, amount1, ok := pool.Amounts()
+			if !ok {
+				continue
+			}
+
+			price0, known0 := prices[pool.Token0.Address]
+			price1, known1 := prices[pool.Token1.Address]
+			if known0 == known1 {
+				continue
+			}
+
+			known, unknown := price0, pool.Token1.Address
+			knownAmount, unknownAmount := amount0, amount1
+			if known1 {
+				known, unknown = price1, pool.Token0.Address
+				knownAmount, unknownAmount = amount1, amount0
+			}
+
+			liquidity := math.Min(known.Liquidity, knownAmount*known.USD)
+			if liquidity <= 0 {
+				continue
+			}
+
+			c, ok := candidates[unknown]
+			if !ok {
+				c = &candidate{}
+				candidates[unknown] = c
+			}
+			c.weighted += known.USD * knownAmount / unknownAmount * liquidity
+			c.weights += liquidity
+		}
+
+		if len(candidates) == 0 {
+			break
+		}
+
+		for token, c := range candidates {
+			prices[token] = Price{USD: c.weighted / c.weights, Liquidity: c.weights}
+		}
+	}
+
+	return prices
+}
+
+// Valuation is the USD valuation of the reserves and volume of a pool at one
+// block height.
+type Valuation struct {
+	Price0     float64
+	Price1     float64
+	ReserveUSD float64
+	VolumeUSD  float64
+	TVLUSD     float64
+}
+
+// Value computes the USD valuation of a pool with the given volumes, if the
+// tokens of the pool could be priced. The reserve value counts both sides of
+// the pool at their derived prices, while the TVL only counts the side with
+// the most liquid price route, doubled, so that tokens priced through thin
+// pools can not inflate it.
+func (p *Pricer) Value(prices map[common.Address]Price, pool *Pool, volume0 float64, volume1 float64) (Valuation, bool) {
+
+	price0, ok0 := prices[pool.Token0.Address]
+	price1, ok1 := prices[pool.Token1.Address]
+	if !ok0 || !ok1 {
+		return Valuation{}, false
+	}
+
+	amount0, amount1, ok := pool.Amounts()
+	if !ok {
+		return Valuation{}, false
+	}
+
+	reserve0USD := amount0 * price0.USD
+	reserve1USD := amount1 * price1.USD
+
+	tvl := 2 * reserve0USD
+	if price1.Liquidity > price0.Liquidity {
+		tvl = 2 * reserve1USD
+	}
+
+	v := Valuation{
+		Price0:     price0.USD,
+		Price1:     price1.USD,
+		ReserveUSD: reserve0USD + reserve1USD,
+		VolumeUSD:  volume0*price0.USD + volume1*price1.USD,
+		TVLUSD:     tvl,
+	}
+
+	return v, true
+}
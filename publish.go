@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// messageVersion is the version of the message format; it changes whenever
+	// fields are removed or change their meaning.
+	messageVersion = 1
+
+	// publishTimeout is how long to wait for the messages of a range to be
+	// acknowledged before the checkpoint fails.
+	publishTimeout = 30 * time.Second
+)
+
+// Message is a decoded event of a pair, the aggregate of the events of a pair
+// within one block, or the revert of the blocks of a pair from a height on, as
+// published to the message bus. Amounts are exact decimal strings in the
+// smallest unit of each token.
+type Message struct {
+	Version   int               `json:"version"`
+	Kind      string            `json:"kind"`
+	Chain     string            `json:"chain"`
+	Pair      string            `json:"pair"`
+	PairName  string            `json:"pair_name"`
+	Height    uint64            `json:"height"`
+	Timestamp time.Time         `json:"timestamp"`
+	LogIndex  *uint             `json:"log_index,omitempty"`
+	TxHash    string            `json:"tx_hash,omitempty"`
+	Amounts   map[string]string `json:"amounts"`
+
+	Transactions *uint64 `json:"transactions,omitempty"`
+	Swaps        *uint64 `json:"swaps,omitempty"`
+}
+
+// Publisher is a sink that publishes the decoded events of the indexed pairs,
+// and their per-block aggregates of kind "block", as JSON messages to NATS.
+// The subject of each message is made of the prefix, the chain, the pair
+// address and the kind, so consumers can subscribe per chain and pair.
+//
+// Messages are held until the checkpoint of their range and then published;
+// the checkpoint only succeeds once the server received them, or, with
+// JetStream, once the stream stored them. As processing resumes after the
+// last checkpoint, every message is delivered at least once; JetStream drops
+// the duplicates by their message ID within its deduplication window.
+//
+// When blocks are removed by a chain reorganization, a message of kind
+// "revert" is published for each pair, whose height is the first height that
+// consumers have to discard; the messages of the new branch follow it. The
+// message IDs of events, and of the aggregates of blocks with events, include
+// the block hash, so they are not dropped as duplicates of the removed ones.
+type Publisher struct {
+	conn    *nats.Conn
+	stream  nats.JetStreamContext
+	prefix  string
+	timeout time.Duration
+	pairABI abi.ABI
+
+	chainName string
+	addresses map[common.Address]*Pool
+	hashes    map[pairHeight]common.Hash
+	pending   []*nats.Msg
+}
+
+// pairHeight identifies the events of a pair within one block.
+type pairHeight struct {
+	pair   common.Address
+	height uint64
+}
+
+func NewPublisher(url string, prefix string, jetStream bool) (*Publisher, error) {
+
+	pairABI, err := abi.JSON(strings.NewReader(PairMetaData.ABI))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Uniswap Pair ABI: %w", err)
+	}
+
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to NATS server: %w", err)
+	}
+
+	p := Publisher{
+		conn:      conn,
+		prefix:    prefix,
+		timeout:   publishTimeout,
+		pairABI:   pairABI,
+		addresses: make(map[common.Address]*Pool),
+		hashes:    make(map[pairHeight]common.Hash),
+	}
+
+	if jetStream {
+		p.stream, err = conn.JetStream()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not initialize JetStream context: %w", err)
+		}
+	}
+
+	return &p, nil
+}
+
+// Track sets the chain and pools whose events are published.
+func (p *Publisher) Track(chainName string, pools []*Pool) error {
+	p.chainName = chainName
+	for _, pool := range pools {
+		p.addresses[pool.Address] = pool
+	}
+	return nil
+}
+
+// Record queues a message for each event of the indexed pairs.
+func (p *Publisher) Record(from uint64, to uint64, pools []*Pool, blocks map[uint64]time.Time, entries []types.Log) error {
+
+	for _, entry := range entries {
+
+		pool, ok := p.addresses[entry.Address]
+		if !ok {
+			continue
+		}
+
+		p.hashes[pairHeight{pair: pool.Address, height: entry.BlockNumber}] = entry.BlockHash
+
+		kind, amounts, err := decodeEvent(p.pairABI, entry)
+		if err != nil {
+			return err
+		}
+		if kind == "" {
+			continue
+		}
+
+		index := entry.Index
+		message := Message{
+			Kind:      kind,
+			Pair:      pool.Address.Hex(),
+			PairName:  pool.Name,
+			Height:    entry.BlockNumber,
+			Timestamp: blocks[entry.BlockNumber],
+			LogIndex:  &index,
+			TxHash:    entry.TxHash.Hex(),
+			Amounts:   make(map[string]string, len(amounts)),
+		}
+		for name, amount := range amounts {
+			message.Amounts[name] = amount.String()
+		}
+
+		err = p.queue(message, fmt.Sprintf("%d-%d-%s", entry.BlockNumber, entry.Index, entry.BlockHash.Hex()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write queues a block aggregate message for each datapoint of the indexed
+// pairs and skips all other datapoints.
+func (p *Publisher) Write(points []*write.Point) error {
+
+	for _, point := range points {
+
+		if point.Name() != measurement {
+			continue
+		}
+		var pool *Pool
+		var block string
+		for _, tag := range point.TagList() {
+			switch tag.Key {
+			case "pair_address":
+				pool = p.addresses[common.HexToAddress(tag.Value)]
+			case "block":
+				block = tag.Value
+			}
+		}
+		if pool == nil {
+			continue
+		}
+
+		fields := make(map[string]interface{})
+		for _, field := range point.FieldList() {
+			fields[field.Key] = field.Value
+		}
+		names := []string{"reserve0", "reserve1", "volume0", "volume1", "fee0", "fee1"}
+		decoded, err := decodeFields(fields, names...)
+		if err != nil {
+			return err
+		}
+
+		height, _ := fields["height"].(uint64)
+		transactions, _ := fields["transactions"].(uint64)
+		swaps, _ := fields["swaps"].(uint64)
+		message := Message{
+			Kind:         "block",
+			Pair:         pool.Address.Hex(),
+			PairName:     pool.Name,
+			Height:       height,
+			Timestamp:    point.Time().Truncate(time.Second),
+			Amounts:      make(map[string]string, len(names)),
+			Transactions: &transactions,
+			Swaps:        &swaps,
+		}
+		for i, name := range names {
+			message.Amounts[name] = decoded[i]
+		}
+
+		// Blocks can share a timestamp, so the key of the datapoint includes
+		// its block; datapoints carried forward in dense mode belong to no
+		// block and are identified by their interval boundary alone.
+		id := fmt.Sprintf("%d", point.Time().UnixNano())
+		if block != "" {
+			id = block + "-" + id
+		}
+		hash, ok := p.hashes[pairHeight{pair: pool.Address, height: height}]
+		if ok {
+			id += "-" + hash.Hex()
+		}
+		err = p.queue(message, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush publishes the queued messages and waits until they were received.
+func (p *Publisher) Flush() error {
+
+	if len(p.pending) == 0 {
+		return nil
+	}
+
+	if p.stream == nil {
+		for _, msg := range p.pending {
+			err := p.conn.PublishMsg(msg)
+			if err != nil {
+				return fmt.Errorf("could not publish message (%s): %w", msg.Subject, err)
+			}
+		}
+		err := p.conn.FlushTimeout(p.timeout)
+		if err != nil {
+			return fmt.Errorf("could not flush messages: %w", err)
+		}
+		p.pending = nil
+		return nil
+	}
+
+	acks := make([]nats.PubAckFuture, 0, len(p.pending))
+	for _, msg := range p.pending {
+		ack, err := p.stream.PublishMsgAsync(msg)
+		if err != nil {
+			return fmt.Errorf("could not publish message (%s): %w", msg.Subject, err)
+		}
+		acks = append(acks, ack)
+	}
+
+	select {
+	case <-p.stream.PublishAsyncComplete():
+	case <-time.After(p.timeout):
+		return fmt.Errorf("timed out waiting for message acknowledgements")
+	}
+	for _, ack := range acks {
+		select {
+		case err := <-ack.Err():
+			return fmt.Errorf("could not store message (%s): %w", ack.Msg().Subject, err)
+		default:
+		}
+	}
+
+	p.pending = nil
+
+	return nil
+}
+
+// Checkpoint publishes the messages of the range.
+func (p *Publisher) Checkpoint(height uint64) error {
+	err := p.Flush()
+	if err != nil {
+		return err
+	}
+	p.hashes = make(map[pairHeight]common.Hash)
+	return nil
+}
+
+// Revert discards the messages that were not published yet and publishes a
+// revert message for each pair.
+func (p *Publisher) Revert(height uint64, since time.Time) error {
+
+	p.pending = nil
+	p.hashes = make(map[pairHeight]common.Hash)
+
+	// Each revert is published, even for the same height, so its ID is based
+	// on the time it happened rather than on the chain.
+	id := fmt.Sprintf("%d-%d", height, time.Now().UnixNano())
+	for _, pool := range p.addresses {
+		message := Message{
+			Kind:      "revert",
+			Pair:      pool.Address.Hex(),
+			PairName:  pool.Name,
+			Height:    height,
+			Timestamp: since,
+			Amounts:   make(map[string]string),
+		}
+		err := p.queue(message, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.Flush()
+}
+
+func (p *Publisher) Close() error {
+	err := p.Flush()
+	if err != nil {
+		return err
+	}
+	return p.conn.Drain()
+}
+
+// queue encodes the message and queues it under its subject, with the given
+// identifier completing its message ID.
+func (p *Publisher) queue(message Message, id string) error {
+
+	message.Version = messageVersion
+	message.Chain = p.chainName
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("could not encode message: %w", err)
+	}
+
+	msg := nats.NewMsg(strings.Join([]string{p.prefix, subjectToken(p.chainName), message.Pair, message.Kind}, "."))
+	msg.Data = data
+	msg.Header.Set(nats.MsgIdHdr, strings.Join([]string{p.chainName, message.Pair, message.Kind, id}, "/"))
+
+	p.pending = append(p.pending, msg)
+
+	return nil
+}
+
+// subjectToken replaces the characters that have a meaning in NATS subjects.
+func subjectToken(name string) string {
+	return strings.NewReplacer(" ", "_", ".", "_", "*", "_", ">", "_").Replace(name)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// runJetStream starts an embedded NATS server with JetStream and a stream that
+// keeps all messages published under the prefix.
+func runJetStream(t *testing.T, prefix string) (*server.Server, nats.JetStreamContext) {
+	t.Helper()
+
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	srv := natstest.RunServer(&opts)
+	t.Cleanup(srv.Shutdown)
+
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	t.Cleanup(conn.Close)
+	stream, err := conn.JetStream()
+	if err != nil {
+		t.Fatalf("could not initialize JetStream context: %v", err)
+	}
+	_, err = stream.AddStream(&nats.StreamConfig{
+		Name:       "EVENTS",
+		Subjects:   []string{prefix + ".>"},
+		Duplicates: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("could not add stream: %v", err)
+	}
+
+	return srv, stream
+}
+
+func syncEntry(pair common.Address, height uint64, index uint, hash common.Hash, reserve0 int64, reserve1 int64) types.Log {
+	data := append(common.LeftPadBytes(big.NewInt(reserve0).Bytes(), 32), common.LeftPadBytes(big.NewInt(reserve1).Bytes(), 32)...)
+	return types.Log{
+		Address:     pair,
+		Topics:      []common.Hash{SigSync},
+		Data:        data,
+		BlockNumber: height,
+		BlockHash:   hash,
+		Index:       index,
+	}
+}
+
+func reservesPoint(chainName string, pool *Pool, height uint64, timestamp time.Time) *write.Point {
+	zero := hex.EncodeToString(big.NewInt(0).Bytes())
+	tags := map[string]string{
+		"chain":        chainName,
+		"pair":         pool.Name,
+		"pair_address": pool.Address.Hex(),
+	}
+	fields := map[string]interface{}{
+		"height":       height,
+		"reserve0":     hex.EncodeToString(big.NewInt(1000).Bytes()),
+		"reserve1":     hex.EncodeToString(big.NewInt(2000).Bytes()),
+		"volume0":      zero,
+		"volume1":      zero,
+		"fee0":         zero,
+		"fee1":         zero,
+		"transactions": uint64(1),
+		"swaps":        uint64(0),
+	}
+	return blockPoint(measurement, tags, fields, height, timestamp, 0)
+}
+
+func TestPublisherCheckpoint(t *testing.T) {
+
+	srv, stream := runJetStream(t, "klangbaach")
+
+	chainName := "Test Chain"
+	pool := &Pool{
+		Address: common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		Name:    "AAA/BBB",
+	}
+	other := &Pool{
+		Address: common.HexToAddress("0x0000000000000000000000000000000000000002"),
+		Name:    "CCC/DDD",
+	}
+	hash := common.HexToHash("0xabcd")
+	timestamp := time.Unix(1600000000, 0).UTC()
+	blocks := map[uint64]time.Time{12: timestamp}
+	entries := []types.Log{
+		syncEntry(pool.Address, 12, 3, hash, 1000, 2000),
+		syncEntry(other.Address, 12, 4, hash, 1000, 2000),
+	}
+
+	publisher, err := NewPublisher(srv.ClientURL(), "klangbaach", true)
+	if err != nil {
+		t.Fatalf("could not create publisher: %v", err)
+	}
+	defer publisher.Close()
+	err = publisher.Track(chainName, []*Pool{pool})
+	if err != nil {
+		t.Fatalf("could not track pools: %v", err)
+	}
+
+	// The range is published twice, as it is after resuming from the previous
+	// checkpoint, so the stream has to drop the duplicates.
+	for i := 0; i < 2; i++ {
+		err = publisher.Record(12, 12, []*Pool{pool, other}, blocks, entries)
+		if err != nil {
+			t.Fatalf("could not record range: %v", err)
+		}
+		err = publisher.Write([]*write.Point{reservesPoint(chainName, pool, 12, timestamp)})
+		if err != nil {
+			t.Fatalf("could not write datapoints: %v", err)
+		}
+		err = publisher.Checkpoint(12)
+		if err != nil {
+			t.Fatalf("could not checkpoint range: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		subject string
+		id      string
+	}{
+		{
+			name:    "event",
+			subject: "klangbaach.Test_Chain." + pool.Address.Hex() + ".sync",
+			id:      fmt.Sprintf("Test Chain/%s/sync/12-3-%s", pool.Address.Hex(), hash.Hex()),
+		},
+		{
+			name:    "block",
+			subject: "klangbaach.Test_Chain." + pool.Address.Hex() + ".block",
+			id:      fmt.Sprintf("Test Chain/%s/block/12-%d-%s", pool.Address.Hex(), timestamp.UnixNano(), hash.Hex()),
+		},
+	}
+
+	sub, err := stream.SubscribeSync("klangbaach.>", nats.DeliverAll())
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := sub.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("could not get message: %v", err)
+			}
+			if msg.Subject != test.subject {
+				t.Errorf("subject = %s, want %s", msg.Subject, test.subject)
+			}
+			if id := msg.Header.Get(nats.MsgIdHdr); id != test.id {
+				t.Errorf("message ID = %s, want %s", id, test.id)
+			}
+		})
+	}
+
+	_, err = sub.NextMsg(100 * time.Millisecond)
+	if err != nats.ErrTimeout {
+		t.Errorf("got unexpected message after the expected ones (err: %v)", err)
+	}
+}
+
+func TestPublisherCheckpointTimeout(t *testing.T) {
+
+	srv, _ := runJetStream(t, "klangbaach")
+
+	pool := &Pool{
+		Address: common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		Name:    "AAA/BBB",
+	}
+	hash := common.HexToHash("0xabcd")
+
+	publisher, err := NewPublisher(srv.ClientURL(), "klangbaach", true)
+	if err != nil {
+		t.Fatalf("could not create publisher: %v", err)
+	}
+	publisher.timeout = 200 * time.Millisecond
+	err = publisher.Track("Test Chain", []*Pool{pool})
+	if err != nil {
+		t.Fatalf("could not track pools: %v", err)
+	}
+
+	// Without the server, the messages are buffered until the connection is
+	// restored, so their acknowledgements never arrive.
+	srv.Shutdown()
+
+	err = publisher.Record(12, 12, []*Pool{pool}, map[uint64]time.Time{12: time.Unix(1600000000, 0)}, []types.Log{syncEntry(pool.Address, 12, 3, hash, 1000, 2000)})
+	if err != nil {
+		t.Fatalf("could not record range: %v", err)
+	}
+	err = publisher.Checkpoint(12)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("checkpoint did not time out without acknowledgements (err: %v)", err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// recomputeCandles rebuilds the candles of a pair from the raw datapoints that
+// were previously written to the sinks, without going through the JSON RPC API
+// for the log entries. The datapoints are read from the SQLite store when it is
+// one of the sinks, and from InfluxDB otherwise.
+func recomputeCandles(args []string) {
+
+	var (
+		logLevel string
+
+		pairAddress string
+		startTime   string
+		endTime     string
+
+		apiURL string
+
+		sinks SinkOptions
+
+		candleIntervals []string
+	)
+
+	flags := pflag.NewFlagSet("candles", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+
+	flags.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL")
+	flags.StringVarP(&pairAddress, "pair-address", "p", "0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc", "Ethereum address for Uniswap v2 pair")
+	flags.StringVar(&startTime, "start-time", "2020-05-05T00:00:00Z", "start time of the datapoints to aggregate (RFC3339)")
+	flags.StringVar(&endTime, "end-time", "", "end time of the datapoints to aggregate (RFC3339, defaults to now)")
+
+	bindSinkFlags(flags, &sinks)
+
+	flags.StringSliceVarP(&candleIntervals, "candle-intervals", "c", []string{"1m", "5m", "1h", "1d"}, "intervals for the OHLCV candles aggregated from the datapoints")
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		log.Fatal().Str("start_time", startTime).Err(err).Msg("invalid start time")
+	}
+	end := time.Now().UTC()
+	if endTime != "" {
+		end, err = time.Parse(time.RFC3339, endTime)
+		if err != nil {
+			log.Fatal().Str("end_time", endTime).Err(err).Msg("invalid end time")
+		}
+	}
+
+	client, chainName := connectChain(log, apiURL)
+
+	pool, err := fetchPool(client, common.HexToAddress(pairAddress))
+	if err != nil {
+		log.Fatal().Str("pair_address", pairAddress).Err(err).Msg("could not get pair metadata")
+	}
+
+	log = log.With().
+		Str("bucket", sinks.InfluxBucket).
+		Str("measurement", candleMeasurement).
+		Str("chain_name", chainName).
+		Str("pair_name", pool.Name).
+		Time("start", start).
+		Time("end", end).
+		Logger()
+
+	candles := make([]*Candles, 0, len(candleIntervals))
+	for _, label := range candleIntervals {
+		aggregator, err := NewCandles(label)
+		if err != nil {
+			log.Fatal().Str("interval", label).Err(err).Msg("invalid candle interval")
+		}
+		candles = append(candles, aggregator)
+	}
+
+	sink, store, err := openSinks(log, sinks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not open sinks")
+	}
+	err = sink.Track(chainName, []*Pool{pool})
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not track pair")
+	}
+	source, err := openSource(sinks, store)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not connect to InfluxDB API")
+	}
+
+	count := 0
+	err = source.Datapoints(chainName, pool, start, end, 0, func(point Datapoint) error {
+		count++
+		// The reserves before the first datapoint are not stored, so the first
+		// candle opens at the price after its block.
+		price := spotPrice(point.Reserve0, point.Reserve1, pool.Token0.Decimals, pool.Token1.Decimals)
+		for _, aggregator := range candles {
+			completed := aggregator.Add(point, price, price)
+			for _, candle := range completed {
+				err := sink.Write([]*write.Point{aggregator.Point(chainName, pool, candle)})
+				if err != nil {
+					return fmt.Errorf("could not write candle: %w", err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not query datapoints")
+	}
+
+	for _, aggregator := range candles {
+		candle, ok := aggregator.Current()
+		if !ok {
+			continue
+		}
+		err = sink.Write([]*write.Point{aggregator.Point(chainName, pool, candle)})
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not write candle")
+		}
+	}
+
+	err = sink.Close()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not write candles")
+	}
+
+	log.Info().Int("datapoints", count).Msg("recomputed candles from datapoints")
+
+	os.Exit(0)
+}
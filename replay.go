@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// replayArchive derives the datapoints of the ranges in the raw log entry
+// archive again and writes them to the configured sinks, without a client. It
+// allows fixing the aggregation of past datapoints without retrieving the log
+// entries from the node again. Each archived range is replayed from the state
+// of the pools archived before it, so the datapoints are identical to the ones
+// the miner wrote while archiving, given the same settings.
+func replayArchive(args []string) {
+
+	var (
+		logLevel string
+
+		pairAddresses []string
+		startHeight   uint64
+		endHeight     uint64
+
+		sinks  SinkOptions
+		config Config
+	)
+
+	flags := pflag.NewFlagSet("replay", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+
+	flags.StringSliceVarP(&pairAddresses, "pair-addresses", "p", []string{"0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc"}, "Ethereum addresses for Uniswap v2 pairs")
+	flags.Uint64VarP(&startHeight, "start-height", "s", 0, "start height of the archived ranges to replay")
+	flags.Uint64VarP(&endHeight, "end-height", "e", ^uint64(0), "end height of the archived ranges to replay")
+
+	bindSinkFlags(flags, &sinks)
+	bindConfigFlags(flags, &config)
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	for _, name := range sinks.Names {
+		if name == "archive" {
+			log.Fatal().Msg("cannot replay into the archive")
+		}
+	}
+
+	archive, err := OpenArchive(sinks.ArchivePath)
+	if err != nil {
+		log.Fatal().Str("archive_path", sinks.ArchivePath).Err(err).Msg("could not open archive")
+	}
+
+	chainName, err := archive.Chain()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not read archived chain")
+	}
+
+	log = log.With().
+		Str("measurement", measurement).
+		Str("chain_name", chainName).
+		Logger()
+
+	var indexed, references []*Pool
+	for _, pairAddress := range pairAddresses {
+		pool, err := archive.Pool(common.HexToAddress(pairAddress))
+		if err != nil {
+			log.Fatal().Str("pair_address", pairAddress).Err(err).Msg("could not get archived pair metadata")
+		}
+		indexed = append(indexed, pool)
+	}
+	for _, pairAddress := range config.ReferencePairs {
+		pool, err := archive.Pool(common.HexToAddress(pairAddress))
+		if err != nil {
+			log.Fatal().Str("pair_address", pairAddress).Err(err).Msg("could not get archived reference pair metadata")
+		}
+		references = append(references, pool)
+	}
+
+	sink, _, err := openSinks(log, sinks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not open sinks")
+	}
+
+	config.WriteMetrics = true
+	miner, err := NewReplayMiner(log, sink, chainName, indexed, references, config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not initialize miner")
+	}
+
+	ranges, err := archive.Ranges(startHeight, endHeight)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not read archived ranges")
+	}
+
+	for _, r := range ranges {
+
+		snapshots, err := archive.Snapshots(r.From)
+		if err != nil {
+			log.Fatal().Uint64("from", r.From).Err(err).Msg("could not read archived pair states")
+		}
+		miner.Restore(r.From-1, snapshots)
+
+		entries, blocks, err := archive.Entries(r.From, r.To)
+		if err != nil {
+			log.Fatal().Uint64("from", r.From).Uint64("to", r.To).Err(err).Msg("could not read archived log entries")
+		}
+
+		err = miner.Replay(r.From, r.To, entries, blocks)
+		if err != nil {
+			log.Fatal().Uint64("from", r.From).Uint64("to", r.To).Err(err).Msg("could not replay block range")
+		}
+	}
+
+	err = miner.Close()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not close miner")
+	}
+
+	err = archive.Close()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not close archive")
+	}
+
+	log.Info().Int("ranges", len(ranges)).Msg("replayed archived block ranges")
+
+	os.Exit(0)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// routerABI describes the swap functions of the Uniswap v2 router.
+const routerABI = `[{"inputs":[{"internalType":"uint256","name":"amountIn","type":"uint256"},{"internalType":"uint256","name":"amountOutMin","type":"uint256"},{"internalType":"address[]","name":"path","type":"address[]"},{"internalType":"address","name":"to","type":"address"},{"internalType":"uint256","name":"deadline","type":"uint256"}],"name":"swapExactTokensForTokens","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"uint256","name":"amountOut","type":"uint256"},{"internalType":"uint256","name":"amountInMax","type":"uint256"},{"internalType":"address[]","name":"path","type":"address[]"},{"internalType":"address","name":"to","type":"address"},{"internalType":"uint256","name":"deadline","type":"uint256"}],"name":"swapTokensForExactTokens","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"uint256","name":"amountOutMin","type":"uint256"},{"internalType":"address[]","name":"path","type":"address[]"},{"internalType":"address","name":"to","type":"address"},{"internalType":"uint256","name":"deadline","type":"uint256"}],"name":"swapExactETHForTokens","outputs":[],"stateMutability":"payable","type":"function"},{"inputs":[{"internalType":"uint256","name":"amountOut","type":"uint256"},{"internalType":"uint256","name":"amountInMax","type":"uint256"},{"internalType":"address[]","name":"path","type":"address[]"},{"internalType":"address","name":"to","type":"address"},{"internalType":"uint256","name":"deadline","type":"uint256"}],"name":"swapTokensForExactETH","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"uint256","name":"amountIn","type":"uint256"},{"internalType":"uint256","name":"amountOutMin","type":"uint256"},{"internalType":"address[]","name":"path","type":"address[]"},{"internalType":"address","name":"to","type":"address"},{"internalType":"uint256","name":"deadline","type":"uint256"}],"name":"swapExactTokensForETH","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"uint256","name":"amountOut","type":"uint256"},{"internalType":"address[]","name":"path","type":"address[]"},{"internalType":"address","name":"to","type":"address"},{"internalType":"uint256","name":"deadline","type":"uint256"}],"name":"swapETHForExactTokens","outputs":[],"stateMutability":"payable","type":"function"},{"inputs":[{"internalType":"uint256","name":"amountIn","type":"uint256"},{"internalType":"uint256","name":"amountOutMin","type":"uint256"},{"internalType":"address[]","name":"path","type":"address[]"},{"internalType":"address","name":"to","type":"address"},{"internalType":"uint256","name":"deadline","type":"uint256"}],"name":"swapExactTokensForTokensSupportingFeeOnTransferTokens","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"uint256","name":"amountOutMin","type":"uint256"},{"internalType":"address[]","name":"path","type":"address[]"},{"internalType":"address","name":"to","type":"address"},{"internalType":"uint256","name":"deadline","type":"uint256"}],"name":"swapExactETHForTokensSupportingFeeOnTransferTokens","outputs":[],"stateMutability":"payable","type":"function"},{"inputs":[{"internalType":"uint256","name":"amountIn","type":"uint256"},{"internalType":"uint256","name":"amountOutMin","type":"uint256"},{"internalType":"address[]","name":"path","type":"address[]"},{"internalType":"address","name":"to","type":"address"},{"internalType":"uint256","name":"deadline","type":"uint256"}],"name":"swapExactTokensForETHSupportingFeeOnTransferTokens","outputs":[],"stateMutability":"nonpayable","type":"function"}]`
+
+// RouterCall is a swap through the router along a path of tokens, with either
+// an exact input or an exact output amount.
+type RouterCall struct {
+	Path      []common.Address
+	AmountIn  *big.Int
+	AmountOut *big.Int
+}
+
+// decodeRouterCall decodes the calldata of a transaction calling one of the
+// swap functions of the router. Swaps with an exact amount of ether as input
+// take their input amount from the value of the transaction.
+func decodeRouterCall(router abi.ABI, tx *types.Transaction) (RouterCall, bool) {
+
+	data := tx.Data()
+	if len(data) < 4 {
+		return RouterCall{}, false
+	}
+	method, err := router.MethodById(data[:4])
+	if err != nil {
+		return RouterCall{}, false
+	}
+	values := make(map[string]interface{})
+	err = method.Inputs.UnpackIntoMap(values, data[4:])
+	if err != nil {
+		return RouterCall{}, false
+	}
+
+	path, _ := values["path"].([]common.Address)
+	call := RouterCall{
+		Path: path,
+	}
+	switch {
+	case strings.HasPrefix(method.Name, "swapExactETH"):
+		call.AmountIn = tx.Value()
+	case strings.HasPrefix(method.Name, "swapExact"):
+		call.AmountIn, _ = values["amountIn"].(*big.Int)
+	default:
+		call.AmountOut, _ = values["amountOut"].(*big.Int)
+	}
+	if len(call.Path) < 2 || (call.AmountIn == nil && call.AmountOut == nil) {
+		return RouterCall{}, false
+	}
+
+	return call, true
+}
+
+// decodePairCall decodes the calldata of a transaction calling the swap
+// function of a pair directly, which only specifies the output amounts. It
+// returns the direction and output amount of the swap, and fails for flash
+// swaps that take out both tokens.
+func decodePairCall(pair abi.ABI, tx *types.Transaction) (bool, *big.Int, bool) {
+
+	data := tx.Data()
+	if len(data) < 4 {
+		return false, nil, false
+	}
+	method, err := pair.MethodById(data[:4])
+	if err != nil || method.Name != "swap" {
+		return false, nil, false
+	}
+	values := make(map[string]interface{})
+	err = method.Inputs.UnpackIntoMap(values, data[4:])
+	if err != nil {
+		return false, nil, false
+	}
+
+	amount0Out, _ := values["amount0Out"].(*big.Int)
+	amount1Out, _ := values["amount1Out"].(*big.Int)
+	if amount0Out == nil || amount1Out == nil {
+		return false, nil, false
+	}
+	switch {
+	case amount0Out.Sign() == 0 && amount1Out.Sign() > 0:
+		return true, amount1Out, true
+	case amount1Out.Sign() == 0 && amount0Out.Sign() > 0:
+		return false, amount0Out, true
+	default:
+		return false, nil, false
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Sink is an output for datapoints. Writes can be buffered until the sink is
+// flushed; a checkpoint signals that all datapoints up to and including the
+// given height were handed to the sink, so that a sink can make them durable
+// and record its progress.
+type Sink interface {
+	Write(points []*write.Point) error
+	Flush() error
+	Checkpoint(height uint64) error
+	Close() error
+}
+
+// Tracker is implemented by sinks that need the metadata of the indexed pools,
+// which the miner hands to them when it is initialized.
+type Tracker interface {
+	Track(chainName string, pools []*Pool) error
+}
+
+// Recorder is implemented by sinks that store the blocks and log entries of
+// the processed ranges, besides the datapoints derived from them. The pools
+// are all tracked pools, in their state before the range; the blocks map the
+// heights that were processed to their timestamps; the entries are all log
+// entries of the given range, inclusively, so they replace the stored ones.
+type Recorder interface {
+	Record(from uint64, to uint64, pools []*Pool, blocks map[uint64]time.Time, entries []types.Log) error
+}
+
+// Reverter is implemented by sinks that can drop what they stored for blocks
+// that were removed by a chain reorganization. The height is the first removed
+// height; no datapoint derived from the removed blocks is older than the given
+// time, while older datapoints, like the candles of an interval that started
+// before it, are replaced once the blocks of the new branch are written.
+type Reverter interface {
+	Revert(height uint64, since time.Time) error
+}
+
+// InfluxSink writes the datapoints to an InfluxDB bucket in batches. The
+// write API reports its errors asynchronously, so they are returned by the
+// next call on the sink.
+type InfluxSink struct {
+	client influxdb2.Client
+	batch  api.WriteAPI
+	org    string
+	bucket string
+
+	chainName string
+	pairs     map[string]struct{}
+
+	mutex  sync.Mutex
+	failed error
+}
+
+func NewInfluxSink(client influxdb2.Client, org string, bucket string) *InfluxSink {
+
+	s := InfluxSink{
+		client: client,
+		batch:  client.WriteAPI(org, bucket),
+		org:    org,
+		bucket: bucket,
+		pairs:  make(map[string]struct{}),
+	}
+
+	go func() {
+		for err := range s.batch.Errors() {
+			s.mutex.Lock()
+			if s.failed == nil {
+				s.failed = err
+			}
+			s.mutex.Unlock()
+		}
+	}()
+
+	return &s
+}
+
+func (s *InfluxSink) Write(points []*write.Point) error {
+	for _, point := range points {
+		for _, tag := range point.TagList() {
+			if tag.Key == "pair_address" {
+				s.pairs[tag.Value] = struct{}{}
+			}
+		}
+		s.batch.WritePoint(point)
+	}
+	return s.failure()
+}
+
+func (s *InfluxSink) Flush() error {
+	s.batch.Flush()
+	return s.failure()
+}
+
+// Checkpoint flushes the datapoints, as the write API otherwise only writes
+// them once its batch is full.
+func (s *InfluxSink) Checkpoint(height uint64) error {
+	return s.Flush()
+}
+
+// Track sets the chain and pairs whose datapoints are removed when reverting.
+func (s *InfluxSink) Track(chainName string, pools []*Pool) error {
+	s.chainName = chainName
+	for _, pool := range pools {
+		s.pairs[pool.Address.Hex()] = struct{}{}
+	}
+	return nil
+}
+
+// Revert deletes the datapoints of the pairs from the given time on, for all
+// measurements written by the miner. The pairs are the tracked ones and those
+// of the written datapoints, like the reference pairs of arbitrages, so that
+// the datapoints of other miners writing to the same bucket are kept.
+func (s *InfluxSink) Revert(height uint64, since time.Time) error {
+
+	err := s.Flush()
+	if err != nil {
+		return err
+	}
+
+	// The predicates of the delete API cannot combine conditions with "or", so
+	// each measurement of each pair is deleted separately.
+	measurements := []string{measurement, candleMeasurement, anomalyMeasurement, arbitrageMeasurement, mevMeasurement}
+	for _, name := range measurements {
+		for pair := range s.pairs {
+			predicate := fmt.Sprintf(`_measurement=%q AND chain=%q AND pair_address=%q`, name, s.chainName, pair)
+			err = s.client.DeleteAPI().DeleteWithName(context.Background(), s.org, s.bucket, since, time.Now().Add(time.Hour), predicate)
+			if err != nil {
+				return fmt.Errorf("could not delete datapoints (%s, %s): %w", name, pair, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *InfluxSink) Close() error {
+	s.batch.Flush()
+	s.client.Close()
+	return s.failure()
+}
+
+// failure returns the first error reported since the last call, if any.
+func (s *InfluxSink) failure() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := s.failed
+	s.failed = nil
+	return err
+}
+
+// Fanout writes the datapoints to several sinks at once. Errors of required
+// sinks are returned, which stops the miner, while errors of best-effort
+// sinks are only logged.
+type Fanout struct {
+	log   zerolog.Logger
+	sinks []fanoutSink
+}
+
+type fanoutSink struct {
+	name       string
+	sink       Sink
+	bestEffort bool
+}
+
+func NewFanout(log zerolog.Logger) *Fanout {
+
+	f := Fanout{
+		log: log,
+	}
+
+	return &f
+}
+
+// Add includes a sink under the given name, which identifies it in errors.
+func (f *Fanout) Add(name string, sink Sink, bestEffort bool) {
+	f.sinks = append(f.sinks, fanoutSink{name: name, sink: sink, bestEffort: bestEffort})
+}
+
+func (f *Fanout) Write(points []*write.Point) error {
+	return f.each("write", func(sink Sink) error {
+		return sink.Write(points)
+	})
+}
+
+func (f *Fanout) Flush() error {
+	return f.each("flush", func(sink Sink) error {
+		return sink.Flush()
+	})
+}
+
+func (f *Fanout) Checkpoint(height uint64) error {
+	return f.each("checkpoint", func(sink Sink) error {
+		return sink.Checkpoint(height)
+	})
+}
+
+// Track hands the metadata of the pools to the sinks that keep it.
+func (f *Fanout) Track(chainName string, pools []*Pool) error {
+	return f.each("track", func(sink Sink) error {
+		tracker, ok := sink.(Tracker)
+		if !ok {
+			return nil
+		}
+		return tracker.Track(chainName, pools)
+	})
+}
+
+// Record hands the blocks and log entries to the sinks that store them.
+func (f *Fanout) Record(from uint64, to uint64, pools []*Pool, blocks map[uint64]time.Time, entries []types.Log) error {
+	return f.each("record", func(sink Sink) error {
+		recorder, ok := sink.(Recorder)
+		if !ok {
+			return nil
+		}
+		return recorder.Record(from, to, pools, blocks, entries)
+	})
+}
+
+// Revert drops what the sinks stored for blocks removed by a reorganization.
+func (f *Fanout) Revert(height uint64, since time.Time) error {
+	return f.each("revert", func(sink Sink) error {
+		reverter, ok := sink.(Reverter)
+		if !ok {
+			return nil
+		}
+		return reverter.Revert(height, since)
+	})
+}
+
+func (f *Fanout) Close() error {
+	return f.each("close", func(sink Sink) error {
+		return sink.Close()
+	})
+}
+
+// each applies the operation to all sinks, even when some of them fail, and
+// returns the first error of a required sink.
+func (f *Fanout) each(operation string, apply func(Sink) error) error {
+
+	var failed error
+	for _, s := range f.sinks {
+		start := time.Now()
+		err := apply(s.sink)
+		sinkDuration.WithLabelValues(s.name, operation).Observe(time.Since(start).Seconds())
+		if err == nil {
+			continue
+		}
+		sinkErrors.WithLabelValues(s.name, operation).Inc()
+		if s.bestEffort {
+			f.log.Warn().Str("sink", s.name).Str("operation", operation).Err(err).Msg("best-effort sink failed")
+			continue
+		}
+		if failed == nil {
+			failed = fmt.Errorf("could not %s datapoints (sink: %s): %w", operation, s.name, err)
+		}
+	}
+
+	return failed
+}
+
+// blockPoint creates a datapoint derived from the block at the given height,
+// keyed by the height and the timestamp of the block. InfluxDB identifies
+// points by their series and their timestamp, so writing the datapoints of a
+// range again replaces them instead of duplicating them; the height is part of
+// the series, so that the datapoints of blocks sharing a timestamp are all
+// kept. Datapoints of single events are offset by their log index in
+// nanoseconds, so they do not collide with each other or with the block.
+// Datapoints written before the height was part of the series are neither
+// found nor replaced under their new key.
+func blockPoint(name string, tags map[string]string, fields map[string]interface{}, height uint64, timestamp time.Time, index uint) *write.Point {
+	tags["block"] = strconv.FormatUint(height, 10)
+	return write.NewPoint(name, tags, fields, timestamp.Add(time.Duration(index)))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBlockPoint(t *testing.T) {
+
+	block := time.Unix(1600000000, 0).UTC()
+
+	tests := []struct {
+		name      string
+		height    uint64
+		timestamp time.Time
+		index     uint
+		want      time.Time
+	}{
+		{name: "block", height: 100, timestamp: block, index: 0, want: block},
+		{name: "first event", height: 100, timestamp: block, index: 1, want: block.Add(time.Nanosecond)},
+		{name: "later event", height: 100, timestamp: block, index: 250, want: block.Add(250 * time.Nanosecond)},
+		{name: "next block", height: 101, timestamp: block.Add(12 * time.Second), index: 0, want: block.Add(12 * time.Second)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := blockPoint(measurement, map[string]string{"chain": "Test Chain"}, map[string]interface{}{"height": test.height}, test.height, test.timestamp, test.index)
+			if !got.Time().Equal(test.want) {
+				t.Errorf("point time = %s, want %s", got.Time().Format(time.RFC3339Nano), test.want.Format(time.RFC3339Nano))
+			}
+		})
+	}
+
+	// Writing the same range again has to produce the same keys, so that the
+	// points are replaced, and the datapoints of blocks sharing a timestamp,
+	// as well as the events of a block, must not collide with each other.
+	type key struct {
+		series string
+		time   int64
+	}
+	seen := make(map[key]string)
+	for _, height := range []uint64{100, 101} {
+		for index := uint(0); index < 1000; index++ {
+			point := blockPoint(measurement, map[string]string{"chain": "Test Chain"}, map[string]interface{}{"height": height}, height, block, index)
+			again := blockPoint(measurement, map[string]string{"chain": "Test Chain"}, map[string]interface{}{"height": height}, height, block, index)
+			got := key{series: seriesKey(point), time: point.Time().UnixNano()}
+			if got != (key{series: seriesKey(again), time: again.Time().UnixNano()}) {
+				t.Fatalf("key of height %d and index %d is not deterministic", height, index)
+			}
+			current := fmt.Sprintf("height %d and index %d", height, index)
+			previous, ok := seen[got]
+			if ok {
+				t.Fatalf("key of %s collides with %s", current, previous)
+			}
+			seen[got] = current
+		}
+	}
+}
@@ -0,0 +1,1000 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// storeSchema creates the tables of the store. Amounts are stored as decimal
+// strings, so they keep their full precision; times are Unix timestamps, in
+// seconds for blocks and in nanoseconds for the keys of the datapoints.
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS tokens (
+	chain TEXT NOT NULL,
+	address TEXT NOT NULL,
+	symbol TEXT NOT NULL,
+	decimals INTEGER NOT NULL,
+	PRIMARY KEY (chain, address)
+);
+CREATE TABLE IF NOT EXISTS pairs (
+	chain TEXT NOT NULL,
+	address TEXT NOT NULL,
+	name TEXT NOT NULL,
+	token0 TEXT NOT NULL,
+	token1 TEXT NOT NULL,
+	PRIMARY KEY (chain, address)
+);
+CREATE TABLE IF NOT EXISTS blocks (
+	chain TEXT NOT NULL,
+	height INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	PRIMARY KEY (chain, height)
+);
+CREATE TABLE IF NOT EXISTS events (
+	chain TEXT NOT NULL,
+	pair TEXT NOT NULL,
+	height INTEGER NOT NULL,
+	log_index INTEGER NOT NULL,
+	tx_hash TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	amount0_in TEXT,
+	amount1_in TEXT,
+	amount0_out TEXT,
+	amount1_out TEXT,
+	reserve0 TEXT,
+	reserve1 TEXT,
+	amount0 TEXT,
+	amount1 TEXT,
+	PRIMARY KEY (chain, height, log_index)
+);
+CREATE INDEX IF NOT EXISTS events_pair ON events (chain, pair, height);
+CREATE TABLE IF NOT EXISTS datapoints (
+	chain TEXT NOT NULL,
+	pair TEXT NOT NULL,
+	time INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	reserve0 TEXT NOT NULL,
+	reserve1 TEXT NOT NULL,
+	volume0 TEXT NOT NULL,
+	volume1 TEXT NOT NULL,
+	fee0 TEXT NOT NULL,
+	fee1 TEXT NOT NULL,
+	transactions INTEGER NOT NULL,
+	swaps INTEGER NOT NULL,
+	PRIMARY KEY (chain, pair, height, time)
+);
+CREATE TABLE IF NOT EXISTS candles (
+	chain TEXT NOT NULL,
+	pair TEXT NOT NULL,
+	interval TEXT NOT NULL,
+	start INTEGER NOT NULL,
+	open REAL NOT NULL,
+	high REAL NOT NULL,
+	low REAL NOT NULL,
+	close REAL NOT NULL,
+	volume0 TEXT NOT NULL,
+	volume1 TEXT NOT NULL,
+	fee0 TEXT NOT NULL,
+	fee1 TEXT NOT NULL,
+	PRIMARY KEY (chain, pair, interval, start)
+);
+CREATE TABLE IF NOT EXISTS points (
+	measurement TEXT NOT NULL,
+	series TEXT NOT NULL,
+	time INTEGER NOT NULL,
+	fields TEXT NOT NULL,
+	PRIMARY KEY (measurement, series, time)
+);
+CREATE TABLE IF NOT EXISTS checkpoints (
+	chain TEXT NOT NULL PRIMARY KEY,
+	height INTEGER NOT NULL
+);
+`
+
+// eventColumns are the amount columns of the events table, which are set for
+// the kinds of events that have them.
+var eventColumns = []string{
+	"amount0_in", "amount1_in", "amount0_out", "amount1_out",
+	"reserve0", "reserve1",
+	"amount0", "amount1",
+}
+
+// Store keeps the blocks, pairs, tokens, events and datapoints of a chain in a
+// single SQLite database file. As a sink, it writes everything of a processed
+// range in one transaction, which is committed with the checkpoint, so the
+// stored checkpoint is always consistent with the stored data. It also serves
+// the stored datapoints back to the other commands.
+type Store struct {
+	db      *sql.DB
+	reader  *sql.DB
+	tx      *sql.Tx
+	pairABI abi.ABI
+
+	chainName string
+	addresses map[common.Address]*Pool
+}
+
+func OpenStore(path string) (*Store, error) {
+
+	pairABI, err := abi.JSON(strings.NewReader(PairMetaData.ABI))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Uniswap Pair ABI: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %w", err)
+	}
+
+	// SQLite allows a single writer, so all writes share one connection, which
+	// holds the transaction of a range until its checkpoint.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(storeSchema)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not create schema: %w", err)
+	}
+
+	err = migrateStore(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not migrate schema: %w", err)
+	}
+
+	// The queries use their own read-only connections, which see the last
+	// committed ranges while the next one is written, as the database is in
+	// WAL mode.
+	reader, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000", path))
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not open database for reading: %w", err)
+	}
+
+	s := Store{
+		db:        db,
+		reader:    reader,
+		pairABI:   pairABI,
+		addresses: make(map[common.Address]*Pool),
+	}
+
+	return &s, nil
+}
+
+// migrateStore updates the tables of databases created with an earlier schema.
+// The datapoints used to be keyed by time alone, which kept only one of the
+// blocks sharing a timestamp; the table is rebuilt with the height in its key.
+func migrateStore(db *sql.DB) error {
+
+	var definition string
+	err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'datapoints'`).Scan(&definition)
+	if err != nil {
+		return fmt.Errorf("could not read table definition: %w", err)
+	}
+	if !strings.Contains(definition, "PRIMARY KEY (chain, pair, time)") {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Only the key changed, so the rows are copied over as they are.
+	statements := []string{
+		`ALTER TABLE datapoints RENAME TO datapoints_unkeyed`,
+		storeSchema,
+		`INSERT INTO datapoints SELECT * FROM datapoints_unkeyed`,
+		`DROP TABLE datapoints_unkeyed`,
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return fmt.Errorf("could not rebuild datapoints table: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Track stores the metadata of the given pools and their tokens, and sets the
+// chain and pools that the datapoints written to the store belong to.
+func (s *Store) Track(chainName string, pools []*Pool) error {
+
+	s.chainName = chainName
+
+	for _, pool := range pools {
+
+		for _, token := range []Token{pool.Token0, pool.Token1} {
+			_, err := s.db.Exec(`INSERT OR REPLACE INTO tokens (chain, address, symbol, decimals) VALUES (?, ?, ?, ?)`,
+				chainName, token.Address.Hex(), token.Symbol, token.Decimals,
+			)
+			if err != nil {
+				return fmt.Errorf("could not store token (%s): %w", token.Symbol, err)
+			}
+		}
+
+		_, err := s.db.Exec(`INSERT OR REPLACE INTO pairs (chain, address, name, token0, token1) VALUES (?, ?, ?, ?, ?)`,
+			chainName, pool.Address.Hex(), pool.Name, pool.Token0.Address.Hex(), pool.Token1.Address.Hex(),
+		)
+		if err != nil {
+			return fmt.Errorf("could not store pair (%s): %w", pool.Name, err)
+		}
+
+		s.addresses[pool.Address] = pool
+	}
+
+	return nil
+}
+
+// Record stores the timestamps of the processed blocks and the events of the
+// tracked pairs, replacing the events previously stored for the range.
+func (s *Store) Record(from uint64, to uint64, pools []*Pool, blocks map[uint64]time.Time, entries []types.Log) error {
+
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+
+	for height, timestamp := range blocks {
+		_, err = tx.Exec(`INSERT OR REPLACE INTO blocks (chain, height, timestamp) VALUES (?, ?, ?)`,
+			s.chainName, height, timestamp.Unix(),
+		)
+		if err != nil {
+			return fmt.Errorf("could not store block (%d): %w", height, err)
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM events WHERE chain = ? AND height BETWEEN ? AND ?`, s.chainName, from, to)
+	if err != nil {
+		return fmt.Errorf("could not delete events: %w", err)
+	}
+
+	for _, entry := range entries {
+
+		pool, ok := s.addresses[entry.Address]
+		if !ok {
+			continue
+		}
+
+		kind, amounts, err := decodeEvent(s.pairABI, entry)
+		if err != nil {
+			return err
+		}
+		if kind == "" {
+			continue
+		}
+
+		args := []interface{}{s.chainName, pool.Address.Hex(), entry.BlockNumber, entry.Index, entry.TxHash.Hex(), kind}
+		for _, column := range eventColumns {
+			var value interface{}
+			amount, ok := amounts[column]
+			if ok {
+				value = amount.String()
+			}
+			args = append(args, value)
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO events (chain, pair, height, log_index, tx_hash, kind,
+			amount0_in, amount1_in, amount0_out, amount1_out, reserve0, reserve1, amount0, amount1)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, args...)
+		if err != nil {
+			return fmt.Errorf("could not store event (%d, %d): %w", entry.BlockNumber, entry.Index, err)
+		}
+	}
+
+	return nil
+}
+
+// Write stores the datapoints and candles of the tracked pairs in their own
+// tables, and all other datapoints by their series.
+func (s *Store) Write(points []*write.Point) error {
+
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+
+	for _, point := range points {
+
+		var pool *Pool
+		var interval string
+		var block string
+		for _, tag := range point.TagList() {
+			switch tag.Key {
+			case "pair_address":
+				pool = s.addresses[common.HexToAddress(tag.Value)]
+			case "interval":
+				interval = tag.Value
+			case "block":
+				block = tag.Value
+			}
+		}
+
+		fields := make(map[string]interface{})
+		for _, field := range point.FieldList() {
+			fields[field.Key] = field.Value
+		}
+
+		switch {
+
+		case point.Name() == measurement && pool != nil:
+			amounts, err := decodeFields(fields, "reserve0", "reserve1", "volume0", "volume1", "fee0", "fee1")
+			if err != nil {
+				return err
+			}
+			// Datapoints carried forward to an interval boundary belong to
+			// no block, so they are stored with a height of zero, which keeps
+			// them apart from the datapoint of a block on the boundary.
+			var height uint64
+			if block != "" {
+				height, err = strconv.ParseUint(block, 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid block tag (%s): %w", block, err)
+				}
+			}
+			transactions, _ := fields["transactions"].(uint64)
+			swaps, _ := fields["swaps"].(uint64)
+			_, err = tx.Exec(`INSERT OR REPLACE INTO datapoints (chain, pair, time, height, timestamp,
+				reserve0, reserve1, volume0, volume1, fee0, fee1, transactions, swaps)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				s.chainName, pool.Address.Hex(), point.Time().UnixNano(), height, point.Time().Unix(),
+				amounts[0], amounts[1], amounts[2], amounts[3], amounts[4], amounts[5], transactions, swaps,
+			)
+			if err != nil {
+				return fmt.Errorf("could not store datapoint (%s, %d): %w", pool.Name, height, err)
+			}
+
+		case point.Name() == candleMeasurement && pool != nil:
+			amounts, err := decodeFields(fields, "volume0", "volume1", "fee0", "fee1")
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT OR REPLACE INTO candles (chain, pair, interval, start,
+				open, high, low, close, volume0, volume1, fee0, fee1)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				s.chainName, pool.Address.Hex(), interval, point.Time().Unix(),
+				fields["open"], fields["high"], fields["low"], fields["close"],
+				amounts[0], amounts[1], amounts[2], amounts[3],
+			)
+			if err != nil {
+				return fmt.Errorf("could not store candle (%s, %s): %w", pool.Name, interval, err)
+			}
+
+		default:
+			data, err := json.Marshal(fields)
+			if err != nil {
+				return fmt.Errorf("could not encode fields (%s): %w", point.Name(), err)
+			}
+			_, err = tx.Exec(`INSERT OR REPLACE INTO points (measurement, series, time, fields) VALUES (?, ?, ?, ?)`,
+				point.Name(), seriesKey(point), point.Time().UnixNano(), string(data),
+			)
+			if err != nil {
+				return fmt.Errorf("could not store datapoint (%s): %w", point.Name(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Flush commits the open transaction.
+func (s *Store) Flush() error {
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Commit()
+	s.tx = nil
+	if err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Checkpoint records the height along with the data of the range, and commits
+// them together.
+func (s *Store) Checkpoint(height uint64) error {
+
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO checkpoints (chain, height) VALUES (?, ?)`, s.chainName, height)
+	if err != nil {
+		return fmt.Errorf("could not store checkpoint: %w", err)
+	}
+
+	return s.Flush()
+}
+
+// Revert deletes the blocks, events and datapoints of the chain from the given
+// height on, and the candles and other datapoints from the given time on, and
+// moves the checkpoint before the height, all in one transaction.
+func (s *Store) Revert(height uint64, since time.Time) error {
+
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"blocks", "events", "datapoints"} {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE chain = ? AND height >= ?`, s.chainName, height)
+		if err != nil {
+			return fmt.Errorf("could not delete rows (%s): %w", table, err)
+		}
+	}
+
+	// The datapoints carried forward to interval boundaries have no height.
+	_, err = tx.Exec(`DELETE FROM datapoints WHERE chain = ? AND height = 0 AND time >= ?`, s.chainName, since.UnixNano())
+	if err != nil {
+		return fmt.Errorf("could not delete rows (carried datapoints): %w", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM candles WHERE chain = ? AND start >= ?`, s.chainName, since.Unix())
+	if err != nil {
+		return fmt.Errorf("could not delete rows (candles): %w", err)
+	}
+
+	// The other datapoints only have the chain as part of their series, which
+	// lists the tags in order after the measurement.
+	chain := ",chain=" + s.chainName
+	_, err = tx.Exec(`DELETE FROM points WHERE measurement != ? AND time >= ? AND (series LIKE ? ESCAPE '\' OR series LIKE ? ESCAPE '\')`,
+		pendingMeasurement, since.UnixNano(), "%"+escapeLike(chain)+",%", "%"+escapeLike(chain),
+	)
+	if err != nil {
+		return fmt.Errorf("could not delete rows (points): %w", err)
+	}
+
+	if height > 0 {
+		_, err = tx.Exec(`UPDATE checkpoints SET height = ? WHERE chain = ? AND height >= ?`, height-1, s.chainName, height)
+	} else {
+		_, err = tx.Exec(`DELETE FROM checkpoints WHERE chain = ?`, s.chainName)
+	}
+	if err != nil {
+		return fmt.Errorf("could not update checkpoint: %w", err)
+	}
+
+	return s.Flush()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with a backslash.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func (s *Store) Close() error {
+	err := s.Flush()
+	if err != nil {
+		return err
+	}
+	err = s.reader.Close()
+	if err != nil {
+		return err
+	}
+	return s.db.Close()
+}
+
+// Checkpointed returns the last checkpointed height of the given chain, if
+// any, from which processing can resume.
+func (s *Store) Checkpointed(chainName string) (uint64, bool, error) {
+
+	var height uint64
+	err := s.reader.QueryRow(`SELECT height FROM checkpoints WHERE chain = ?`, chainName).Scan(&height)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return height, true, nil
+}
+
+// Checkpoints returns the last checkpointed height of every chain in the store.
+func (s *Store) Checkpoints() (map[string]uint64, error) {
+
+	rows, err := s.reader.Query(`SELECT chain, height FROM checkpoints`)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %w", err)
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[string]uint64)
+	for rows.Next() {
+		var chainName string
+		var height uint64
+		err = rows.Scan(&chainName, &height)
+		if err != nil {
+			return nil, fmt.Errorf("could not read row: %w", err)
+		}
+		checkpoints[chainName] = height
+	}
+
+	return checkpoints, rows.Err()
+}
+
+// Chains returns the names of the chains with stored pairs.
+func (s *Store) Chains() ([]string, error) {
+
+	rows, err := s.reader.Query(`SELECT DISTINCT chain FROM pairs ORDER BY chain`)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var chains []string
+	for rows.Next() {
+		var chainName string
+		err = rows.Scan(&chainName)
+		if err != nil {
+			return nil, fmt.Errorf("could not read row: %w", err)
+		}
+		chains = append(chains, chainName)
+	}
+
+	return chains, rows.Err()
+}
+
+// Datapoints reads the datapoints of a pair between the given timestamps, in
+// chronological order, and hands them to the given callback.
+func (s *Store) Datapoints(chainName string, pool *Pool, start time.Time, end time.Time, limit int, process func(Datapoint) error) error {
+
+	rows, err := s.reader.Query(`SELECT height, time, reserve0, reserve1, volume0, volume1, fee0, fee1, transactions, swaps
+		FROM datapoints
+		WHERE chain = ? AND pair = ? AND time >= ? AND time < ?
+		ORDER BY time, height
+		LIMIT ?`,
+		chainName, pool.Address.Hex(), start.UnixNano(), end.UnixNano(), sqlLimit(limit),
+	)
+	if err != nil {
+		return fmt.Errorf("could not execute query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var point Datapoint
+		var key int64
+		var amounts [6]string
+		err = rows.Scan(&point.Height, &key, &amounts[0], &amounts[1], &amounts[2], &amounts[3], &amounts[4], &amounts[5], &point.Transactions, &point.Swaps)
+		if err != nil {
+			return fmt.Errorf("could not read row: %w", err)
+		}
+		point.Timestamp = time.Unix(0, key).UTC()
+
+		values := []**big.Int{&point.Reserve0, &point.Reserve1, &point.Volume0, &point.Volume1, &point.Fee0, &point.Fee1}
+		for i, value := range values {
+			amount, ok := new(big.Int).SetString(amounts[i], 10)
+			if !ok {
+				return fmt.Errorf("invalid amount (%s)", amounts[i])
+			}
+			*value = amount
+		}
+
+		err = process(point)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Heights reads the heights of the datapoints of a pair between the given
+// timestamps.
+func (s *Store) Heights(chainName string, pool *Pool, start time.Time, end time.Time) (map[uint64]struct{}, error) {
+
+	rows, err := s.reader.Query(`SELECT height
+		FROM datapoints
+		WHERE chain = ? AND pair = ? AND time >= ? AND time < ?`,
+		chainName, pool.Address.Hex(), start.UnixNano(), end.UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %w", err)
+	}
+	defer rows.Close()
+
+	heights := make(map[uint64]struct{})
+	for rows.Next() {
+		var height uint64
+		err = rows.Scan(&height)
+		if err != nil {
+			return nil, fmt.Errorf("could not read row: %w", err)
+		}
+		heights[height] = struct{}{}
+	}
+
+	return heights, rows.Err()
+}
+
+// Candles reads the candles of a pair with the given interval that start
+// between the given timestamps, in chronological order, and hands them to the
+// given callback.
+func (s *Store) Candles(chainName string, pool *Pool, interval string, start time.Time, end time.Time, limit int, process func(Candle) error) error {
+
+	rows, err := s.reader.Query(`SELECT start, open, high, low, close, volume0, volume1, fee0, fee1
+		FROM candles
+		WHERE chain = ? AND pair = ? AND interval = ? AND start >= ? AND start < ?
+		ORDER BY start
+		LIMIT ?`,
+		chainName, pool.Address.Hex(), interval, start.Unix(), end.Unix(), sqlLimit(limit),
+	)
+	if err != nil {
+		return fmt.Errorf("could not execute query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var candle Candle
+		var key int64
+		var amounts [4]string
+		err = rows.Scan(&key, &candle.Open, &candle.High, &candle.Low, &candle.Close, &amounts[0], &amounts[1], &amounts[2], &amounts[3])
+		if err != nil {
+			return fmt.Errorf("could not read row: %w", err)
+		}
+		candle.Start = time.Unix(key, 0).UTC()
+
+		values := []**big.Int{&candle.Volume0, &candle.Volume1, &candle.Fee0, &candle.Fee1}
+		for i, value := range values {
+			amount, ok := new(big.Int).SetString(amounts[i], 10)
+			if !ok {
+				return fmt.Errorf("invalid amount (%s)", amounts[i])
+			}
+			*value = amount
+		}
+
+		err = process(candle)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Event is a decoded event of a pair, as stored with the block timestamp; the
+// amounts hold the amount columns of its kind.
+type Event struct {
+	Kind      string
+	Pair      common.Address
+	Height    uint64
+	Timestamp time.Time
+	LogIndex  uint
+	TxHash    common.Hash
+	Amounts   map[string]*big.Int
+}
+
+// EventFilter selects the stored events of one kind. Events are ordered by
+// height and log index; the time range includes the start and excludes the
+// end, which is unbounded when zero; no pairs select all pairs.
+type EventFilter struct {
+	Kind       string
+	Pairs      []common.Address
+	Start      time.Time
+	End        time.Time
+	Descending bool
+	Offset     int
+	Limit      int
+}
+
+// Day is the aggregate of the datapoints of a pair over one UTC day, with the
+// reserves at the end of the day.
+type Day struct {
+	Pair         common.Address
+	Date         time.Time
+	Reserve0     *big.Int
+	Reserve1     *big.Int
+	Volume0      *big.Int
+	Volume1      *big.Int
+	Transactions uint64
+}
+
+// Pairs returns the metadata of the pairs stored for the given chain, ordered
+// by address.
+func (s *Store) Pairs(chainName string) ([]*Pool, error) {
+
+	rows, err := s.reader.Query(`SELECT p.address, p.name, t0.address, t0.symbol, t0.decimals, t1.address, t1.symbol, t1.decimals
+		FROM pairs p
+		JOIN tokens t0 ON t0.chain = p.chain AND t0.address = p.token0
+		JOIN tokens t1 ON t1.chain = p.chain AND t1.address = p.token1
+		WHERE p.chain = ?
+		ORDER BY p.address`,
+		chainName,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var pools []*Pool
+	for rows.Next() {
+		var pool Pool
+		var address, address0, address1 string
+		err = rows.Scan(&address, &pool.Name,
+			&address0, &pool.Token0.Symbol, &pool.Token0.Decimals,
+			&address1, &pool.Token1.Symbol, &pool.Token1.Decimals,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not read row: %w", err)
+		}
+		pool.Address = common.HexToAddress(address)
+		pool.Token0.Address = common.HexToAddress(address0)
+		pool.Token1.Address = common.HexToAddress(address1)
+		pools = append(pools, &pool)
+	}
+
+	return pools, rows.Err()
+}
+
+// Tokens returns the metadata of the tokens stored for the given chain,
+// ordered by address.
+func (s *Store) Tokens(chainName string) ([]Token, error) {
+
+	rows, err := s.reader.Query(`SELECT address, symbol, decimals FROM tokens WHERE chain = ? ORDER BY address`, chainName)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var token Token
+		var address string
+		err = rows.Scan(&address, &token.Symbol, &token.Decimals)
+		if err != nil {
+			return nil, fmt.Errorf("could not read row: %w", err)
+		}
+		token.Address = common.HexToAddress(address)
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// Events returns the stored events of the given chain selected by the filter.
+func (s *Store) Events(chainName string, filter EventFilter) ([]Event, error) {
+
+	end := int64(math.MaxInt64)
+	if !filter.End.IsZero() {
+		end = filter.End.Unix()
+	}
+
+	query := `SELECT e.pair, e.height, b.timestamp, e.log_index, e.tx_hash, ` + strings.Join(eventColumns, ", ") + `
+		FROM events e JOIN blocks b ON b.chain = e.chain AND b.height = e.height
+		WHERE e.chain = ? AND e.kind = ? AND b.timestamp >= ? AND b.timestamp < ?`
+	args := []interface{}{chainName, filter.Kind, filter.Start.Unix(), end}
+	if len(filter.Pairs) > 0 {
+		query += ` AND e.pair IN (?` + strings.Repeat(", ?", len(filter.Pairs)-1) + `)`
+		for _, pair := range filter.Pairs {
+			args = append(args, pair.Hex())
+		}
+	}
+	if filter.Descending {
+		query += ` ORDER BY e.height DESC, e.log_index DESC`
+	} else {
+		query += ` ORDER BY e.height, e.log_index`
+	}
+	query += ` LIMIT ? OFFSET ?`
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := s.reader.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+
+		event := Event{
+			Kind:    filter.Kind,
+			Amounts: make(map[string]*big.Int),
+		}
+		var pair, hash string
+		var timestamp int64
+		amounts := make([]sql.NullString, len(eventColumns))
+		dest := []interface{}{&pair, &event.Height, &timestamp, &event.LogIndex, &hash}
+		for i := range amounts {
+			dest = append(dest, &amounts[i])
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, fmt.Errorf("could not read row: %w", err)
+		}
+		event.Pair = common.HexToAddress(pair)
+		event.Timestamp = time.Unix(timestamp, 0).UTC()
+		event.TxHash = common.HexToHash(hash)
+
+		for i, column := range eventColumns {
+			if !amounts[i].Valid {
+				continue
+			}
+			amount, ok := new(big.Int).SetString(amounts[i].String, 10)
+			if !ok {
+				return nil, fmt.Errorf("invalid amount (%s)", amounts[i].String)
+			}
+			event.Amounts[column] = amount
+		}
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// Latest returns the last stored datapoint of a pair, if any.
+func (s *Store) Latest(chainName string, pair common.Address) (Datapoint, bool, error) {
+
+	var point Datapoint
+	var key int64
+	var amounts [6]string
+	err := s.reader.QueryRow(`SELECT height, time, reserve0, reserve1, volume0, volume1, fee0, fee1, transactions, swaps
+		FROM datapoints WHERE chain = ? AND pair = ?
+		ORDER BY time DESC LIMIT 1`,
+		chainName, pair.Hex(),
+	).Scan(&point.Height, &key, &amounts[0], &amounts[1], &amounts[2], &amounts[3], &amounts[4], &amounts[5], &point.Transactions, &point.Swaps)
+	if err == sql.ErrNoRows {
+		return Datapoint{}, false, nil
+	}
+	if err != nil {
+		return Datapoint{}, false, fmt.Errorf("could not execute query: %w", err)
+	}
+	point.Timestamp = time.Unix(0, key).UTC()
+
+	values := []**big.Int{&point.Reserve0, &point.Reserve1, &point.Volume0, &point.Volume1, &point.Fee0, &point.Fee1}
+	for i, value := range values {
+		amount, ok := new(big.Int).SetString(amounts[i], 10)
+		if !ok {
+			return Datapoint{}, false, fmt.Errorf("invalid amount (%s)", amounts[i])
+		}
+		*value = amount
+	}
+
+	return point, true, nil
+}
+
+// Totals returns the volumes and number of transactions of a pair summed over
+// all its stored datapoints. The volumes are summed here rather than in the
+// query, as SQLite cannot sum them without losing precision.
+func (s *Store) Totals(chainName string, pair common.Address) (*big.Int, *big.Int, uint64, error) {
+
+	rows, err := s.reader.Query(`SELECT volume0, volume1, transactions FROM datapoints WHERE chain = ? AND pair = ?`,
+		chainName, pair.Hex(),
+	)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("could not execute query: %w", err)
+	}
+	defer rows.Close()
+
+	volume0 := big.NewInt(0)
+	volume1 := big.NewInt(0)
+	transactions := uint64(0)
+	for rows.Next() {
+		var encoded0, encoded1 string
+		var count uint64
+		err = rows.Scan(&encoded0, &encoded1, &count)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("could not read row: %w", err)
+		}
+		amount0, ok0 := new(big.Int).SetString(encoded0, 10)
+		amount1, ok1 := new(big.Int).SetString(encoded1, 10)
+		if !ok0 || !ok1 {
+			return nil, nil, 0, fmt.Errorf("invalid volumes (%s, %s)", encoded0, encoded1)
+		}
+		volume0.Add(volume0, amount0)
+		volume1.Add(volume1, amount1)
+		transactions += count
+	}
+
+	return volume0, volume1, transactions, rows.Err()
+}
+
+// Days aggregates the datapoints of a pair between the given timestamps by UTC
+// day, in chronological order.
+func (s *Store) Days(chainName string, pair common.Address, start time.Time, end time.Time) ([]Day, error) {
+
+	rows, err := s.reader.Query(`SELECT timestamp, reserve0, reserve1, volume0, volume1, transactions
+		FROM datapoints WHERE chain = ? AND pair = ? AND time >= ? AND time < ?
+		ORDER BY time`,
+		chainName, pair.Hex(), start.UnixNano(), end.UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var days []Day
+	for rows.Next() {
+
+		var timestamp int64
+		var amounts [4]string
+		var transactions uint64
+		err = rows.Scan(&timestamp, &amounts[0], &amounts[1], &amounts[2], &amounts[3], &transactions)
+		if err != nil {
+			return nil, fmt.Errorf("could not read row: %w", err)
+		}
+
+		values := make([]*big.Int, len(amounts))
+		for i := range amounts {
+			amount, ok := new(big.Int).SetString(amounts[i], 10)
+			if !ok {
+				return nil, fmt.Errorf("invalid amount (%s)", amounts[i])
+			}
+			values[i] = amount
+		}
+
+		date := time.Unix(timestamp, 0).UTC().Truncate(24 * time.Hour)
+		if len(days) == 0 || !days[len(days)-1].Date.Equal(date) {
+			days = append(days, Day{
+				Pair:    pair,
+				Date:    date,
+				Volume0: big.NewInt(0),
+				Volume1: big.NewInt(0),
+			})
+		}
+		day := &days[len(days)-1]
+		day.Reserve0 = values[0]
+		day.Reserve1 = values[1]
+		day.Volume0.Add(day.Volume0, values[2])
+		day.Volume1.Add(day.Volume1, values[3])
+		day.Transactions += transactions
+	}
+
+	return days, rows.Err()
+}
+
+// begin returns the open transaction, or opens one.
+func (s *Store) begin() (*sql.Tx, error) {
+	if s.tx != nil {
+		return s.tx, nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	s.tx = tx
+	return tx, nil
+}
+
+// decodeFields decodes the amount fields with the given names into decimal
+// strings.
+func decodeFields(fields map[string]interface{}, names ...string) ([]string, error) {
+	amounts := make([]string, 0, len(names))
+	for _, name := range names {
+		encoded, _ := fields[name].(string)
+		amount, err := decodeAmount(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode field (%s): %w", name, err)
+		}
+		amounts = append(amounts, amount.String())
+	}
+	return amounts, nil
+}
+
+// sqlLimit converts a limit where zero means no limit into the value of a
+// LIMIT clause, where a negative value means no limit.
+func sqlLimit(limit int) int {
+	if limit == 0 {
+		return -1
+	}
+	return limit
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"github.com/spf13/pflag"
+)
+
+// showStatus shows the last checkpointed height of each chain in the SQLite
+// database. With a JSON RPC API URL, it also shows how far the checkpoint of
+// that chain lags behind the chain head.
+func showStatus(args []string) {
+
+	var (
+		logLevel string
+
+		sqlitePath string
+
+		apiURL string
+	)
+
+	flags := pflag.NewFlagSet("status", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+
+	flags.StringVar(&sqlitePath, "sqlite-path", "klangbaach.db", "path of the SQLite database file")
+	flags.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL of the chain to compare with (optional)")
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	store, err := OpenStore(sqlitePath)
+	if err != nil {
+		log.Fatal().Str("sqlite_path", sqlitePath).Err(err).Msg("could not open SQLite database")
+	}
+
+	checkpoints, err := store.Checkpoints()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not read checkpoints")
+	}
+
+	if apiURL == "" {
+		chains := make([]string, 0, len(checkpoints))
+		for chainName := range checkpoints {
+			chains = append(chains, chainName)
+		}
+		sort.Strings(chains)
+		for _, chainName := range chains {
+			log.Info().Str("chain_name", chainName).Uint64("checkpoint", checkpoints[chainName]).Msg("chain status")
+		}
+		if len(checkpoints) == 0 {
+			log.Info().Msg("no checkpoints stored")
+		}
+	} else {
+		client, chainName := connectChain(log, apiURL)
+		head, err := client.BlockNumber(context.Background())
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not get last block height")
+		}
+		height, ok := checkpoints[chainName]
+		if !ok {
+			log.Info().Str("chain_name", chainName).Uint64("head", head).Msg("no checkpoint stored for chain")
+		} else {
+			lag := uint64(0)
+			if head > height {
+				lag = head - height
+			}
+			log.Info().
+				Str("chain_name", chainName).
+				Uint64("checkpoint", height).
+				Uint64("head", head).
+				Uint64("lag", lag).
+				Msg("chain status")
+		}
+	}
+
+	err = store.Close()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not close SQLite database")
+	}
+
+	os.Exit(0)
+}
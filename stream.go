@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// settleDelay is how long to wait after the last log entry of a block
+	// before processing it; the node delivers the entries of a block together,
+	// so the delay only needs to cover their transmission. The entries are
+	// read again in full when the block is processed, so entries arriving
+	// after the delay are not lost.
+	settleDelay = 200 * time.Millisecond
+
+	// resubscribeDelay is how long to wait before subscribing again after the
+	// subscription failed.
+	resubscribeDelay = 5 * time.Second
+
+	// reorgDepth is the number of processed ranges for which the state before
+	// them is kept, so that reorganizations within them can be rolled back.
+	reorgDepth = 128
+)
+
+// minerState is the state of the pools and of everything the miner derives
+// from them before a processed range.
+type minerState struct {
+	from      uint64
+	height    uint64
+	next      time.Time
+	pools     map[common.Address]Pool
+	aprs      map[common.Address]*APR
+	candles   map[common.Address][]*Candles
+	anomalies map[common.Address]*Anomalies
+	arbitrage *Arbitrage
+	check     *TransferCheck
+}
+
+// Stream follows the chain head from the given height through a subscription
+// to the log entries of the tracked pools, which requires a WebSocket
+// endpoint. Each block is processed and checkpointed as soon as its entries
+// have arrived. When the subscription drops, the heights missed in the
+// meantime are processed in batches of the given size before following the
+// head again. Stream returns when the context is canceled.
+func (m *Miner) Stream(ctx context.Context, next uint64, batchSize uint64) error {
+
+	query := ethereum.FilterQuery{
+		Addresses: m.addresses,
+		Topics:    [][]common.Hash{{SigSwap, SigSync, SigMint, SigBurn}},
+	}
+
+	for {
+
+		entries := make(chan types.Log, 1024)
+		start := time.Now()
+		sub, err := m.client.SubscribeFilterLogs(ctx, query, entries)
+		observeRPC("eth_subscribe", start, err)
+		if err != nil {
+			m.log.Warn().Err(err).Msg("could not subscribe to log entries")
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(resubscribeDelay):
+				continue
+			}
+		}
+
+		// The subscription buffers the entries of new blocks while the ones
+		// missed before it was established are processed.
+		next, err = m.catchUp(next, batchSize)
+		if err != nil {
+			sub.Unsubscribe()
+			return fmt.Errorf("could not catch up with chain head: %w", err)
+		}
+
+		m.log.Info().Uint64("next", next).Msg("following chain head")
+
+		next, err = m.follow(ctx, query, sub, entries, next)
+		sub.Unsubscribe()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+	}
+}
+
+// catchUp processes the heights from the given one up to the current head and
+// returns the next height to process.
+func (m *Miner) catchUp(next uint64, batchSize uint64) (uint64, error) {
+
+	start := time.Now()
+	head, err := m.client.BlockNumber(context.Background())
+	observeRPC("eth_blockNumber", start, err)
+	if err != nil {
+		return next, fmt.Errorf("could not get last block height: %w", err)
+	}
+	observeHead(head)
+
+	for next <= head {
+
+		to := next + batchSize - 1
+		if to > head {
+			to = head
+		}
+
+		m.save(next)
+		err = m.Process(next, to)
+		if err != nil {
+			return next, fmt.Errorf("could not process block range: %w", err)
+		}
+
+		next = to + 1
+	}
+
+	return next, nil
+}
+
+// follow processes the blocks delivered by the subscription until it fails or
+// the context is canceled, and returns the next height to process. When log
+// entries of processed blocks are removed by a reorganization, the miner is
+// rolled back to the height before them, and the blocks of the new branch are
+// processed again.
+func (m *Miner) follow(ctx context.Context, query ethereum.FilterQuery, sub ethereum.Subscription, entries <-chan types.Log, next uint64) (uint64, error) {
+
+	var pending []types.Log
+	var settle <-chan time.Time
+
+	// The subscription only tells which block arrived; once it settled, all of
+	// its entries are read by its hash, including those the subscription did
+	// not deliver yet. When that fails, the block is left to catching up after
+	// subscribing again.
+	stale := false
+	process := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		height := pending[0].BlockNumber
+		hash := pending[0].BlockHash
+		filter := query
+		filter.BlockHash = &hash
+		start := time.Now()
+		block, err := m.client.FilterLogs(context.Background(), filter)
+		observeRPC("eth_getLogs", start, err)
+		if err != nil {
+			m.log.Warn().Uint64("height", height).Err(err).Msg("could not read log entries of block")
+			stale = true
+			pending = nil
+			settle = nil
+			return nil
+		}
+		m.save(next)
+		err = m.refreshFees()
+		if err != nil {
+			m.log.Warn().Uint64("height", height).Err(err).Msg("could not refresh protocol fee recipients")
+		}
+		err = m.Apply(next, height, block)
+		if err != nil {
+			return fmt.Errorf("could not process block (%d): %w", height, err)
+		}
+		next = height + 1
+		pending = nil
+		settle = nil
+		return nil
+	}
+
+	for {
+		select {
+
+		case <-ctx.Done():
+			return next, process()
+
+		case err := <-sub.Err():
+			// The entries of the pending block might be incomplete, so they are
+			// discarded and retrieved again when catching up.
+			m.log.Warn().Err(err).Uint64("next", next).Msg("log subscription dropped")
+			return next, nil
+
+		case <-settle:
+			err := process()
+			if err != nil || stale {
+				return next, err
+			}
+
+		case entry := <-entries:
+
+			if entry.Removed {
+				if entry.BlockNumber >= next {
+					pending = nil
+					settle = nil
+					continue
+				}
+				m.log.Warn().Uint64("height", entry.BlockNumber).Msg("chain reorganization detected")
+				err := m.rollback(entry.BlockNumber)
+				if err != nil {
+					return next, fmt.Errorf("could not roll back reorganized blocks: %w", err)
+				}
+				next = entry.BlockNumber
+				pending = nil
+				settle = nil
+				continue
+			}
+
+			// The block of the entry was already read in full.
+			if entry.BlockNumber < next {
+				m.log.Debug().Uint64("height", entry.BlockNumber).Uint("index", entry.Index).Msg("skipping log entry of processed block")
+				continue
+			}
+
+			observeHead(entry.BlockNumber)
+
+			if len(pending) > 0 && pending[0].BlockNumber != entry.BlockNumber {
+				err := process()
+				if err != nil || stale {
+					return next, err
+				}
+			}
+
+			pending = append(pending, entry)
+			settle = time.After(settleDelay)
+		}
+	}
+}
+
+// save keeps the current state of the miner as the state before the range
+// starting at the given height, and discards the oldest states beyond the
+// reorganization depth.
+func (m *Miner) save(from uint64) {
+
+	state := minerState{
+		from:      from,
+		height:    m.height,
+		next:      m.next,
+		pools:     make(map[common.Address]Pool, len(m.pools)),
+		aprs:      make(map[common.Address]*APR, len(m.aprs)),
+		candles:   make(map[common.Address][]*Candles, len(m.candles)),
+		anomalies: make(map[common.Address]*Anomalies, len(m.anomalies)),
+	}
+	for address, pool := range m.pools {
+		state.pools[address] = pool.clone()
+	}
+	for address, apr := range m.aprs {
+		state.aprs[address] = apr.clone()
+	}
+	for address, aggregators := range m.candles {
+		for _, aggregator := range aggregators {
+			state.candles[address] = append(state.candles[address], aggregator.clone())
+		}
+	}
+	for address, detector := range m.anomalies {
+		state.anomalies[address] = detector.clone()
+	}
+	if m.arbitrage != nil {
+		state.arbitrage = m.arbitrage.clone()
+	}
+	if m.check != nil {
+		state.check = m.check.clone()
+	}
+
+	// A range that is processed again replaces the states from its start on.
+	for len(m.states) > 0 && m.states[len(m.states)-1].from >= from {
+		m.states = m.states[:len(m.states)-1]
+	}
+	m.states = append(m.states, state)
+	if len(m.states) > reorgDepth {
+		m.states = m.states[len(m.states)-reorgDepth:]
+	}
+}
+
+// restore sets the miner to a saved state. The state is copied again, so it
+// can be restored more than once.
+func (m *Miner) restore(state minerState) {
+
+	m.height = state.height
+	m.next = state.next
+
+	// The pools are shared with the pricer, the arbitrage and the transfer
+	// check, so they are updated in place.
+	for address, saved := range state.pools {
+		pool, ok := m.pools[address]
+		if ok {
+			*pool = saved.clone()
+		}
+	}
+	for address, apr := range state.aprs {
+		m.aprs[address] = apr.clone()
+	}
+	for address, aggregators := range state.candles {
+		restored := make([]*Candles, 0, len(aggregators))
+		for _, aggregator := range aggregators {
+			restored = append(restored, aggregator.clone())
+		}
+		m.candles[address] = restored
+	}
+	for address, detector := range state.anomalies {
+		m.anomalies[address] = detector.clone()
+	}
+	if state.arbitrage != nil {
+		m.arbitrage = state.arbitrage.clone()
+	}
+	if state.check != nil {
+		m.check = state.check.clone()
+	}
+}
+
+// rollback undoes the processing of the blocks from the given height on, which
+// were removed by a reorganization. The miner goes back to the last state saved
+// before that height, the sinks drop what they stored from there on, and the
+// heights before the removed blocks are processed again. Without a saved state,
+// only the state of the pools is loaded from the node, so the aggregates might
+// still include the removed blocks.
+func (m *Miner) rollback(height uint64) error {
+
+	// Several blocks can share a timestamp, and the sinks drop the datapoints
+	// of the removed blocks by their time, which also drops those of the kept
+	// blocks with the same timestamp; the miner therefore goes back to the
+	// first block with the timestamp of the last kept block.
+	start := height
+	var since time.Time
+	if height > 0 {
+		last, err := m.header(height - 1)
+		if err != nil {
+			return err
+		}
+		since = time.Unix(int64(last.Time), 0).UTC()
+		start = height - 1
+		for start > 0 {
+			previous, err := m.header(start - 1)
+			if err != nil {
+				return err
+			}
+			if previous.Time != last.Time {
+				break
+			}
+			start--
+		}
+	}
+
+	index := len(m.states) - 1
+	for index >= 0 && m.states[index].from > start {
+		index--
+	}
+	m.states = m.states[:index+1]
+
+	from := start
+	if index >= 0 {
+		from = m.states[index].from
+		m.restore(m.states[index])
+	} else {
+		m.log.Warn().Uint64("height", height).Msg("no state saved before reorganization, loading pair state from node")
+		if start > 0 {
+			m.Load(start - 1)
+		}
+	}
+
+	reverter, ok := m.sink.(Reverter)
+	if ok && height > 0 {
+		err := reverter.Revert(from, since)
+		if err != nil {
+			return fmt.Errorf("could not revert sinks: %w", err)
+		}
+	}
+
+	if from < height {
+		err := m.Process(from, height-1)
+		if err != nil {
+			return fmt.Errorf("could not process block range again: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// header retrieves the header of the block at the given height.
+func (m *Miner) header(height uint64) (*types.Header, error) {
+	start := time.Now()
+	header, err := m.client.HeaderByNumber(context.Background(), new(big.Int).SetUint64(height))
+	observeRPC("eth_getBlockByNumber", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not get header for height (%d): %w", height, err)
+	}
+	return header, nil
+}
@@ -0,0 +1,903 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	maxEntities = 1000
+)
+
+// subgraphSchema is the subset of the Uniswap v2 subgraph schema that can be
+// derived from the stored data. Entities, fields and arguments keep the names
+// of the subgraph, so existing queries work as long as they only use them.
+const subgraphSchema = `
+schema {
+	query: Query
+}
+
+scalar BigInt
+scalar BigDecimal
+scalar Bytes
+
+enum OrderDirection {
+	asc
+	desc
+}
+
+enum Token_orderBy {
+	id
+	symbol
+}
+
+enum Pair_orderBy {
+	id
+}
+
+enum Swap_orderBy {
+	timestamp
+}
+
+enum Mint_orderBy {
+	timestamp
+}
+
+enum Burn_orderBy {
+	timestamp
+}
+
+enum PairDayData_orderBy {
+	date
+}
+
+input Token_filter {
+	id: ID
+	id_in: [ID!]
+	symbol: String
+}
+
+input Pair_filter {
+	id: ID
+	id_in: [ID!]
+	token0: String
+	token1: String
+}
+
+input Event_filter {
+	pair: String
+	pair_in: [String!]
+	timestamp_gt: BigInt
+	timestamp_gte: BigInt
+	timestamp_lt: BigInt
+	timestamp_lte: BigInt
+}
+
+input PairDayData_filter {
+	pairAddress: Bytes
+	pairAddress_in: [Bytes!]
+	date_gt: Int
+	date_gte: Int
+	date_lt: Int
+	date_lte: Int
+}
+
+type Query {
+	token(id: ID!): Token
+	tokens(skip: Int = 0, first: Int = 100, orderBy: Token_orderBy, orderDirection: OrderDirection, where: Token_filter): [Token!]!
+	pair(id: ID!): Pair
+	pairs(skip: Int = 0, first: Int = 100, orderBy: Pair_orderBy, orderDirection: OrderDirection, where: Pair_filter): [Pair!]!
+	swaps(skip: Int = 0, first: Int = 100, orderBy: Swap_orderBy, orderDirection: OrderDirection, where: Event_filter): [Swap!]!
+	mints(skip: Int = 0, first: Int = 100, orderBy: Mint_orderBy, orderDirection: OrderDirection, where: Event_filter): [Mint!]!
+	burns(skip: Int = 0, first: Int = 100, orderBy: Burn_orderBy, orderDirection: OrderDirection, where: Event_filter): [Burn!]!
+	pairDayDatas(skip: Int = 0, first: Int = 100, orderBy: PairDayData_orderBy, orderDirection: OrderDirection, where: PairDayData_filter): [PairDayData!]!
+}
+
+type Token {
+	id: ID!
+	symbol: String!
+	decimals: BigInt!
+}
+
+type Pair {
+	id: ID!
+	token0: Token!
+	token1: Token!
+	reserve0: BigDecimal!
+	reserve1: BigDecimal!
+	token0Price: BigDecimal!
+	token1Price: BigDecimal!
+	volumeToken0: BigDecimal!
+	volumeToken1: BigDecimal!
+	txCount: BigInt!
+}
+
+type Transaction {
+	id: ID!
+	blockNumber: BigInt!
+	timestamp: BigInt!
+}
+
+type Swap {
+	id: ID!
+	transaction: Transaction!
+	timestamp: BigInt!
+	pair: Pair!
+	amount0In: BigDecimal!
+	amount1In: BigDecimal!
+	amount0Out: BigDecimal!
+	amount1Out: BigDecimal!
+	logIndex: BigInt
+}
+
+type Mint {
+	id: ID!
+	transaction: Transaction!
+	timestamp: BigInt!
+	pair: Pair!
+	amount0: BigDecimal
+	amount1: BigDecimal
+	logIndex: BigInt
+}
+
+type Burn {
+	id: ID!
+	transaction: Transaction!
+	timestamp: BigInt!
+	pair: Pair!
+	amount0: BigDecimal
+	amount1: BigDecimal
+	logIndex: BigInt
+}
+
+type PairDayData {
+	id: ID!
+	date: Int!
+	pairAddress: Bytes!
+	token0: Token!
+	token1: Token!
+	reserve0: BigDecimal!
+	reserve1: BigDecimal!
+	dailyVolumeToken0: BigDecimal!
+	dailyVolumeToken1: BigDecimal!
+	dailyTxns: BigInt!
+}
+`
+
+// BigInt is an integer of arbitrary size, encoded as a decimal string.
+type BigInt string
+
+func (BigInt) ImplementsGraphQLType(name string) bool {
+	return name == "BigInt"
+}
+
+// UnmarshalGraphQL accepts integer literals as well as strings, as the
+// subgraph does.
+func (b *BigInt) UnmarshalGraphQL(input interface{}) error {
+	var value string
+	switch input := input.(type) {
+	case string:
+		value = input
+	case int32:
+		value = strconv.FormatInt(int64(input), 10)
+	case int64:
+		value = strconv.FormatInt(input, 10)
+	case float64:
+		value = strconv.FormatFloat(input, 'f', -1, 64)
+	default:
+		return fmt.Errorf("invalid type for BigInt (%T)", input)
+	}
+	_, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return fmt.Errorf("invalid BigInt (%s)", value)
+	}
+	*b = BigInt(value)
+	return nil
+}
+
+func (b BigInt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(b))
+}
+
+// Int64 returns the value of the integer, saturated to the range of int64.
+func (b BigInt) Int64() int64 {
+	value, _ := new(big.Int).SetString(string(b), 10)
+	if !value.IsInt64() {
+		if value.Sign() < 0 {
+			return -1 << 63
+		}
+		return 1<<63 - 1
+	}
+	return value.Int64()
+}
+
+// BigDecimal is a decimal number of arbitrary precision, encoded as a string.
+type BigDecimal string
+
+func (BigDecimal) ImplementsGraphQLType(name string) bool {
+	return name == "BigDecimal"
+}
+
+func (d *BigDecimal) UnmarshalGraphQL(input interface{}) error {
+	value, ok := input.(string)
+	if !ok {
+		return fmt.Errorf("invalid type for BigDecimal (%T)", input)
+	}
+	*d = BigDecimal(value)
+	return nil
+}
+
+func (d BigDecimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(d))
+}
+
+// Bytes is a byte string, such as an address, encoded as lowercase hex.
+type Bytes string
+
+func (Bytes) ImplementsGraphQLType(name string) bool {
+	return name == "Bytes"
+}
+
+func (b *Bytes) UnmarshalGraphQL(input interface{}) error {
+	value, ok := input.(string)
+	if !ok {
+		return fmt.Errorf("invalid type for Bytes (%T)", input)
+	}
+	*b = Bytes(strings.ToLower(value))
+	return nil
+}
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(b))
+}
+
+// Subgraph serves the pairs, tokens and events of a chain stored in the SQLite
+// store through GraphQL, with the entities of the Uniswap v2 subgraph, so that
+// queries written against the hosted subgraph can run against the miner's own
+// data. Identifiers are lowercase hex addresses, as in the subgraph; events are
+// identified by their transaction hash and log index.
+type Subgraph struct {
+	store     *Store
+	chainName string
+}
+
+// NewSubgraph returns the HTTP handler of the GraphQL API for the given chain.
+func NewSubgraph(store *Store, chainName string) (http.Handler, error) {
+
+	s := Subgraph{
+		store:     store,
+		chainName: chainName,
+	}
+
+	schema, err := graphql.ParseSchema(subgraphSchema, &s, graphql.UseFieldResolvers())
+	if err != nil {
+		return nil, fmt.Errorf("invalid subgraph schema: %w", err)
+	}
+
+	return &relay.Handler{Schema: schema}, nil
+}
+
+// The arguments of the collection fields; the fields of the filters match the
+// input fields of the schema once their underscores are removed.
+type tokenArgs struct {
+	Skip           int32
+	First          int32
+	OrderBy        *string
+	OrderDirection *string
+	Where          *tokenWhere
+}
+
+type tokenWhere struct {
+	ID     *graphql.ID
+	IDIn   *[]graphql.ID
+	Symbol *string
+}
+
+type pairArgs struct {
+	Skip           int32
+	First          int32
+	OrderBy        *string
+	OrderDirection *string
+	Where          *pairWhere
+}
+
+type pairWhere struct {
+	ID     *graphql.ID
+	IDIn   *[]graphql.ID
+	Token0 *string
+	Token1 *string
+}
+
+type eventArgs struct {
+	Skip           int32
+	First          int32
+	OrderBy        *string
+	OrderDirection *string
+	Where          *eventWhere
+}
+
+type eventWhere struct {
+	Pair         *string
+	PairIn       *[]string
+	TimestampGt  *BigInt
+	TimestampGte *BigInt
+	TimestampLt  *BigInt
+	TimestampLte *BigInt
+}
+
+type dayArgs struct {
+	Skip           int32
+	First          int32
+	OrderBy        *string
+	OrderDirection *string
+	Where          *dayWhere
+}
+
+type dayWhere struct {
+	PairAddress   *Bytes
+	PairAddressIn *[]Bytes
+	DateGt        *int32
+	DateGte       *int32
+	DateLt        *int32
+	DateLte       *int32
+}
+
+func (s *Subgraph) Token(args struct{ ID graphql.ID }) (*tokenResolver, error) {
+	tokens, err := s.Tokens(tokenArgs{First: 1, Where: &tokenWhere{ID: &args.ID}})
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return tokens[0], nil
+}
+
+func (s *Subgraph) Tokens(args tokenArgs) ([]*tokenResolver, error) {
+
+	err := checkPage(args.Skip, args.First)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.store.Tokens(s.chainName)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolvers []*tokenResolver
+	for _, token := range tokens {
+		resolver := newTokenResolver(token)
+		if args.Where != nil {
+			if args.Where.ID != nil && resolver.ID != graphql.ID(strings.ToLower(string(*args.Where.ID))) {
+				continue
+			}
+			if args.Where.IDIn != nil && !containsID(*args.Where.IDIn, resolver.ID) {
+				continue
+			}
+			if args.Where.Symbol != nil && resolver.Symbol != *args.Where.Symbol {
+				continue
+			}
+		}
+		resolvers = append(resolvers, resolver)
+	}
+
+	bySymbol := args.OrderBy != nil && *args.OrderBy == "symbol"
+	desc := descending(args.OrderDirection)
+	sort.SliceStable(resolvers, func(i, j int) bool {
+		a, b := resolvers[i].ID, resolvers[j].ID
+		if bySymbol {
+			a, b = graphql.ID(resolvers[i].Symbol), graphql.ID(resolvers[j].Symbol)
+		}
+		if desc {
+			return a > b
+		}
+		return a < b
+	})
+
+	start, end := pageBounds(len(resolvers), args.Skip, args.First)
+	return resolvers[start:end], nil
+}
+
+func (s *Subgraph) Pair(args struct{ ID graphql.ID }) (*pairResolver, error) {
+	pairs, err := s.Pairs(pairArgs{First: 1, Where: &pairWhere{ID: &args.ID}})
+	if err != nil || len(pairs) == 0 {
+		return nil, err
+	}
+	return pairs[0], nil
+}
+
+func (s *Subgraph) Pairs(args pairArgs) ([]*pairResolver, error) {
+
+	err := checkPage(args.Skip, args.First)
+	if err != nil {
+		return nil, err
+	}
+
+	pools, err := s.store.Pairs(s.chainName)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolvers []*pairResolver
+	for _, pool := range pools {
+		resolver := s.newPairResolver(pool)
+		if args.Where != nil {
+			if args.Where.ID != nil && resolver.ID() != graphql.ID(strings.ToLower(string(*args.Where.ID))) {
+				continue
+			}
+			if args.Where.IDIn != nil && !containsID(*args.Where.IDIn, resolver.ID()) {
+				continue
+			}
+			if args.Where.Token0 != nil && !strings.EqualFold(*args.Where.Token0, pool.Token0.Address.Hex()) {
+				continue
+			}
+			if args.Where.Token1 != nil && !strings.EqualFold(*args.Where.Token1, pool.Token1.Address.Hex()) {
+				continue
+			}
+		}
+		resolvers = append(resolvers, resolver)
+	}
+
+	desc := descending(args.OrderDirection)
+	sort.SliceStable(resolvers, func(i, j int) bool {
+		if desc {
+			return resolvers[i].ID() > resolvers[j].ID()
+		}
+		return resolvers[i].ID() < resolvers[j].ID()
+	})
+
+	start, end := pageBounds(len(resolvers), args.Skip, args.First)
+	return resolvers[start:end], nil
+}
+
+func (s *Subgraph) Swaps(args eventArgs) ([]*swapResolver, error) {
+
+	events, pools, err := s.events("swap", args)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*swapResolver, 0, len(events))
+	for _, event := range events {
+		pool := pools[event.Pair]
+		resolvers = append(resolvers, &swapResolver{
+			eventResolver: s.newEventResolver(event, pool),
+			Amount0In:     BigDecimal(formatAmount(event.Amounts["amount0_in"], pool.Token0.Decimals)),
+			Amount1In:     BigDecimal(formatAmount(event.Amounts["amount1_in"], pool.Token1.Decimals)),
+			Amount0Out:    BigDecimal(formatAmount(event.Amounts["amount0_out"], pool.Token0.Decimals)),
+			Amount1Out:    BigDecimal(formatAmount(event.Amounts["amount1_out"], pool.Token1.Decimals)),
+		})
+	}
+
+	return resolvers, nil
+}
+
+func (s *Subgraph) Mints(args eventArgs) ([]*liquidityResolver, error) {
+	return s.liquidity("mint", args)
+}
+
+func (s *Subgraph) Burns(args eventArgs) ([]*liquidityResolver, error) {
+	return s.liquidity("burn", args)
+}
+
+func (s *Subgraph) PairDayDatas(args dayArgs) ([]*dayResolver, error) {
+
+	err := checkPage(args.Skip, args.First)
+	if err != nil {
+		return nil, err
+	}
+
+	pools, err := s.store.Pairs(s.chainName)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Unix(0, 0)
+	end := time.Now().Add(24 * time.Hour)
+	selected := pools
+	if args.Where != nil {
+		var addresses []Bytes
+		if args.Where.PairAddress != nil {
+			addresses = append(addresses, *args.Where.PairAddress)
+		}
+		if args.Where.PairAddressIn != nil {
+			addresses = append(addresses, *args.Where.PairAddressIn...)
+		}
+		if len(addresses) > 0 {
+			selected = nil
+			for _, pool := range pools {
+				for _, address := range addresses {
+					if strings.EqualFold(string(address), pool.Address.Hex()) {
+						selected = append(selected, pool)
+						break
+					}
+				}
+			}
+		}
+		if args.Where.DateGt != nil {
+			start = time.Unix(int64(*args.Where.DateGt)+1, 0)
+		}
+		if args.Where.DateGte != nil {
+			start = time.Unix(int64(*args.Where.DateGte), 0)
+		}
+		if args.Where.DateLt != nil {
+			end = time.Unix(int64(*args.Where.DateLt), 0)
+		}
+		if args.Where.DateLte != nil {
+			end = time.Unix(int64(*args.Where.DateLte)+1, 0)
+		}
+	}
+
+	// The filters apply to the date of the day, which is its start.
+	start = start.Add(24*time.Hour - time.Second).Truncate(24 * time.Hour)
+	end = end.Add(24*time.Hour - time.Second).Truncate(24 * time.Hour)
+
+	var resolvers []*dayResolver
+	for _, pool := range selected {
+		days, err := s.store.Days(s.chainName, pool.Address, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, day := range days {
+			resolvers = append(resolvers, newDayResolver(day, pool))
+		}
+	}
+
+	desc := descending(args.OrderDirection)
+	sort.SliceStable(resolvers, func(i, j int) bool {
+		if resolvers[i].Date == resolvers[j].Date {
+			return resolvers[i].ID < resolvers[j].ID
+		}
+		if desc {
+			return resolvers[i].Date > resolvers[j].Date
+		}
+		return resolvers[i].Date < resolvers[j].Date
+	})
+
+	first, last := pageBounds(len(resolvers), args.Skip, args.First)
+	return resolvers[first:last], nil
+}
+
+// liquidity returns the mints or burns selected by the arguments.
+func (s *Subgraph) liquidity(kind string, args eventArgs) ([]*liquidityResolver, error) {
+
+	events, pools, err := s.events(kind, args)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*liquidityResolver, 0, len(events))
+	for _, event := range events {
+		pool := pools[event.Pair]
+		amount0 := BigDecimal(formatAmount(event.Amounts["amount0"], pool.Token0.Decimals))
+		amount1 := BigDecimal(formatAmount(event.Amounts["amount1"], pool.Token1.Decimals))
+		resolvers = append(resolvers, &liquidityResolver{
+			eventResolver: s.newEventResolver(event, pool),
+			Amount0:       &amount0,
+			Amount1:       &amount1,
+		})
+	}
+
+	return resolvers, nil
+}
+
+// events reads the events of the given kind selected by the arguments, along
+// with the pools they belong to.
+func (s *Subgraph) events(kind string, args eventArgs) ([]Event, map[common.Address]*Pool, error) {
+
+	err := checkPage(args.Skip, args.First)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := EventFilter{
+		Kind:       kind,
+		Descending: descending(args.OrderDirection),
+		Offset:     int(args.Skip),
+		Limit:      int(args.First),
+	}
+	if args.Where != nil {
+		if args.Where.Pair != nil {
+			filter.Pairs = append(filter.Pairs, common.HexToAddress(*args.Where.Pair))
+		}
+		if args.Where.PairIn != nil {
+			for _, pair := range *args.Where.PairIn {
+				filter.Pairs = append(filter.Pairs, common.HexToAddress(pair))
+			}
+		}
+		if args.Where.TimestampGt != nil {
+			filter.Start = time.Unix(args.Where.TimestampGt.Int64()+1, 0)
+		}
+		if args.Where.TimestampGte != nil {
+			filter.Start = time.Unix(args.Where.TimestampGte.Int64(), 0)
+		}
+		if args.Where.TimestampLt != nil {
+			filter.End = time.Unix(args.Where.TimestampLt.Int64(), 0)
+		}
+		if args.Where.TimestampLte != nil {
+			filter.End = time.Unix(args.Where.TimestampLte.Int64()+1, 0)
+		}
+	}
+	if filter.Start.IsZero() {
+		filter.Start = time.Unix(0, 0)
+	}
+
+	events, err := s.store.Events(s.chainName, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stored, err := s.store.Pairs(s.chainName)
+	if err != nil {
+		return nil, nil, err
+	}
+	pools := make(map[common.Address]*Pool, len(stored))
+	for _, pool := range stored {
+		pools[pool.Address] = pool
+	}
+	for _, event := range events {
+		_, ok := pools[event.Pair]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown pair of event (%s)", event.Pair.Hex())
+		}
+	}
+
+	return events, pools, nil
+}
+
+type tokenResolver struct {
+	ID       graphql.ID
+	Symbol   string
+	Decimals BigInt
+}
+
+func newTokenResolver(token Token) *tokenResolver {
+	return &tokenResolver{
+		ID:       graphql.ID(strings.ToLower(token.Address.Hex())),
+		Symbol:   token.Symbol,
+		Decimals: BigInt(strconv.FormatUint(uint64(token.Decimals), 10)),
+	}
+}
+
+// pairResolver resolves the fields of a pair, reading its last datapoint and
+// its totals only when they are requested.
+type pairResolver struct {
+	subgraph *Subgraph
+	pool     *Pool
+
+	latestOnce sync.Once
+	latest     Datapoint
+	latestErr  error
+
+	totalsOnce   sync.Once
+	volume0      *big.Int
+	volume1      *big.Int
+	transactions uint64
+	totalsErr    error
+}
+
+func (s *Subgraph) newPairResolver(pool *Pool) *pairResolver {
+	return &pairResolver{
+		subgraph: s,
+		pool:     pool,
+	}
+}
+
+func (p *pairResolver) ID() graphql.ID {
+	return graphql.ID(strings.ToLower(p.pool.Address.Hex()))
+}
+
+func (p *pairResolver) Token0() *tokenResolver {
+	return newTokenResolver(p.pool.Token0)
+}
+
+func (p *pairResolver) Token1() *tokenResolver {
+	return newTokenResolver(p.pool.Token1)
+}
+
+func (p *pairResolver) Reserve0() (BigDecimal, error) {
+	point, err := p.last()
+	if err != nil {
+		return "", err
+	}
+	return BigDecimal(formatAmount(point.Reserve0, p.pool.Token0.Decimals)), nil
+}
+
+func (p *pairResolver) Reserve1() (BigDecimal, error) {
+	point, err := p.last()
+	if err != nil {
+		return "", err
+	}
+	return BigDecimal(formatAmount(point.Reserve1, p.pool.Token1.Decimals)), nil
+}
+
+// Token0Price is the price of token0 in units of token1 as defined by the
+// subgraph, which is the amount of token0 per token1.
+func (p *pairResolver) Token0Price() (BigDecimal, error) {
+	point, err := p.last()
+	if err != nil {
+		return "", err
+	}
+	price := spotPrice(point.Reserve1, point.Reserve0, p.pool.Token1.Decimals, p.pool.Token0.Decimals)
+	return BigDecimal(strconv.FormatFloat(price, 'f', -1, 64)), nil
+}
+
+// Token1Price is the amount of token1 per token0.
+func (p *pairResolver) Token1Price() (BigDecimal, error) {
+	point, err := p.last()
+	if err != nil {
+		return "", err
+	}
+	price := spotPrice(point.Reserve0, point.Reserve1, p.pool.Token0.Decimals, p.pool.Token1.Decimals)
+	return BigDecimal(strconv.FormatFloat(price, 'f', -1, 64)), nil
+}
+
+func (p *pairResolver) VolumeToken0() (BigDecimal, error) {
+	err := p.totals()
+	if err != nil {
+		return "", err
+	}
+	return BigDecimal(formatAmount(p.volume0, p.pool.Token0.Decimals)), nil
+}
+
+func (p *pairResolver) VolumeToken1() (BigDecimal, error) {
+	err := p.totals()
+	if err != nil {
+		return "", err
+	}
+	return BigDecimal(formatAmount(p.volume1, p.pool.Token1.Decimals)), nil
+}
+
+func (p *pairResolver) TxCount() (BigInt, error) {
+	err := p.totals()
+	if err != nil {
+		return "", err
+	}
+	return BigInt(strconv.FormatUint(p.transactions, 10)), nil
+}
+
+// last returns the last datapoint of the pair, with zero reserves if there is
+// none yet.
+func (p *pairResolver) last() (Datapoint, error) {
+	p.latestOnce.Do(func() {
+		var ok bool
+		p.latest, ok, p.latestErr = p.subgraph.store.Latest(p.subgraph.chainName, p.pool.Address)
+		if p.latestErr == nil && !ok {
+			p.latest = Datapoint{Reserve0: big.NewInt(0), Reserve1: big.NewInt(0)}
+		}
+	})
+	return p.latest, p.latestErr
+}
+
+func (p *pairResolver) totals() error {
+	p.totalsOnce.Do(func() {
+		p.volume0, p.volume1, p.transactions, p.totalsErr = p.subgraph.store.Totals(p.subgraph.chainName, p.pool.Address)
+	})
+	return p.totalsErr
+}
+
+type transactionResolver struct {
+	ID          graphql.ID
+	BlockNumber BigInt
+	Timestamp   BigInt
+}
+
+// eventResolver resolves the fields common to swaps, mints and burns.
+type eventResolver struct {
+	ID          graphql.ID
+	Transaction *transactionResolver
+	Timestamp   BigInt
+	Pair        *pairResolver
+	LogIndex    *BigInt
+}
+
+func (s *Subgraph) newEventResolver(event Event, pool *Pool) eventResolver {
+	timestamp := BigInt(strconv.FormatInt(event.Timestamp.Unix(), 10))
+	index := BigInt(strconv.FormatUint(uint64(event.LogIndex), 10))
+	return eventResolver{
+		ID: graphql.ID(fmt.Sprintf("%s-%d", event.TxHash.Hex(), event.LogIndex)),
+		Transaction: &transactionResolver{
+			ID:          graphql.ID(event.TxHash.Hex()),
+			BlockNumber: BigInt(strconv.FormatUint(event.Height, 10)),
+			Timestamp:   timestamp,
+		},
+		Timestamp: timestamp,
+		Pair:      s.newPairResolver(pool),
+		LogIndex:  &index,
+	}
+}
+
+type swapResolver struct {
+	eventResolver
+	Amount0In  BigDecimal
+	Amount1In  BigDecimal
+	Amount0Out BigDecimal
+	Amount1Out BigDecimal
+}
+
+type liquidityResolver struct {
+	eventResolver
+	Amount0 *BigDecimal
+	Amount1 *BigDecimal
+}
+
+type dayResolver struct {
+	ID                graphql.ID
+	Date              int32
+	PairAddress       Bytes
+	Token0            *tokenResolver
+	Token1            *tokenResolver
+	Reserve0          BigDecimal
+	Reserve1          BigDecimal
+	DailyVolumeToken0 BigDecimal
+	DailyVolumeToken1 BigDecimal
+	DailyTxns         BigInt
+}
+
+// newDayResolver identifies the day by the pair address and the number of the
+// day since the epoch, as the subgraph does.
+func newDayResolver(day Day, pool *Pool) *dayResolver {
+	address := strings.ToLower(pool.Address.Hex())
+	number := day.Date.Unix() / 86400
+	return &dayResolver{
+		ID:                graphql.ID(fmt.Sprintf("%s-%d", address, number)),
+		Date:              int32(day.Date.Unix()),
+		PairAddress:       Bytes(address),
+		Token0:            newTokenResolver(pool.Token0),
+		Token1:            newTokenResolver(pool.Token1),
+		Reserve0:          BigDecimal(formatAmount(day.Reserve0, pool.Token0.Decimals)),
+		Reserve1:          BigDecimal(formatAmount(day.Reserve1, pool.Token1.Decimals)),
+		DailyVolumeToken0: BigDecimal(formatAmount(day.Volume0, pool.Token0.Decimals)),
+		DailyVolumeToken1: BigDecimal(formatAmount(day.Volume1, pool.Token1.Decimals)),
+		DailyTxns:         BigInt(strconv.FormatUint(day.Transactions, 10)),
+	}
+}
+
+// checkPage validates the pagination arguments against the limits of the
+// subgraph.
+func checkPage(skip int32, first int32) error {
+	if skip < 0 {
+		return fmt.Errorf("skip must not be negative")
+	}
+	if first < 0 || first > maxEntities {
+		return fmt.Errorf("first must be between 0 and %d", maxEntities)
+	}
+	return nil
+}
+
+// pageBounds returns the bounds of the page selected by the pagination
+// arguments within a list of the given length.
+func pageBounds(count int, skip int32, first int32) (int, int) {
+	start := int(skip)
+	if start > count {
+		start = count
+	}
+	end := start + int(first)
+	if end > count {
+		end = count
+	}
+	return start, end
+}
+
+func descending(direction *string) bool {
+	return direction != nil && *direction == "desc"
+}
+
+func containsID(ids []graphql.ID, id graphql.ID) bool {
+	for _, candidate := range ids {
+		if graphql.ID(strings.ToLower(string(candidate))) == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Token is the metadata of an ERC20 token. The flags are set when the miner
+// detects that the token does not behave like a regular ERC20 token.
+type Token struct {
+	Address       common.Address
+	Symbol        string
+	Decimals      uint8
+	FeeOnTransfer bool
+	Rebasing      bool
+}
+
+func fetchToken(caller bind.ContractCaller, address common.Address) (Token, error) {
+
+	token, err := NewERC20Caller(address, caller)
+	if err != nil {
+		return Token{}, fmt.Errorf("could not bind token contract: %w", err)
+	}
+
+	symbol, err := token.Symbol(nil)
+	if err != nil {
+		return Token{}, fmt.Errorf("could not get token symbol: %w", err)
+	}
+
+	decimals, err := token.Decimals(nil)
+	if err != nil {
+		return Token{}, fmt.Errorf("could not get token decimals: %w", err)
+	}
+
+	return Token{
+		Address:  address,
+		Symbol:   symbol,
+		Decimals: decimals,
+	}, nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// trackPosition reports the value, fees, impermanent loss and PnL over time of
+// a liquidity position in a pair, either for the actual position of a holder
+// or for a hypothetical deposit at a given height. The miner does not store the
+// supply of liquidity tokens nor their transfers, so the state of the pair is
+// read from the node at every snapshot height, which requires an archive node
+// unless all heights are recent.
+func trackPosition(args []string) {
+
+	var (
+		logLevel  string
+		batchSize uint64
+
+		pairAddress string
+		holder      string
+		startHeight uint64
+		endHeight   uint64
+		entryHeight uint64
+		amount      float64
+		interval    uint64
+
+		apiURL string
+	)
+
+	flags := pflag.NewFlagSet("position", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+	flags.Uint64VarP(&batchSize, "batch-size", "b", 100000, "number of blocks to cover per request for liquidity token transfers")
+
+	flags.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL of an archive node, as the pair state is read at every snapshot height")
+	flags.StringVarP(&pairAddress, "pair-address", "p", "0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc", "Ethereum address for Uniswap v2 pair")
+	flags.StringVar(&holder, "holder", "", "address of the liquidity token holder whose position to reconstruct")
+	flags.Uint64VarP(&startHeight, "start-height", "s", 10000835, "start height for searching liquidity token transfers of the holder")
+	flags.Uint64VarP(&endHeight, "end-height", "e", 0, "end height for the position report (defaults to the last block)")
+	flags.Uint64Var(&entryHeight, "entry-height", 0, "entry height of a hypothetical position, instead of a holder")
+	flags.Float64Var(&amount, "amount", 0, "value of the hypothetical deposit in units of the second token, split evenly between both tokens")
+	flags.Uint64Var(&interval, "interval", 6500, "number of blocks between two snapshots of the position")
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	if (holder == "") == (entryHeight == 0) {
+		log.Fatal().Msg("need exactly one of holder or entry height")
+	}
+	if entryHeight != 0 && amount <= 0 {
+		log.Fatal().Float64("amount", amount).Msg("need positive amount for hypothetical position")
+	}
+	if interval == 0 {
+		log.Fatal().Msg("need positive snapshot interval")
+	}
+
+	client, err := ethclient.Dial(apiURL)
+	if err != nil {
+		log.Fatal().Str("api_url", apiURL).Err(err).Msg("could not connect to JSON RPC API")
+	}
+
+	if endHeight == 0 {
+		endHeight, err = client.BlockNumber(context.Background())
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not get last block height")
+		}
+	}
+
+	address := common.HexToAddress(pairAddress)
+	pool, err := fetchPool(client, address)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not get pair metadata")
+	}
+
+	log = log.With().Str("pair_name", pool.Name).Logger()
+
+	// The transfers are keyed by height, with the net change of the balance of
+	// the holder for that block.
+	transfers := make(map[uint64]*big.Int)
+	if entryHeight != 0 {
+
+		state, err := fetchPoolState(client, address, entryHeight)
+		if err != nil {
+			log.Fatal().Uint64("height", entryHeight).Err(err).Msg("could not get pair state")
+		}
+		if state.Reserve1.Sign() == 0 {
+			log.Fatal().Uint64("height", entryHeight).Msg("pair has no reserves at entry height")
+		}
+
+		// Half of the deposited value goes into each token, so the liquidity
+		// received is the supply times the share of the second reserve.
+		deposit := new(big.Float).Mul(big.NewFloat(amount/2), pow10(pool.Token1.Decimals))
+		liquidity := new(big.Float).Mul(deposit, new(big.Float).SetInt(state.Supply))
+		liquidity.Quo(liquidity, new(big.Float).SetInt(state.Reserve1))
+		transfers[entryHeight], _ = liquidity.Int(nil)
+
+	} else {
+
+		filterer, err := NewPairFilterer(address, client)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not bind pair filterer")
+		}
+
+		account := common.HexToAddress(holder)
+		for from := startHeight; from <= endHeight; from += batchSize {
+
+			to := from + batchSize - 1
+			if to > endHeight {
+				to = endHeight
+			}
+
+			opts := bind.FilterOpts{
+				Start:   from,
+				End:     &to,
+				Context: context.Background(),
+			}
+
+			outgoing, err := filterer.FilterTransfer(&opts, []common.Address{account}, nil)
+			if err != nil {
+				log.Fatal().Uint64("from", from).Uint64("to", to).Err(err).Msg("could not filter outgoing transfers")
+			}
+			for outgoing.Next() {
+				event := outgoing.Event
+				if event.To == account {
+					continue
+				}
+				delta, ok := transfers[event.Raw.BlockNumber]
+				if !ok {
+					delta = big.NewInt(0)
+					transfers[event.Raw.BlockNumber] = delta
+				}
+				delta.Sub(delta, event.Value)
+			}
+			err = outgoing.Error()
+			if err != nil {
+				log.Fatal().Uint64("from", from).Uint64("to", to).Err(err).Msg("could not iterate outgoing transfers")
+			}
+
+			incoming, err := filterer.FilterTransfer(&opts, nil, []common.Address{account})
+			if err != nil {
+				log.Fatal().Uint64("from", from).Uint64("to", to).Err(err).Msg("could not filter incoming transfers")
+			}
+			for incoming.Next() {
+				event := incoming.Event
+				if event.From == account {
+					continue
+				}
+				delta, ok := transfers[event.Raw.BlockNumber]
+				if !ok {
+					delta = big.NewInt(0)
+					transfers[event.Raw.BlockNumber] = delta
+				}
+				delta.Add(delta, event.Value)
+			}
+			err = incoming.Error()
+			if err != nil {
+				log.Fatal().Uint64("from", from).Uint64("to", to).Err(err).Msg("could not iterate incoming transfers")
+			}
+
+			log.Debug().Uint64("from", from).Uint64("to", to).Int("transfers", len(transfers)).Msg("searched liquidity token transfers")
+		}
+
+		if len(transfers) == 0 {
+			log.Fatal().Str("holder", holder).Msg("no liquidity token transfers found for holder")
+		}
+	}
+
+	heights := make([]uint64, 0, len(transfers))
+	for height := range transfers {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i int, j int) bool {
+		return heights[i] < heights[j]
+	})
+
+	// Snapshots are taken at regular intervals from the first transfer onwards,
+	// as well as at every height where the balance changes.
+	samples := make(map[uint64]struct{})
+	for _, height := range heights {
+		samples[height] = struct{}{}
+	}
+	for height := heights[0]; height < endHeight; height += interval {
+		samples[height] = struct{}{}
+	}
+	samples[endHeight] = struct{}{}
+
+	heights = heights[:0]
+	for height := range samples {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i int, j int) bool {
+		return heights[i] < heights[j]
+	})
+
+	position := NewPosition(pool)
+	var snapshot PositionSnapshot
+	for _, height := range heights {
+
+		state, err := fetchPoolState(client, address, height)
+		if err != nil {
+			log.Fatal().Uint64("height", height).Err(err).Msg("could not get pair state")
+		}
+
+		header, err := client.HeaderByNumber(context.Background(), new(big.Int).SetUint64(height))
+		if err != nil {
+			log.Fatal().Uint64("height", height).Err(err).Msg("could not get header for height")
+		}
+		state.Timestamp = time.Unix(int64(header.Time), 0).UTC()
+
+		delta, ok := transfers[height]
+		if ok {
+			position.Transfer(delta, state)
+		}
+
+		snapshot = position.Snapshot(state)
+
+		log.Info().
+			Uint64("height", snapshot.Height).
+			Time("timestamp", snapshot.Timestamp).
+			Str("balance", snapshot.Balance.String()).
+			Float64("amount0", snapshot.Amount0).
+			Float64("amount1", snapshot.Amount1).
+			Float64("value", snapshot.Value).
+			Float64("hold", snapshot.Hold).
+			Float64("fees", snapshot.Fees).
+			Float64("impermanent_loss", snapshot.ImpermanentLoss).
+			Float64("pnl", snapshot.PnL).
+			Msg("position snapshot")
+	}
+
+	log.Info().
+		Str("value_unit", pool.Token1.Symbol).
+		Float64("value", snapshot.Value).
+		Float64("fees", snapshot.Fees).
+		Float64("impermanent_loss", snapshot.ImpermanentLoss).
+		Float64("pnl", snapshot.PnL).
+		Msg("position report completed")
+
+	os.Exit(0)
+}
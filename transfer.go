@@ -0,0 +1,226 @@
+package main
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TransferCheck reconciles the token transfers from and to the tracked pools
+// with the reserves reported by their sync events. For regular tokens, the
+// reserves change by exactly the amounts transferred since the previous sync.
+// Tokens that deduct a fee on transfer while reporting the gross amount leave
+// the pool with less than transferred, while rebasing tokens change the
+// balance of the pool without any transfer at all.
+type TransferCheck struct {
+	pools       map[common.Address]*Pool
+	pending     map[common.Address]*[2]*big.Int
+	transfers   map[common.Address]*[2]uint
+	unexplained map[common.Address]*[2]*big.Int
+}
+
+func NewTransferCheck(pools []*Pool) *TransferCheck {
+
+	t := TransferCheck{
+		pools:       make(map[common.Address]*Pool),
+		pending:     make(map[common.Address]*[2]*big.Int),
+		transfers:   make(map[common.Address]*[2]uint),
+		unexplained: make(map[common.Address]*[2]*big.Int),
+	}
+	for _, pool := range pools {
+		t.pools[pool.Address] = pool
+		t.pending[pool.Address] = &[2]*big.Int{big.NewInt(0), big.NewInt(0)}
+		t.transfers[pool.Address] = &[2]uint{}
+		t.unexplained[pool.Address] = &[2]*big.Int{big.NewInt(0), big.NewInt(0)}
+	}
+
+	return &t
+}
+
+// Transfer applies a token transfer log entry from or to one of the pools.
+func (t *TransferCheck) Transfer(entry types.Log) {
+
+	if len(entry.Topics) < 3 {
+		return
+	}
+
+	from := common.BytesToAddress(entry.Topics[1].Bytes())
+	to := common.BytesToAddress(entry.Topics[2].Bytes())
+	value := new(big.Int).SetBytes(entry.Data)
+
+	for _, address := range []common.Address{from, to} {
+
+		pool, ok := t.pools[address]
+		if !ok {
+			continue
+		}
+
+		side := -1
+		switch entry.Address {
+		case pool.Token0.Address:
+			side = 0
+		case pool.Token1.Address:
+			side = 1
+		}
+		if side < 0 {
+			continue
+		}
+
+		pending := t.pending[pool.Address]
+		if address == to {
+			pending[side].Add(pending[side], value)
+		} else {
+			pending[side].Sub(pending[side], value)
+		}
+		t.transfers[pool.Address][side]++
+	}
+}
+
+// Sync reconciles the new reserves of a pool with its previous reserves and
+// the transfers since then, and returns the tokens that were newly flagged.
+// It has to be called before the reserves of the pool are updated.
+func (t *TransferCheck) Sync(pool *Pool, reserve0 *big.Int, reserve1 *big.Int) []Token {
+
+	pending, ok := t.pending[pool.Address]
+	if !ok {
+		return nil
+	}
+	transfers := t.transfers[pool.Address]
+	defer func() {
+		pending[0].SetUint64(0)
+		pending[1].SetUint64(0)
+		transfers[0] = 0
+		transfers[1] = 0
+	}()
+
+	if pool.Reserve0 == nil || pool.Reserve1 == nil {
+		return nil
+	}
+
+	var flagged []Token
+	sides := []struct {
+		before *big.Int
+		after  *big.Int
+		token  *Token
+	}{
+		{pool.Reserve0, reserve0, &pool.Token0},
+		{pool.Reserve1, reserve1, &pool.Token1},
+	}
+	for i, side := range sides {
+
+		mismatch := new(big.Int).Sub(side.after, side.before)
+		mismatch.Sub(mismatch, pending[i])
+		if mismatch.Sign() == 0 {
+			continue
+		}
+
+		unexplained := t.unexplained[pool.Address]
+		unexplained[i].Add(unexplained[i], mismatch)
+
+		// A pool receiving less than the transfers reported points to a fee
+		// on transfer; any other mismatch means the balance changed by itself.
+		feeOnTransfer := transfers[i] > 0 && mismatch.Sign() < 0
+		if t.flag(side.token.Address, feeOnTransfer) {
+			flagged = append(flagged, *side.token)
+		}
+	}
+
+	return flagged
+}
+
+// Balances reconciles the actual token balances of a pool with its reserves
+// and the transfers since its last sync, which detects rebases even for pools
+// that see no sync events. It returns the tokens that were newly flagged.
+func (t *TransferCheck) Balances(pool *Pool, balance0 *big.Int, balance1 *big.Int) []Token {
+
+	if pool.Reserve0 == nil || pool.Reserve1 == nil {
+		return nil
+	}
+
+	pending := t.pending[pool.Address]
+	var flagged []Token
+	sides := []struct {
+		balance *big.Int
+		reserve *big.Int
+		token   *Token
+	}{
+		{balance0, pool.Reserve0, &pool.Token0},
+		{balance1, pool.Reserve1, &pool.Token1},
+	}
+	for i, side := range sides {
+		drift := new(big.Int).Sub(side.balance, side.reserve)
+		drift.Sub(drift, pending[i])
+		if drift.Sign() == 0 {
+			continue
+		}
+		if t.flag(side.token.Address, false) {
+			flagged = append(flagged, *side.token)
+		}
+	}
+
+	return flagged
+}
+
+// Unexplained returns the changes in the reserves of a pool that were not
+// explained by transfers since the last reset, and resets them.
+func (t *TransferCheck) Unexplained(pool *Pool) (*big.Int, *big.Int) {
+	unexplained := t.unexplained[pool.Address]
+	amount0, amount1 := unexplained[0], unexplained[1]
+	unexplained[0], unexplained[1] = big.NewInt(0), big.NewInt(0)
+	return amount0, amount1
+}
+
+// Reset discards the unexplained changes of all pools, so that they only cover
+// the current range, even when they are not written with the datapoints.
+func (t *TransferCheck) Reset() {
+	for _, unexplained := range t.unexplained {
+		unexplained[0], unexplained[1] = big.NewInt(0), big.NewInt(0)
+	}
+}
+
+// clone returns a copy of the check whose pending transfers and unexplained
+// changes can change independently; the pools are shared.
+func (t *TransferCheck) clone() *TransferCheck {
+	clone := TransferCheck{
+		pools:       t.pools,
+		pending:     make(map[common.Address]*[2]*big.Int, len(t.pending)),
+		transfers:   make(map[common.Address]*[2]uint, len(t.transfers)),
+		unexplained: make(map[common.Address]*[2]*big.Int, len(t.unexplained)),
+	}
+	for address, pending := range t.pending {
+		clone.pending[address] = &[2]*big.Int{new(big.Int).Set(pending[0]), new(big.Int).Set(pending[1])}
+	}
+	for address, transfers := range t.transfers {
+		copied := *transfers
+		clone.transfers[address] = &copied
+	}
+	for address, unexplained := range t.unexplained {
+		clone.unexplained[address] = &[2]*big.Int{new(big.Int).Set(unexplained[0]), new(big.Int).Set(unexplained[1])}
+	}
+	return &clone
+}
+
+// flag marks the given token on all pools that contain it, and returns whether
+// the token was not flagged that way before.
+func (t *TransferCheck) flag(address common.Address, feeOnTransfer bool) bool {
+
+	updated := false
+	for _, pool := range t.pools {
+		for _, token := range []*Token{&pool.Token0, &pool.Token1} {
+			if token.Address != address {
+				continue
+			}
+			if feeOnTransfer && !token.FeeOnTransfer {
+				token.FeeOnTransfer = true
+				updated = true
+			}
+			if !feeOnTransfer && !token.Rebasing {
+				token.Rebasing = true
+				updated = true
+			}
+		}
+	}
+
+	return updated
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"math/big"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// verifyReserves samples the datapoints stored for a set of pairs and compares
+// their reserves with those returned by the pair contracts at the same heights,
+// which requires an archive node. The datapoints are read from the SQLite store
+// when it is one of the sinks, and from InfluxDB otherwise.
+func verifyReserves(args []string) {
+
+	var (
+		logLevel string
+
+		pairAddresses []string
+		startTime     string
+		endTime       string
+		samples       int
+		seed          int64
+
+		apiURL string
+
+		sinks SinkOptions
+	)
+
+	flags := pflag.NewFlagSet("verify", pflag.ExitOnError)
+
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Zerolog logger minimum severity level")
+
+	flags.StringVarP(&apiURL, "api-url", "a", "", "JSON RPC API URL of an archive node")
+	flags.StringSliceVarP(&pairAddresses, "pair-addresses", "p", []string{"0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc"}, "Ethereum addresses for Uniswap v2 pairs")
+	flags.StringVar(&startTime, "start-time", "2020-05-05T00:00:00Z", "start time of the datapoints to verify (RFC3339)")
+	flags.StringVar(&endTime, "end-time", "", "end time of the datapoints to verify (RFC3339, defaults to now)")
+	flags.IntVarP(&samples, "samples", "n", 100, "number of datapoints to verify per pair")
+	flags.Int64Var(&seed, "seed", 0, "seed for sampling the datapoints (defaults to the current time)")
+
+	bindSinkFlags(flags, &sinks)
+
+	_ = flags.Parse(args)
+
+	log := newLogger(logLevel)
+
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		log.Fatal().Str("start_time", startTime).Err(err).Msg("invalid start time")
+	}
+	end := time.Now().UTC()
+	if endTime != "" {
+		end, err = time.Parse(time.RFC3339, endTime)
+		if err != nil {
+			log.Fatal().Str("end_time", endTime).Err(err).Msg("invalid end time")
+		}
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	random := rand.New(rand.NewSource(seed))
+
+	client, chainName := connectChain(log, apiURL)
+
+	// Only the sink that is read from is opened, as nothing is written.
+	var store *Store
+	for _, name := range sinks.Names {
+		if name != "sqlite" {
+			continue
+		}
+		store, err = OpenStore(sinks.SQLitePath)
+		if err != nil {
+			log.Fatal().Str("sqlite_path", sinks.SQLitePath).Err(err).Msg("could not open SQLite database")
+		}
+	}
+	source, err := openSource(sinks, store)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not connect to InfluxDB API")
+	}
+
+	log = log.With().
+		Str("bucket", sinks.InfluxBucket).
+		Str("measurement", measurement).
+		Str("chain_name", chainName).
+		Int64("seed", seed).
+		Logger()
+
+	verified := 0
+	mismatched := 0
+	for _, pairAddress := range pairAddresses {
+
+		address := common.HexToAddress(pairAddress)
+		pool, err := fetchPool(client, address)
+		if err != nil {
+			log.Fatal().Str("pair_address", pairAddress).Err(err).Msg("could not get pair metadata")
+		}
+
+		pairContract, err := NewPairCaller(address, client)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not bind pair contract")
+		}
+
+		// Reservoir sampling keeps a uniform sample of the datapoints without
+		// holding the whole series in memory.
+		seen := 0
+		var sample []Datapoint
+		err = source.Datapoints(chainName, pool, start, end, 0, func(point Datapoint) error {
+			if point.Height == 0 {
+				return nil
+			}
+			seen++
+			if len(sample) < samples {
+				sample = append(sample, point)
+				return nil
+			}
+			index := random.Intn(seen)
+			if index < samples {
+				sample[index] = point
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatal().Str("pair_name", pool.Name).Err(err).Msg("could not query datapoints")
+		}
+
+		sort.Slice(sample, func(i int, j int) bool {
+			return sample[i].Height < sample[j].Height
+		})
+
+		log.Info().Str("pair_name", pool.Name).Int("datapoints", seen).Int("samples", len(sample)).Msg("sampled datapoints for verification")
+
+		for _, point := range sample {
+
+			opts := bind.CallOpts{
+				BlockNumber: new(big.Int).SetUint64(point.Height),
+			}
+			reserves, err := pairContract.GetReserves(&opts)
+			if err != nil {
+				log.Fatal().Str("pair_name", pool.Name).Uint64("height", point.Height).Err(err).Msg("could not get reserves at height")
+			}
+
+			verified++
+
+			delta0 := new(big.Int).Sub(point.Reserve0, reserves.Reserve0)
+			delta1 := new(big.Int).Sub(point.Reserve1, reserves.Reserve1)
+			if delta0.Sign() == 0 && delta1.Sign() == 0 {
+				log.Debug().Str("pair_name", pool.Name).Uint64("height", point.Height).Msg("reserves verified")
+				continue
+			}
+
+			mismatched++
+
+			log.Warn().
+				Str("pair_name", pool.Name).
+				Uint64("height", point.Height).
+				Time("timestamp", point.Timestamp).
+				Str("stored0", point.Reserve0.String()).
+				Str("stored1", point.Reserve1.String()).
+				Str("actual0", reserves.Reserve0.String()).
+				Str("actual1", reserves.Reserve1.String()).
+				Str("delta0", delta0.String()).
+				Str("delta1", delta1.String()).
+				Msg("stored reserves do not match on-chain reserves")
+		}
+	}
+
+	log.Info().Int("verified", verified).Int("mismatched", mismatched).Msg("verification of stored reserves completed")
+
+	if mismatched > 0 {
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}